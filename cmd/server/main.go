@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"guilliman/cmd/auth"
 	"guilliman/config"
+	"guilliman/internal/jobs"
 	"guilliman/internal/models"
 	"guilliman/internal/routes"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // Time zones for user settings, the runtime image has no tzdata
 )
 
 // @title           Guilliman API
@@ -48,11 +51,36 @@ func main() {
 		log.Fatalf("Failed to create tables: %v", err)
 	}
 
+	if err := models.RunMigrations(); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	if err := models.InitializeBlobStore(); err != nil {
+		log.Fatalf("Failed to initialize attachment storage: %v", err)
+	}
+
+	if err := models.InitializePriceProvider(); err != nil {
+		log.Fatalf("Failed to initialize price provider: %v", err)
+	}
+
 	// Seed the database with initial categories
 	if err := models.SeedCategories(); err != nil {
 		log.Fatalf("Failed to seed categories: %v", err)
 	}
 
+	// Pending transactions the bank never settled are voided after the
+	// number of days of the user's settings
+	jobs.Every("expire pending transactions", time.Hour, models.ExpirePendingTransactions)
+	// The journal and the stored balances are checked every night
+	jobs.Daily("check ledger", 3*time.Hour, models.CheckLedger)
+	// Deleted transactions are purged once past the user's trash retention
+	jobs.Daily("purge trash", 4*time.Hour, models.PurgeTrash)
+	// Credit card statements are closed hourly, as cycles end at midnight
+	// in the time zone of each user
+	jobs.Every("generate card statements", time.Hour, models.GenerateStatements)
+	// Idempotency keys are forgotten once past their TTL
+	jobs.Every("purge idempotency keys", time.Hour, models.PurgeIdempotencyKeys)
+
 	// Init Firebase
 	err := auth.InitFirebase()
 	if err != nil {
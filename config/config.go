@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -13,6 +14,19 @@ type AppConfig struct {
 	ExchangeRateKey string
 	SecretKey       string
 	Env             string
+
+	StorageDriver string // "local" or "s3"
+	StoragePath   string
+	S3Endpoint    string
+	S3Region      string
+	S3Bucket      string
+	S3AccessKey   string
+	S3SecretKey   string
+
+	PriceProvider    string // "manual" or "stooq"
+	PriceProviderURL string
+
+	IdempotencyKeyTTL time.Duration // How long responses are replayed for an Idempotency-Key
 }
 
 var Config AppConfig
@@ -28,6 +42,24 @@ func Load() {
 	Config.SqlDb = getEnv("SQL_URL", "")
 	Config.SecretKey = getEnv("SECRET_KEY", "")
 	Config.Env = getEnv("ENV", "debug")
+
+	Config.StorageDriver = getEnv("STORAGE_DRIVER", "local")
+	Config.StoragePath = getEnv("STORAGE_PATH", "./data/attachments")
+	Config.S3Endpoint = getEnv("S3_ENDPOINT", "")
+	Config.S3Region = getEnv("S3_REGION", "us-east-1")
+	Config.S3Bucket = getEnv("S3_BUCKET", "")
+	Config.S3AccessKey = getEnv("S3_ACCESS_KEY", "")
+	Config.S3SecretKey = getEnv("S3_SECRET_KEY", "")
+
+	Config.PriceProvider = getEnv("PRICE_PROVIDER", "manual")
+	Config.PriceProviderURL = getEnv("PRICE_PROVIDER_URL", "")
+
+	ttl, err := time.ParseDuration(getEnv("IDEMPOTENCY_KEY_TTL", "24h"))
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid IDEMPOTENCY_KEY_TTL, keeping idempotency keys for 24h")
+		ttl = 24 * time.Hour
+	}
+	Config.IdempotencyKeyTTL = ttl
 }
 
 func GetServerPort() string {
@@ -50,6 +82,46 @@ func GetSecretKey() string {
 	return Config.SecretKey
 }
 
+func GetStorageDriver() string {
+	return Config.StorageDriver
+}
+
+func GetStoragePath() string {
+	return Config.StoragePath
+}
+
+func GetS3Endpoint() string {
+	return Config.S3Endpoint
+}
+
+func GetS3Region() string {
+	return Config.S3Region
+}
+
+func GetS3Bucket() string {
+	return Config.S3Bucket
+}
+
+func GetS3AccessKey() string {
+	return Config.S3AccessKey
+}
+
+func GetS3SecretKey() string {
+	return Config.S3SecretKey
+}
+
+func GetPriceProvider() string {
+	return Config.PriceProvider
+}
+
+func GetPriceProviderURL() string {
+	return Config.PriceProviderURL
+}
+
+func GetIdempotencyKeyTTL() time.Duration {
+	return Config.IdempotencyKeyTTL
+}
+
 func getEnv(key, defaultVal string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
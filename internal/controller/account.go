@@ -1,14 +1,28 @@
 package controller
 
 import (
+	"errors"
 	"guilliman/internal/models"
 	"guilliman/internal/utils"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/guregu/null/v5"
 )
 
+// updateAccountRequest is an account update. A balance is reached by posting
+// an adjustment transaction for the difference.
+type updateAccountRequest struct {
+	models.Account
+	Balance null.Float `json:"balance"`
+}
+
+// purgeAccountRequest confirms a purge with the name of the account
+type purgeAccountRequest struct {
+	Confirm string `json:"confirm" binding:"required"`
+}
+
 // GetAccounts godoc
 // @Summary      Get accounts
 // @Description  get accounts
@@ -22,6 +36,28 @@ import (
 // @Failure      500  {object}  httputil.HTTPError
 // @Router       /accounts/{id} [get]
 
+// accountError answers access errors on accounts, it returns false for
+// other errors
+func accountError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidCredit), errors.Is(err, models.ErrInvalidLoan),
+		errors.Is(err, models.ErrInvalidInvestment), errors.Is(err, models.ErrInvalidReconciliation),
+		errors.Is(err, models.ErrPurgeNotConfirmed):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrReconciled), errors.Is(err, models.ErrAccountArchived), errors.Is(err, models.ErrAccountNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrVersionMismatch):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *Controller) GetAccountsController(c *gin.Context) {
 	uid, err := utils.GetUserUID(c)
 	if err != nil {
@@ -31,7 +67,9 @@ func (h *Controller) GetAccountsController(c *gin.Context) {
 
 	accountId := c.Param("id")
 
-	accounts, err := models.GetAccounts(accountId, uid) // Fetch accounts from storage
+	includeArchived := c.Query("include_archived") == "true"
+
+	accounts, err := models.GetAccounts(accountId, includeArchived, uid) // Fetch accounts from storage
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -54,13 +92,17 @@ func (h *Controller) AddAccountController(c *gin.Context) {
 
 	newAccount.UserID = uid
 
-	account, err := models.AddAccount(newAccount) // Add account to storage
+	account, err := models.AddAccount(newAccount, actor(c, uid)) // Add account to storage
 	if err != nil {
+		if accountError(c, err) {
+			return
+		}
 		// You can log the error or return it, depending on your application's needs
 		log.Printf("Error adding account: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add acccount"})
 		return
 	}
+	setETag(c, account.Version)
 	c.JSON(http.StatusCreated, account)
 }
 
@@ -71,22 +113,32 @@ func (h *Controller) UpdateAccountController(c *gin.Context) {
 		return
 	}
 
-	var newAccount models.Account
-	if err := c.ShouldBindJSON(&newAccount); err != nil {
+	var request updateAccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	newAccount.UserID = uid
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 
-	account, err := models.UpdateAccount(newAccount) // Add account to storage
+	request.Account.ID = c.Param("id")
+	request.Account.UserID = uid
+	request.Account.Version = version
+
+	account, err := models.UpdateAccount(request.Account, request.Balance, actor(c, uid))
 	if err != nil {
-		// You can log the error or return it, depending on your application's needs
-		log.Printf("Error adding account: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add acccount"})
+		if accountError(c, err) {
+			return
+		}
+		log.Printf("Error updating account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account"})
 		return
 	}
-	c.JSON(http.StatusCreated, account)
+	setETag(c, account.Version)
+	c.JSON(http.StatusOK, account)
 }
 
 func (h *Controller) DeleteAccountController(c *gin.Context) {
@@ -96,10 +148,18 @@ func (h *Controller) DeleteAccountController(c *gin.Context) {
 		return
 	}
 
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	idParam := c.Param("id")
 
-	err = models.DeleteAccount(idParam, uid) // delete account
+	err = models.DeleteAccount(idParam, version, actor(c, uid)) // delete account
 	if err != nil {
+		if accountError(c, err) {
+			return
+		}
 		// You can log the error or return it, depending on your application's needs
 		log.Printf("Error adding account: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete acccount"})
@@ -107,3 +167,107 @@ func (h *Controller) DeleteAccountController(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, "OK")
 }
+
+// ArchiveAccountController closes an account, its history is kept
+func (h *Controller) ArchiveAccountController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := models.ArchiveAccount(c.Param("id"), actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		log.Printf("Error archiving account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive account"})
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+// UnarchiveAccountController reopens an archived account
+func (h *Controller) UnarchiveAccountController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := models.UnarchiveAccount(c.Param("id"), actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		log.Printf("Error unarchiving account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unarchive account"})
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+// PurgeAccountController deletes an account with all its transactions once
+// the request confirms it with the account's name
+func (h *Controller) PurgeAccountController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request purgeAccountRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.PurgeAccount(c.Param("id"), request.Confirm, actor(c, uid)); err != nil {
+		if accountError(c, err) {
+			return
+		}
+		log.Printf("Error purging account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge account"})
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+// RecomputeBalanceController sets the balance of an account to the sum of
+// its transactions
+func (h *Controller) RecomputeBalanceController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	check, err := models.RecomputeBalance(c.Param("id"), actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		log.Printf("Error recomputing balance: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute balance"})
+		return
+	}
+	c.JSON(http.StatusOK, check)
+}
+
+// GetBalanceDiscrepanciesController lists the accounts whose balance didn't
+// match their transactions at the last nightly check
+func (h *Controller) GetBalanceDiscrepanciesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	checks, err := models.GetBalanceDiscrepancies(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, checks)
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type accountGroupRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type orderAccountGroupsRequest struct {
+	GroupIDs []string `json:"group_ids"`
+}
+
+type groupAccountsRequest struct {
+	AccountIDs []string `json:"account_ids"`
+}
+
+// accountGroupError writes the response for an error of the account groups
+// model
+func accountGroupError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidGroup):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error %s: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed " + action})
+	}
+}
+
+// GetAccountGroupsController lists the account groups of the user with
+// their accounts and subtotals
+func (h *Controller) GetAccountGroupsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	groups, err := models.GetAccountGroups(uid)
+	if err != nil {
+		accountGroupError(c, err, "retrieving account groups")
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+func (h *Controller) AddAccountGroupController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request accountGroupRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	group, err := models.AddAccountGroup(models.AccountGroup{Name: request.Name}, uid)
+	if err != nil {
+		accountGroupError(c, err, "adding account group")
+		return
+	}
+	c.JSON(http.StatusCreated, group)
+}
+
+func (h *Controller) RenameAccountGroupController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request accountGroupRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.RenameAccountGroup(c.Param("group_id"), request.Name, uid); err != nil {
+		accountGroupError(c, err, "renaming account group")
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+func (h *Controller) DeleteAccountGroupController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeleteAccountGroup(c.Param("group_id"), uid); err != nil {
+		accountGroupError(c, err, "deleting account group")
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+// OrderAccountGroupsController sets the order of all the groups of the user
+func (h *Controller) OrderAccountGroupsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request orderAccountGroupsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.OrderAccountGroups(request.GroupIDs, uid); err != nil {
+		accountGroupError(c, err, "ordering account groups")
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+// SetGroupAccountsController places accounts in a group in the given order
+func (h *Controller) SetGroupAccountsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request groupAccountsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	group, err := models.SetGroupAccounts(c.Param("group_id"), request.AccountIDs, uid)
+	if err != nil {
+		accountGroupError(c, err, "grouping accounts")
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// attachmentError writes the response for an error of the attachments model
+func attachmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidAttachment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error handling attachment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process attachment"})
+	}
+}
+
+func (h *Controller) GetAttachmentsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachments, err := models.GetAttachments(c.Param("id"), uid)
+	if err != nil {
+		attachmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// AddAttachmentController uploads the "file" field of a multipart form as
+// an attachment of the transaction
+func (h *Controller) AddAttachmentController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Leave some room for the multipart envelope
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, models.MaxAttachmentSize+1<<20)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachment is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing attachment file"})
+		return
+	}
+	if header.Size > models.MaxAttachmentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Attachment is too large"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(filepath.Base(header.Filename))
+	if name == "" || name == "." || name == "/" {
+		name = "attachment"
+	}
+
+	attachment, err := models.AddAttachment(c.Param("id"), uid, name, data)
+	if err != nil {
+		attachmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, attachment)
+}
+
+func (h *Controller) DownloadAttachmentController(c *gin.Context) {
+	h.sendAttachment(c, false)
+}
+
+func (h *Controller) GetAttachmentThumbnailController(c *gin.Context) {
+	h.sendAttachment(c, true)
+}
+
+// sendAttachment streams an attachment of the user, or its thumbnail
+func (h *Controller) sendAttachment(c *gin.Context, thumbnail bool) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachment, err := models.GetAttachment(c.Param("id"), uid)
+	if err != nil {
+		attachmentError(c, err)
+		return
+	}
+
+	reader, err := models.OpenAttachment(attachment, thumbnail)
+	if err != nil {
+		attachmentError(c, err)
+		return
+	}
+	defer reader.Close()
+
+	contentType, size := attachment.ContentType, attachment.Size
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName})
+	if thumbnail {
+		contentType, size = "image/jpeg", -1
+		disposition = "inline"
+	}
+
+	c.DataFromReader(http.StatusOK, size, contentType, reader, map[string]string{
+		"Content-Disposition":    disposition,
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=3600",
+	})
+}
+
+func (h *Controller) DeleteAttachmentController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeleteAttachment(c.Param("id"), uid); err != nil {
+		attachmentError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
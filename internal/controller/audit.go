@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/null/v5"
+)
+
+// SourceHeader lets importers and schedulers calling the API tell their
+// changes apart from the user's in the audit log, unknown sources are
+// recorded as api
+const SourceHeader = "X-Change-Source"
+
+// actor identifies the user making a change for the audit log, with the id
+// of the request and the source the client declared
+func actor(c *gin.Context, uid string) models.Actor {
+	requestID := c.GetString("requestID")
+	return models.Actor{
+		UserID:    uid,
+		RequestID: null.NewString(requestID, requestID != ""),
+		Source:    c.GetHeader(SourceHeader),
+	}
+}
+
+// historyError writes the response for an error of the audit log
+func historyError(c *gin.Context, err error) {
+	if errors.Is(err, models.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Error retrieving history: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed retrieving history"})
+}
+
+// GetTransactionHistoryController lists the changes to a transaction
+func (h *Controller) GetTransactionHistoryController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := models.GetEntityHistory(models.AuditTransaction, c.Param("id"), uid)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// GetAccountHistoryController lists the changes to an account
+func (h *Controller) GetAccountHistoryController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := models.GetEntityHistory(models.AuditAccount, c.Param("id"), uid)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// GetAuditLogController lists the changes of the user, last first. It takes
+// entity_type, entity_id and source filters, and limit and before, the id of
+// the last entry of the previous page, to page.
+func (h *Controller) GetAuditLogController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := models.AuditQuery{
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		Source:     c.Query("source"),
+	}
+	if query.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "0")); err != nil || query.Limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	if query.Before, err = strconv.ParseInt(c.DefaultQuery("before", "0"), 10, 64); err != nil || query.Before < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before"})
+		return
+	}
+
+	entries, err := models.GetAuditLog(query, uid)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -1,21 +1,65 @@
 package controller
 
 import (
+	"errors"
 	"guilliman/internal/models"
+	"guilliman/internal/utils"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// GetBudgetSummaryController returns the budget of a pay period for the
+// user's personal accounts, or for a household with ?household=<id>
 func (h *Controller) GetBudgetSummaryController(c *gin.Context) {
-	startDay := c.Query("start_day")
-	endDay := c.Query("end_day")
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := resolvePayPeriod(c, uid, time.Now())
+	if err != nil {
+		return
+	}
 
-	budgetSummary, err := models.GetBudgetSummary(startDay, endDay)
+	budgetSummary, err := models.GetBudgetSummary(start, end, queryList(c, "tag"), c.Query("household"), uid)
 	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, budgetSummary)
 }
+
+// GetCategoryReportController returns the spending per category of a pay
+// period, selected and scoped like the budget summary
+func (h *Controller) GetCategoryReportController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	start, end, err := resolvePayPeriod(c, uid, time.Now())
+	if err != nil {
+		return
+	}
+
+	report, err := models.GetCategoryReport(start, end, queryList(c, "tag"), c.Query("household"), uid)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
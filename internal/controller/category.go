@@ -1,15 +1,39 @@
 package controller
 
 import (
+	"errors"
 	"guilliman/internal/models"
+	"guilliman/internal/utils"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// categoryError answers access errors on household categories, it returns
+// false for other errors
+func categoryError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrVersionMismatch):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *Controller) GetCategoriesController(c *gin.Context) {
-	categories, err := models.GetCategories() // Fetch categories from storage
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	categories, err := models.GetCategories(uid) // Fetch categories from storage
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -18,43 +42,84 @@ func (h *Controller) GetCategoriesController(c *gin.Context) {
 }
 
 func (h *Controller) CreateCategoryController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	var newCategory models.Category
 	if err := c.ShouldBindJSON(&newCategory); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	category, err := models.AddCategory(newCategory) // Add category to storage
+	category, err := models.AddCategory(newCategory, actor(c, uid)) // Add category to storage
 	if err != nil {
+		if categoryError(c, err) {
+			return
+		}
 		log.Printf("Error adding category: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add category"})
 		return
 	}
+	setETag(c, category.Version)
 	c.JSON(http.StatusCreated, category)
 }
 
 func (h *Controller) UpdateCategoryController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
   var updatedCategory models.Category
   if err := c.ShouldBindJSON(&updatedCategory); err != nil {
     c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
     return
   }
-	category, err := models.UpdateCategory(updatedCategory)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	updatedCategory.Version = version
+
+	category, err := models.UpdateCategory(updatedCategory, actor(c, uid))
 	if err != nil {
+		if categoryError(c, err) {
+			return
+		}
 		log.Printf("Error updating category: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed updating category"})
 		return
 	}
+	setETag(c, category.Version)
 	c.JSON(http.StatusCreated, category)
 }
 
 func (h *Controller) DeleteCategoryController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	var deletedCategory models.Category
 	if err := c.ShouldBindJSON(&deletedCategory); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := models.DeleteCategory(deletedCategory); err != nil {
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	deletedCategory.Version = version
+
+	if err := models.DeleteCategory(deletedCategory, actor(c, uid)); err != nil {
+		if categoryError(c, err) {
+			return
+		}
 		log.Printf("Error deleting category: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed deleting category"})
 		return
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type payStatementRequest struct {
+	FromAccountID string  `json:"from_account_id" binding:"required"`
+	Amount        float64 `json:"amount"`  // Defaults to what remains of the statement
+	Minimum       bool    `json:"minimum"` // Pay what remains of the minimum payment instead
+}
+
+// GetStatementsController lists the statements of a credit card, closing
+// the cycles that ended since the last request
+func (h *Controller) GetStatementsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	statements, err := models.GetStatements(c.Param("id"), uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, statements)
+}
+
+// PayStatementController records a transfer from a bank account to the card
+func (h *Controller) PayStatementController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request payStatementRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := models.PayStatement(c.Param("id"), c.Param("statement_id"), request.FromAccountID, request.Amount, request.Minimum, actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, transaction)
+}
+
+// GetUpcomingDuesController lists the card statements to pay within the
+// next days (30 by default), overdue ones included
+func (h *Controller) GetUpcomingDuesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+		return
+	}
+
+	dues, err := models.GetUpcomingDues(days, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, dues)
+}
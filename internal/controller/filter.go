@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/null/v5"
+)
+
+// transactionFilterFromQuery reads the transaction filter parameters shared by
+// listing and search:
+//
+//	from, to                 RFC 3339 or Unix seconds
+//	min_amount, max_amount   absolute amount
+//	category_id, tag         repeated or comma separated
+//	main_category, currency, account, payee, type, description
+//	status                   void transactions are only listed when asked for
+//	household                only the transactions of a household's accounts
+//
+// On failure the error response is already written.
+func transactionFilterFromQuery(c *gin.Context, uid string) (models.TransactionFilter, error) {
+	filter := models.TransactionFilter{
+		UserID:          uid,
+		MainCategory:    c.Query("main_category"),
+		Currency:        c.Query("currency"),
+		AccountID:       c.Query("account"),
+		PayeeID:         c.Query("payee"),
+		TransactionType: c.Query("type"),
+		Description:     c.Query("description"),
+		HouseholdID:     c.Query("household"),
+		Status:          c.Query("status"),
+	}
+
+	badRequest := func(message string) (models.TransactionFilter, error) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return models.TransactionFilter{}, fmt.Errorf("%s", message)
+	}
+
+	if !isValidTransactionType(filter.TransactionType) {
+		return badRequest("Invalid transaction type")
+	}
+	if !isValidTransactionStatus(filter.Status) {
+		return badRequest("Invalid status, use pending, cleared, reconciled or void")
+	}
+
+	if from := c.Query("from"); from != "" {
+		timestamp, err := timeutils.ParseTimestamp(from)
+		if err != nil {
+			return badRequest("Invalid from date. Use RFC 3339 or a Unix timestamp.")
+		}
+		filter.From = timestamp.Time
+	}
+
+	if to := c.Query("to"); to != "" {
+		timestamp, err := timeutils.ParseTimestamp(to)
+		if err != nil {
+			return badRequest("Invalid to date. Use RFC 3339 or a Unix timestamp.")
+		}
+		filter.To = timestamp.Time
+	}
+
+	if minAmount := c.Query("min_amount"); minAmount != "" {
+		value, err := strconv.ParseFloat(minAmount, 64)
+		if err != nil {
+			return badRequest("Invalid min_amount")
+		}
+		filter.MinAmount = null.FloatFrom(value)
+	}
+
+	if maxAmount := c.Query("max_amount"); maxAmount != "" {
+		value, err := strconv.ParseFloat(maxAmount, 64)
+		if err != nil {
+			return badRequest("Invalid max_amount")
+		}
+		filter.MaxAmount = null.FloatFrom(value)
+	}
+
+	filter.CategoryIDs = queryList(c, "category_id")
+	filter.Tags = queryList(c, "tag")
+
+	return filter, nil
+}
+
+// queryList reads a parameter given several times and/or as a comma separated list
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, param := range c.QueryArray(key) {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func isValidTransactionType(transactionType string) bool {
+	switch transactionType {
+	case "", models.TransactionTypeExpense, models.TransactionTypeIncome,
+		models.TransactionTypeSavings, models.TransactionTypeTransfer, models.TransactionTypeRefund,
+		models.TransactionTypeSettlement, models.TransactionTypeTrade, models.TransactionTypeAdjustment:
+		return true
+	}
+	return false
+}
+
+func isValidTransactionStatus(status string) bool {
+	switch status {
+	case "", models.TransactionStatusPending, models.TransactionStatusCleared,
+		models.TransactionStatusReconciled, models.TransactionStatusVoid:
+		return true
+	}
+	return false
+}
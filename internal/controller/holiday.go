@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxCalendarSize = 1 << 20
+
+func (h *Controller) GetHolidaysController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	year := time.Now().Year()
+	if yearParam := c.Query("year"); yearParam != "" {
+		year, err = strconv.Atoi(yearParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid year"})
+			return
+		}
+	}
+
+	holidays, err := models.GetHolidays(uid, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"year":                year,
+		"holidays":            holidays,
+		"supported_countries": timeutils.SupportedCountries(),
+	})
+}
+
+func (h *Controller) GetHolidayCalendarsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	files, err := models.GetHolidayCalendarFiles(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+// AddHolidayCalendarController accepts an .ics file either as the "file"
+// field of a multipart form or as a raw text/calendar body
+func (h *Controller) AddHolidayCalendarController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCalendarSize)
+
+	name := c.Query("name")
+	var reader io.Reader = c.Request.Body
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing calendar file"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		reader = file
+		if name == "" {
+			name = header.Filename
+		}
+	}
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Calendar file is too large"})
+		return
+	}
+
+	if name == "" {
+		name = "Holidays"
+	}
+
+	file, err := models.AddHolidayCalendarFile(models.HolidayCalendarFile{
+		Name:    name,
+		Content: string(content),
+		UserID:  uid,
+	})
+	if err != nil {
+		log.Printf("Error adding holiday calendar: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, file)
+}
+
+func (h *Controller) DeleteHolidayCalendarController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeleteHolidayCalendarFile(c.Param("id"), uid); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
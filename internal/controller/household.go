@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type householdRequest struct {
+	Name string `json:"name"`
+}
+
+type invitationRequest struct {
+	Role  string `json:"role"`  // Defaults to editor
+	Email string `json:"email"` // Optional, restricts who can accept
+}
+
+type acceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type memberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// householdError writes the response for an error of the households model
+func householdError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidHousehold):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error handling household: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process household"})
+	}
+}
+
+func (h *Controller) GetHouseholdsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	households, err := models.GetHouseholds(uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, households)
+}
+
+func (h *Controller) GetHouseholdController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	household, err := models.GetHousehold(c.Param("id"), uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, household)
+}
+
+// AddHouseholdController creates a household owned by the user
+func (h *Controller) AddHouseholdController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request householdRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	household, err := models.AddHousehold(request.Name, uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, household)
+}
+
+func (h *Controller) UpdateHouseholdController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request householdRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	household, err := models.RenameHousehold(c.Param("id"), request.Name, uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, household)
+}
+
+func (h *Controller) DeleteHouseholdController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeleteHousehold(c.Param("id"), uid); err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Household deleted successfully"})
+}
+
+// InviteToHouseholdController creates an invitation. Its token is only
+// returned here and has to be passed on to the invited user.
+func (h *Controller) InviteToHouseholdController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request invitationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, err := models.InviteToHousehold(c.Param("id"), request.Role, request.Email, uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, invitation)
+}
+
+func (h *Controller) GetInvitationsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitations, err := models.GetInvitations(c.Param("id"), uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+func (h *Controller) RevokeInvitationController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.RevokeInvitation(c.Param("id"), c.Param("invitation_id"), uid); err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// AcceptInvitationController makes the user a member of the household the
+// token invites to
+func (h *Controller) AcceptInvitationController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request acceptInvitationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	household, err := models.AcceptInvitation(request.Token, uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, household)
+}
+
+func (h *Controller) UpdateHouseholdMemberController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request memberRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	household, err := models.SetMemberRole(c.Param("id"), c.Param("user_id"), request.Role, uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, household)
+}
+
+// RemoveHouseholdMemberController removes a member, or lets the user leave
+// when the member is themselves
+func (h *Controller) RemoveHouseholdMemberController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.RemoveMember(c.Param("id"), c.Param("user_id"), uid); err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// AddHouseholdAccountController shares a personal account with the household
+func (h *Controller) AddHouseholdAccountController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := models.MoveAccountToHousehold(c.Param("id"), c.Param("account_id"), uid)
+	if err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
+
+// RemoveHouseholdAccountController makes a household account personal again
+func (h *Controller) RemoveHouseholdAccountController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.RemoveAccountFromHousehold(c.Param("id"), c.Param("account_id"), uid); err != nil {
+		householdError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account removed from household"})
+}
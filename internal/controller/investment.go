@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"guilliman/internal/models"
+	"guilliman/internal/prices"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxPriceFileSize = 10 << 20
+
+// performancePeriod reads the from and to query parameters, zero when not
+// given. It writes the response and returns false when they are invalid.
+func performancePeriod(c *gin.Context) (time.Time, time.Time, bool) {
+	var from, to time.Time
+	if value := c.Query("from"); value != "" {
+		timestamp, err := timeutils.ParseTimestamp(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date. Use RFC 3339 or a Unix timestamp."})
+			return from, to, false
+		}
+		from = timestamp.Time
+	}
+	if value := c.Query("to"); value != "" {
+		timestamp, err := timeutils.ParseTimestamp(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date. Use RFC 3339 or a Unix timestamp."})
+			return from, to, false
+		}
+		to = timestamp.Time
+	}
+	return from, to, true
+}
+
+// GetHoldingsController values an investment account at the latest prices
+func (h *Controller) GetHoldingsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := models.GetHoldings(c.Param("id"), uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+func (h *Controller) GetTradesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	trades, err := models.GetTrades(c.Param("id"), uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, trades)
+}
+
+// AddTradeController records a buy, sell, dividend or split
+func (h *Controller) AddTradeController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var trade models.Trade
+	if err := c.ShouldBindJSON(&trade); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trade, err = models.AddTrade(c.Param("id"), trade, actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, trade)
+}
+
+func (h *Controller) DeleteTradeController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeleteTrade(c.Param("id"), c.Param("trade_id"), actor(c, uid)); err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Trade deleted successfully"})
+}
+
+// GetPerformanceController returns the time-weighted and money-weighted
+// returns of an investment account between from and to
+func (h *Controller) GetPerformanceController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	from, to, ok := performancePeriod(c)
+	if !ok {
+		return
+	}
+
+	performance, err := models.GetPerformance(c.Param("id"), from, to, uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, performance)
+}
+
+// GetPortfolioPerformanceController returns the returns of every investment
+// account and of all of them together
+func (h *Controller) GetPortfolioPerformanceController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	from, to, ok := performancePeriod(c)
+	if !ok {
+		return
+	}
+
+	portfolio, err := models.GetPortfolioPerformance(from, to, uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, portfolio)
+}
+
+func (h *Controller) GetPricesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	history, err := models.GetPrices(c.Param("symbol"), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// UploadPricesController imports a CSV file of prices, either as the "file"
+// field of a multipart form or as a raw text/csv body. Files without a
+// symbol column need ?symbol.
+func (h *Controller) UploadPricesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPriceFileSize)
+
+	var reader io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing prices file"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	quotes, err := prices.ParseCSV(reader, c.Query("symbol"))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Prices file is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := models.ImportPrices(quotes, "csv", uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"imported": count})
+}
+
+// RefreshPricesController fetches the missing prices of the traded symbols
+// from the configured price provider
+func (h *Controller) RefreshPricesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	refreshes, err := models.RefreshPrices(uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, refreshes)
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/null/v5"
+)
+
+type loanPaymentRequest struct {
+	FromAccountID      string              `json:"from_account_id" binding:"required"`
+	Amount             float64             `json:"amount" binding:"required"`
+	Date               timeutils.Timestamp `json:"date"`                 // Defaults to now
+	InterestCategoryID null.String         `json:"interest_category_id"` // Defaults to the category of the loan
+}
+
+// GetLoanScheduleController returns the amortization schedule of a loan,
+// projected from its balance unless ?from=start. ?extra adds a recurring
+// extra payment and compares with the schedule without it.
+func (h *Controller) GetLoanScheduleController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	from := c.DefaultQuery("from", "current")
+	if from != "current" && from != "start" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, use start or current"})
+		return
+	}
+
+	extra, err := strconv.ParseFloat(c.DefaultQuery("extra", "0"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid extra"})
+		return
+	}
+
+	schedule, err := models.GetLoanSchedule(c.Param("id"), from == "start", extra, uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (h *Controller) GetLoanPaymentsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	payments, err := models.GetLoanPayments(c.Param("id"), uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payments)
+}
+
+// AddLoanPaymentController records a payment, split into an interest
+// expense and a principal transfer to the loan
+func (h *Controller) AddLoanPaymentController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request loanPaymentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment := models.LoanPayment{
+		FromAccountID: request.FromAccountID,
+		Amount:        request.Amount,
+		Date:          request.Date,
+	}
+	payment, err = models.AddLoanPayment(c.Param("id"), payment, request.InterestCategoryID, actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, payment)
+}
+
+// AddLoanRateController changes the rate of a variable rate loan from a date
+func (h *Controller) AddLoanRateController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rate models.LoanRate
+	if err := c.ShouldBindJSON(&rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := models.AddLoanRate(c.Param("id"), rate, uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, account)
+}
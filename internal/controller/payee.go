@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// payeeError writes the response for an error of the payees model
+func payeeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidPayee):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error handling payee: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payee"})
+	}
+}
+
+func (h *Controller) GetPayeesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	payees, err := models.GetPayees(uid)
+	if err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, payees)
+}
+
+func (h *Controller) AddPayeeController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var newPayee models.Payee
+	if err := c.ShouldBindJSON(&newPayee); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newPayee.UserID = uid
+
+	payee, err := models.AddPayee(newPayee)
+	if err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, payee)
+}
+
+func (h *Controller) UpdatePayeeController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updatedPayee models.Payee
+	if err := c.ShouldBindJSON(&updatedPayee); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updatedPayee.ID = c.Param("id")
+	updatedPayee.UserID = uid
+
+	payee, err := models.UpdatePayee(updatedPayee)
+	if err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, payee)
+}
+
+func (h *Controller) DeletePayeeController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeletePayee(c.Param("id"), uid); err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+func (h *Controller) AddPayeeAliasController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var newAlias models.PayeeAlias
+	if err := c.ShouldBindJSON(&newAlias); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newAlias.PayeeID = c.Param("id")
+
+	alias, err := models.AddPayeeAlias(newAlias, uid)
+	if err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, alias)
+}
+
+func (h *Controller) DeletePayeeAliasController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeletePayeeAlias(c.Param("id"), c.Param("alias_id"), uid); err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+// RematchPayeesController assigns payees to existing transactions, only to
+// the ones without payee unless overwrite=true
+func (h *Controller) RematchPayeesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := models.RematchPayees(actor(c, uid), c.Query("overwrite") == "true")
+	if err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": count})
+}
+
+// GetPayeeReportController summarizes spending per payee, filtered like the
+// transaction listing (typically from and to)
+func (h *Controller) GetPayeeReportController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := transactionFilterFromQuery(c, uid)
+	if err != nil {
+		return
+	}
+
+	report, err := models.GetPayeeReport(filter)
+	if err != nil {
+		payeeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
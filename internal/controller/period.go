@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolvePayPeriod returns the pay period requested through the query string.
+// The user's stored pay cycle is used unless the legacy start_day or end_day
+// parameters are present, and period selects a cycle relative to the one
+// containing ref (period=-1 is the last cycle). The legacy parameters are a
+// monthly cycle starting on start_day (25 by default) whose periods end on
+// end_day of the following month, the day before start_day by default.
+// Boundaries are computed in the user's time zone. On failure the error
+// response is already written.
+func resolvePayPeriod(c *gin.Context, uid string, ref time.Time) (time.Time, time.Time, error) {
+	index := 0
+	if periodParam := c.Query("period"); periodParam != "" {
+		value, err := strconv.Atoi(periodParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period. Use an integer such as 0 or -1."})
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid period: %v", err)
+		}
+		index = value
+	}
+
+	startDay, endDay := c.Query("start_day"), c.Query("end_day")
+	if startDay != "" || endDay != "" {
+		cycle := timeutils.DefaultPayCycle()
+		if startDay != "" {
+			day, err := strconv.Atoi(startDay)
+			cycle = timeutils.MonthlyPayCycle(day)
+			if err == nil {
+				err = cycle.Validate()
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_day. Use a day between 1 and 31."})
+				return time.Time{}, time.Time{}, fmt.Errorf("invalid start_day: %v", err)
+			}
+		}
+		loc, err := models.GetUserLocation(uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return time.Time{}, time.Time{}, err
+		}
+		start, end := timeutils.PeriodRange(cycle, ref.In(loc), index)
+
+		if endDay != "" {
+			day, err := strconv.Atoi(endDay)
+			if err == nil && (day < 1 || day > 31) {
+				err = fmt.Errorf("%d is not a day of the month", day)
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_day. Use a day between 1 and 31."})
+				return time.Time{}, time.Time{}, fmt.Errorf("invalid end_day: %v", err)
+			}
+			end = timeutils.EndOnDay(start, day)
+		}
+		return start, end, nil
+	}
+
+	start, end, err := models.GetPayPeriod(uid, ref, index)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return time.Time{}, time.Time{}, err
+	}
+
+	return start, end, nil
+}
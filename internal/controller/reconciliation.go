@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"net/http"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/null/v5"
+)
+
+type startReconciliationRequest struct {
+	StatementDate    timeutils.Timestamp `json:"statement_date" binding:"required"`
+	StatementBalance float64             `json:"statement_balance"`
+}
+
+type clearTransactionsRequest struct {
+	TransactionIDs []string `json:"transaction_ids" binding:"required"`
+	Cleared        *bool    `json:"cleared"` // Defaults to true, false unmarks the transactions
+}
+
+type finishReconciliationRequest struct {
+	Adjust     bool        `json:"adjust"`      // Post an adjustment for the remaining difference
+	CategoryID null.String `json:"category_id"` // Category of the adjustment, optional
+}
+
+func (h *Controller) GetReconciliationsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	reconciliations, err := models.GetReconciliations(c.Param("id"), uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reconciliations)
+}
+
+// GetReconciliationController returns a reconciliation with its
+// transactions, those left to clear while it is open
+func (h *Controller) GetReconciliationController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	reconciliation, err := models.GetReconciliation(c.Param("id"), c.Param("reconciliation_id"), uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reconciliation)
+}
+
+// StartReconciliationController opens a reconciliation against the ending
+// balance of a bank statement
+func (h *Controller) StartReconciliationController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request startReconciliationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reconciliation, err := models.StartReconciliation(c.Param("id"), request.StatementDate, request.StatementBalance, uid)
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, reconciliation)
+}
+
+// ClearTransactionsController marks transactions as found on the statement
+// and returns the remaining difference
+func (h *Controller) ClearTransactionsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request clearTransactionsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cleared := request.Cleared == nil || *request.Cleared
+
+	reconciliation, err := models.ClearTransactions(c.Param("id"), c.Param("reconciliation_id"), request.TransactionIDs, cleared, actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reconciliation)
+}
+
+// FinishReconciliationController locks the cleared transactions
+func (h *Controller) FinishReconciliationController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request finishReconciliationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reconciliation, err := models.FinishReconciliation(c.Param("id"), c.Param("reconciliation_id"), request.Adjust, request.CategoryID, actor(c, uid))
+	if err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reconciliation)
+}
+
+func (h *Controller) CancelReconciliationController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.CancelReconciliation(c.Param("id"), c.Param("reconciliation_id"), uid); err != nil {
+		if accountError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Reconciliation cancelled"})
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type shareRequest struct {
+	Method       string         `json:"method"`
+	Participants []models.Share `json:"participants"`
+}
+
+type settleRequest struct {
+	UserID    string  `json:"user_id"`
+	Email     string  `json:"email"`
+	AccountID string  `json:"account_id"`
+	Amount    float64 `json:"amount"` // Omit to settle the whole balance
+}
+
+// sharedError writes the response for an error of the shared expenses model
+func sharedError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidShare):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error handling shared expense: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process shared expense"})
+	}
+}
+
+// ShareTransactionController splits an expense of the user with other users
+func (h *Controller) ShareTransactionController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request shareRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Method == "" {
+		request.Method = models.ShareEqual
+	}
+
+	expense, err := models.ShareExpense(c.Param("id"), actor(c, uid), request.Method, request.Participants)
+	if err != nil {
+		sharedError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, expense)
+}
+
+func (h *Controller) GetTransactionShareController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expense, err := models.GetSharedExpense(c.Param("id"), uid)
+	if err != nil {
+		sharedError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, expense)
+}
+
+func (h *Controller) UnshareTransactionController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.UnshareExpense(c.Param("id"), actor(c, uid)); err != nil {
+		sharedError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+func (h *Controller) GetSharedExpensesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expenses, err := models.GetSharedExpenses(uid)
+	if err != nil {
+		sharedError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, expenses)
+}
+
+// GetBalancesController returns who owes whom, positive amounts are owed to the user
+func (h *Controller) GetBalancesController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	balances, err := models.GetBalances(uid)
+	if err != nil {
+		sharedError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, balances)
+}
+
+// SettleUpController records the reimbursement of a balance in one of the user's accounts
+func (h *Controller) SettleUpController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request settleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settlement, err := models.SettleUp(actor(c, uid), request.UserID, request.Email, request.AccountID, request.Amount)
+	if err != nil {
+		sharedError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, settlement)
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Controller) GetTagsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := models.GetTags(uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+func (h *Controller) AddTagController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var newTag models.Tag
+	if err := c.ShouldBindJSON(&newTag); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newTag.UserID = uid
+
+	tag, err := models.AddTag(newTag)
+	if err != nil {
+		log.Printf("Error adding tag: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to add tag, names must be unique"})
+		return
+	}
+	c.JSON(http.StatusCreated, tag)
+}
+
+func (h *Controller) UpdateTagController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updatedTag models.Tag
+	if err := c.ShouldBindJSON(&updatedTag); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updatedTag.ID = c.Param("id")
+
+	tag, err := models.UpdateTag(updatedTag, actor(c, uid))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error updating tag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tag"})
+		return
+	}
+	c.JSON(http.StatusOK, tag)
+}
+
+func (h *Controller) DeleteTagController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.DeleteTag(c.Param("id"), actor(c, uid)); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error deleting tag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+type bulkTagRequest struct {
+	TransactionIDs []string `json:"transaction_ids"`
+	Add            []string `json:"add"`
+	Remove         []string `json:"remove"`
+}
+
+// BulkTagTransactionsController tags and untags several transactions at once.
+// The transactions are the ones listed in transaction_ids or, when it is
+// empty, every transaction matching the listing filters of the query string,
+// at least one of which is required.
+func (h *Controller) BulkTagTransactionsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request bulkTagRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(request.Add) == 0 && len(request.Remove) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to add or remove"})
+		return
+	}
+
+	filter, err := transactionFilterFromQuery(c, uid)
+	if err != nil {
+		return
+	}
+	if len(request.TransactionIDs) > 0 {
+		filter = models.TransactionFilter{UserID: uid, IDs: request.TransactionIDs}
+	}
+
+	count, err := models.BulkTagTransactions(filter, request.Add, request.Remove, actor(c, uid))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error tagging transactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to tag transactions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": count})
+}
@@ -1,7 +1,7 @@
 package controller
 
 import (
-	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -38,11 +38,18 @@ func (h *Controller) GetTransactionsByMainCategory(c *gin.Context) {
 	}
 
 	mainCategory := c.Param("main_category")
-	startDay := c.Query("start_day")
-	endDay := c.Query("end_day")
 
-	transactions, err := models.GetTransactionsByMainCategory(mainCategory, startDay, endDay, uid)
+	start, end, err := resolvePayPeriod(c, uid, time.Now())
 	if err != nil {
+		return
+	}
+
+	transactions, err := models.GetTransactionsByMainCategory(mainCategory, start, end, queryList(c, "tag"), c.Query("household"), uid)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -62,18 +69,56 @@ func (h *Controller) UpdateTransactionController(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 
+	id := c.Param("id")
 	transaction.UserID = uid
+	transaction.Version = version
+
+	// The transaction as it was is kept to undo the update
+	previous, previousErr := models.GetTransactionByID(id, uid)
 
-	transaction, err = models.UpdateTransaction(transaction.ID, transaction)
+	transaction, err = models.UpdateTransaction(id, transaction, c.Query("override") == "true", actor(c, uid))
 	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrVersionMismatch) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrReconciled) || errors.Is(err, models.ErrInvalidStatus) || errors.Is(err, models.ErrAccountArchived) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrInvalidSplits) || errors.Is(err, models.ErrInvalidRefund) || errors.Is(err, models.ErrInvalidShare) ||
+			errors.Is(err, models.ErrInvalidPayee) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("Error updating transaction: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction"})
 		return
 	}
+	if previousErr == nil {
+		setUndoToken(c, uid, transaction.ID, models.UndoUpdate, &previous)
+	}
+	setETag(c, transaction.Version)
 	c.JSON(http.StatusOK, transaction)
 }
 
+// GetTransactionsController lists transactions a page at a time. Besides the
+// filters of transactionFilterFromQuery it accepts sort (date, amount or
+// created_at), order (asc or desc, default desc), limit and cursor, the
+// next_cursor of the previous page.
 func (h *Controller) GetTransactionsController(c *gin.Context) {
 	uid, err := utils.GetUserUID(c)
 	if err != nil {
@@ -81,16 +126,42 @@ func (h *Controller) GetTransactionsController(c *gin.Context) {
 		return
 	}
 
-	typeParam := c.Query("type")
-	accountParam := c.Query("account")
-	limitParam := c.Query("limit")
+	filter, err := transactionFilterFromQuery(c, uid)
+	if err != nil {
+		return
+	}
+
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order, use asc or desc"})
+		return
+	}
+
+	query := models.TransactionQuery{
+		Filter: filter,
+		SortBy: c.Query("sort"),
+		Desc:   order == "desc",
+		Cursor: c.Query("cursor"),
+	}
 
-	transactions, err := models.GetTransactions(typeParam, accountParam, limitParam, uid)
+	if limitParam := c.Query("limit"); limitParam != "" {
+		query.Limit, err = strconv.Atoi(limitParam)
+		if err != nil || query.Limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	page, err := models.QueryTransactions(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, models.ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
-	c.JSON(http.StatusOK, transactions)
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *Controller) GetTransactionByIdController(c *gin.Context) {
@@ -104,13 +175,14 @@ func (h *Controller) GetTransactionByIdController(c *gin.Context) {
 
 	transaction, err := models.GetTransactionByID(id, uid)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, models.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
+	setETag(c, transaction.Version)
 	c.JSON(http.StatusOK, transaction)
 }
 
@@ -129,29 +201,63 @@ func (h *Controller) AddTransactionController(c *gin.Context) {
 
 	newTransaction.UserID = uid
 
-	transaction, err := models.AddTransaction(newTransaction)
+	transaction, err := models.AddTransaction(newTransaction, actor(c, uid))
 	if err != nil {
+		if errors.Is(err, models.ErrInvalidSplits) || errors.Is(err, models.ErrInvalidRefund) || errors.Is(err, models.ErrCreditLimit) ||
+			errors.Is(err, models.ErrInvalidStatus) || errors.Is(err, models.ErrInvalidPayee) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrAccountArchived) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("Error adding transaction: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add transaction"})
 		return
 	}
+	setUndoToken(c, uid, transaction.ID, models.UndoCreate, nil)
+	setETag(c, transaction.Version)
 	c.JSON(http.StatusCreated, transaction)
 }
 
 func (h *Controller) DeleteTransactionController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	idParam := c.Param("id")
 
-	err := models.DeleteTransaction(idParam)
+	err = models.DeleteTransaction(idParam, version, actor(c, uid), c.Query("override") == "true")
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, models.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		} else if errors.Is(err, models.ErrVersionMismatch) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		} else if errors.Is(err, models.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else if errors.Is(err, models.ErrInvalidRefund) || errors.Is(err, models.ErrReconciled) ||
+			errors.Is(err, models.ErrInvalidStatus) || errors.Is(err, models.ErrInvalidShare) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Transaction deleted successfully"})
+	setUndoToken(c, uid, idParam, models.UndoDelete, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Transaction moved to the trash"})
 }
 
 func (h *Controller) GetTransactionsForPeriodController(c *gin.Context) {
@@ -166,28 +272,25 @@ func (h *Controller) GetTransactionsForPeriodController(c *gin.Context) {
 	accountParam := c.Query("account")
 
 	// check transaction type is valid or empty
-	if typeParam != models.TransactionTypeExpense &&
-		typeParam != models.TransactionTypeIncome &&
-		typeParam != models.TransactionTypeSavings &&
-		typeParam != models.TransactionTypeTransfer &&
-		typeParam != "" {
+	if !isValidTransactionType(typeParam) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction type"})
 		return
 	}
 
-	var date time.Time
-	if dateParam == "" {
-		date = time.Now()
-	} else {
-		timestamp, err := strconv.ParseInt(dateParam, 10, 64)
+	date := time.Now()
+	if dateParam != "" {
+		timestamp, err := timeutils.ParseTimestamp(dateParam)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use a Unix timestamp."})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use RFC 3339 or a Unix timestamp."})
 			return
 		}
-		date = time.Unix(timestamp, 0)
+		date = timestamp.Time
 	}
 
-	startTimestamp, endTimestamp := timeutils.CalculatePeriodBoundaries(date)
+	startTimestamp, endTimestamp, err := resolvePayPeriod(c, uid, date)
+	if err != nil {
+		return
+	}
 
 	expenses, err := models.GetTransactionsForPeriod(startTimestamp, endTimestamp, typeParam, accountParam, uid)
 	if err != nil {
@@ -207,22 +310,17 @@ func (h *Controller) GetTransactionsMonthlyController(c *gin.Context) {
 
 	typeParam := c.Query("type")
 	accountParam := c.Query("account")
-	startDay := c.Query("start_day")
-	endDay := c.Query("end_day")
 
 	// check transaction type is valid or empty
-	if typeParam != models.TransactionTypeExpense &&
-		typeParam != models.TransactionTypeIncome &&
-		typeParam != models.TransactionTypeSavings &&
-		typeParam != models.TransactionTypeTransfer &&
-		typeParam != "" {
+	if !isValidTransactionType(typeParam) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction type"})
 		return
 	}
 
-	startDate, endDate := timeutils.GetSalaryMonthRange(startDay, endDay)
-	startTimestamp := startDate.Unix()
-	endTimestamp := endDate.Unix()
+	startTimestamp, endTimestamp, err := resolvePayPeriod(c, uid, time.Now())
+	if err != nil {
+		return
+	}
 
 	expenses, err := models.GetTransactionsForPeriod(startTimestamp, endTimestamp, typeParam, accountParam, uid)
 	if err != nil {
@@ -231,3 +329,18 @@ func (h *Controller) GetTransactionsMonthlyController(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, expenses)
 }
+
+func (h *Controller) GetRefundsController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	refunds, err := models.GetRefunds(c.Param("id"), uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, refunds)
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/null/v5"
+)
+
+type settleTransactionRequest struct {
+	Amount null.Float          `json:"amount"` // Final amount, omit to keep the pending one
+	Date   timeutils.Timestamp `json:"date"`   // Settlement date, omit to keep the pending one
+}
+
+// transactionStatusError writes the response for an error of settling or voiding
+func transactionStatusError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrInvalidStatus), errors.Is(err, models.ErrReconciled),
+		errors.Is(err, models.ErrInvalidRefund), errors.Is(err, models.ErrInvalidShare), errors.Is(err, models.ErrInvalidSplits):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error changing transaction status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change transaction status"})
+	}
+}
+
+// SettleTransactionController clears a pending transaction with its final amount
+func (h *Controller) SettleTransactionController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request settleTransactionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := models.SettleTransaction(c.Param("id"), request.Amount, request.Date, actor(c, uid))
+	if err != nil {
+		transactionStatusError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, transaction)
+}
+
+// VoidTransactionController cancels a transaction, keeping it listed with
+// status=void
+func (h *Controller) VoidTransactionController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := models.VoidTransaction(c.Param("id"), actor(c, uid), c.Query("override") == "true")
+	if err != nil {
+		transactionStatusError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, transaction)
+}
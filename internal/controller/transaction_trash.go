@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"guilliman/internal/models"
+	"guilliman/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type undoRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// setUndoToken issues an undo token for a change to a transaction and sends
+// it in the X-Undo-Token header, with its expiry in X-Undo-Expires-At. The
+// change stands when no token can be issued.
+func setUndoToken(c *gin.Context, uid string, transactionID string, action string, previous *models.Transaction) {
+	token, err := models.IssueUndoToken(uid, transactionID, action, previous)
+	if err != nil {
+		log.Printf("Error issuing undo token: %v", err)
+		return
+	}
+	c.Header("X-Undo-Token", token.Token)
+	c.Header("X-Undo-Expires-At", token.ExpiresAt.Format(time.RFC3339))
+}
+
+// trashError writes the response for an error of the trash and undo
+func trashError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrAccountArchived), errors.Is(err, models.ErrInvalidRefund), errors.Is(err, models.ErrReconciled),
+		errors.Is(err, models.ErrInvalidStatus), errors.Is(err, models.ErrInvalidShare), errors.Is(err, models.ErrInvalidSplits):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error %s: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed " + action})
+	}
+}
+
+// GetTrashController lists the deleted transactions of the user until they
+// are purged
+func (h *Controller) GetTrashController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	transactions, err := models.GetTrash(uid)
+	if err != nil {
+		trashError(c, err, "retrieving trash")
+		return
+	}
+	c.JSON(http.StatusOK, transactions)
+}
+
+// RestoreTransactionController takes a transaction out of the trash
+func (h *Controller) RestoreTransactionController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := models.RestoreTransaction(c.Param("id"), actor(c, uid))
+	if err != nil {
+		trashError(c, err, "restoring transaction")
+		return
+	}
+	c.JSON(http.StatusOK, transaction)
+}
+
+// UndoController reverts the change an undo token was issued for
+func (h *Controller) UndoController(c *gin.Context) {
+	uid, err := utils.GetUserUID(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request undoRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.Undo(request.Token, actor(c, uid)); err != nil {
+		trashError(c, err, "undoing change")
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 
 	"guilliman/internal/models"
@@ -56,10 +57,23 @@ func (h *Controller) TransferFundsController(c *gin.Context) {
 		return
 	}
 
-	transaction, err := models.AddTransfer(transfer)
+	transaction, err := models.AddTransfer(transfer, actor(c, uid))
 	if err != nil {
+		if errors.Is(err, models.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrInvalidStatus) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrAccountArchived) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	setUndoToken(c, uid, transaction.ID, models.UndoCreate, nil)
 	c.JSON(http.StatusCreated, transaction)
 }
@@ -0,0 +1,51 @@
+// Package jobs runs the maintenance tasks of the server in the background
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// Every runs a job now and then at a fixed interval. A failing job is
+// logged and runs again at the next tick.
+func Every(name string, interval time.Duration, job func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			run(name, job)
+			<-ticker.C
+		}
+	}()
+}
+
+// Daily runs a job every day at a time of day in UTC, given as the time
+// since midnight
+func Daily(name string, at time.Duration, job func() error) {
+	go func() {
+		for {
+			time.Sleep(time.Until(nextRun(time.Now().UTC(), at)))
+			run(name, job)
+		}
+	}()
+}
+
+// nextRun is the first time of day at or after now, in UTC
+func nextRun(now time.Time, at time.Duration) time.Time {
+	next := now.Truncate(24 * time.Hour).Add(at)
+	if next.Before(now) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next
+}
+
+func run(name string, job func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", name, r)
+		}
+	}()
+	if err := job(); err != nil {
+		log.Printf("Job %s failed: %v", name, err)
+	}
+}
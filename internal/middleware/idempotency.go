@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"guilliman/config"
+	"guilliman/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader lets clients retry a POST without repeating its
+// effect: the response to the first request with a key is replayed to the
+// requests repeating it for config.GetIdempotencyKeyTTL
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys accepted from clients
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodySize bounds the bodies read to be hashed, the largest
+// upload a controller accepts: an attachment with its multipart envelope.
+// Controllers apply their own, smaller, limits afterwards.
+const maxIdempotentBodySize = models.MaxAttachmentSize + 1<<20
+
+// replayedHeaders are the headers of a response replayed with it
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "X-Undo-Token", "X-Undo-Expires-At"}
+
+// recordingWriter keeps a copy of the body written to the response
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware handles the Idempotency-Key header of POST requests,
+// after AuthMiddleware since keys are per user. A key sent again with another
+// method, path or body is refused with 422. Failed requests, answered with a
+// 5xx, don't use up their key.
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+		uid := c.GetString("userUID")
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			} else {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			}
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.RequestURI() + "\n"))
+		hash.Write(body)
+
+		stored, err := models.ReserveIdempotencyKey(uid, key, hex.EncodeToString(hash.Sum(nil)), config.GetIdempotencyKeyTTL())
+		switch {
+		case errors.Is(err, models.ErrIdempotencyKeyReused):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		case errors.Is(err, models.ErrIdempotencyKeyInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		case err != nil:
+			log.Printf("Error reserving idempotency key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+			c.Abort()
+			return
+		case stored != nil:
+			for name, value := range stored.Headers {
+				c.Header(name, value)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Status(stored.Status)
+			c.Writer.Write(stored.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := models.ReleaseIdempotencyKey(uid, key); err != nil {
+				log.Printf("Error releasing idempotency key: %v", err)
+			}
+			return
+		}
+		response := models.IdempotentResponse{Status: writer.Status(), Headers: map[string]string{}, Body: writer.body.Bytes()}
+		for _, name := range replayedHeaders {
+			if value := writer.Header().Get(name); value != "" {
+				response.Headers[name] = value
+			}
+		}
+		if err := models.SaveIdempotentResponse(uid, key, response); err != nil {
+			log.Printf("Error storing idempotent response: %v", err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"fmt"
+	"guilliman/internal/utils/timeutils"
 	"time"
 
 	"github.com/guregu/null/v5"
@@ -11,26 +12,125 @@ import (
 
 // Account struct
 type Account struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`     // Name of the account (e.g., "Checking Account", "Credit Card")
-	Type     string  `json:"type"`     // Type of account (e.g., "Bank", "Credit Card", "Cash")
-	Currency string  `json:"currency"` // Currency of the account (e.g., "USD", "EUR")
-	Balance  float64 `json:"balance"`  // Balance of the account (optional)
-	UserID   string  `json:"user_id"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`     // Name of the account (e.g., "Checking Account", "Credit Card")
+	Type        string      `json:"type"`     // Type of account (e.g., "Bank", "Credit Card", "Cash")
+	Currency    string      `json:"currency"` // Currency of the account (e.g., "USD", "EUR")
+	Balance     float64     `json:"balance"`  // Available balance, counting pending transactions
+	UserID      string      `json:"user_id"`
+	HouseholdID null.String `json:"household_id"` // Shared with the members of the household, personal when null
+	Role        string      `json:"role"`         // Role of the requesting user on the account
+
+	ArchivedAt timeutils.Timestamp `json:"archived_at"` // Closed, kept for history and net worth, null on open accounts
+
+	IncludeInNetWorth null.Bool   `json:"include_in_net_worth"` // Counted in the net worth, true unless set
+	IncludeInBudget   null.Bool   `json:"include_in_budget"`    // Its transactions count in budgets and spending, true unless set
+	GroupID           null.String `json:"group_id"`             // Group of the requesting user the account is in, read only
+	Position          int         `json:"position"`             // Position in the group, read only
+
+	Version int `json:"version"` // Changes with every update but those of the balance, sent as the ETag. Read only.
+
+	ClearedBalance float64 `json:"cleared_balance"` // Balance without the pending transactions, read only
+
+	Credit *CreditTerms `json:"credit,omitempty"` // Credit card accounts only
+	Loan   *LoanTerms   `json:"loan,omitempty"`   // Loan accounts only
+
+	Investment *InvestmentTerms `json:"investment,omitempty"` // Investment, brokerage and pension accounts only
 }
 
-// GetAccounts retrieves all accounts for a user
-func GetAccounts(id string, uid string) ([]Account, error) {
+// accountColumns are read by scanAccount from accountsFrom, which only
+// returns the accounts visible to the user given as $1
+var accountColumns = `a.id, a.name, a.type, a.currency, a.balance, a.user_id, a.household_id::text,
+	CASE WHEN a.household_id IS NULL THEN 'owner' ELSE hm.role END,
+	a.credit_limit, a.statement_closing_day, a.payment_due_day, a.minimum_payment_rate, a.minimum_payment_amount,
+	` + loanColumn + `, a.cost_method, a.archived_at,
+	a.include_in_net_worth, a.include_in_budget, ap.group_id::text, COALESCE(ap.position, 0), a.version,
+	a.balance - COALESCE((SELECT SUM(` + balanceEffect("a.id") + `) FROM transactions t
+		WHERE (t.account_id = a.id OR t.related_account_id = a.id) AND t.status = 'pending'), 0)`
+
+const accountsFrom = ` FROM accounts a
+	LEFT JOIN household_members hm ON hm.household_id = a.household_id AND hm.user_id = $1
+	LEFT JOIN account_positions ap ON ap.account_id = a.id AND ap.user_id = $1
+	WHERE ((a.household_id IS NULL AND a.user_id = $1) OR hm.user_id IS NOT NULL)`
+
+// accountOrder sorts accounts by the position of their group and their
+// position in it, ungrouped accounts last
+const accountOrder = ` ORDER BY ap.group_id IS NULL,
+	(SELECT g.position FROM account_groups g WHERE g.id = ap.group_id), ap.group_id, COALESCE(ap.position, 0), a.name`
+
+func scanAccount(row pgx.Row) (Account, error) {
+	var account Account
+	var limit, rate, minimum null.Float
+	var closingDay, dueDay null.Int
+	var costMethod null.String
+	err := row.Scan(
+		&account.ID,
+		&account.Name,
+		&account.Type,
+		&account.Currency,
+		&account.Balance,
+		&account.UserID,
+		&account.HouseholdID,
+		&account.Role,
+		&limit,
+		&closingDay,
+		&dueDay,
+		&rate,
+		&minimum,
+		&account.Loan,
+		&costMethod,
+		&account.ArchivedAt,
+		&account.IncludeInNetWorth,
+		&account.IncludeInBudget,
+		&account.GroupID,
+		&account.Position,
+		&account.Version,
+		&account.ClearedBalance,
+	)
+	if err == nil && account.IsCreditCard() {
+		account.Credit = &CreditTerms{
+			Limit:                limit.Float64,
+			StatementClosingDay:  int(closingDay.Int64),
+			PaymentDueDay:        int(dueDay.Int64),
+			MinimumPaymentRate:   rate.Float64,
+			MinimumPaymentAmount: minimum.Float64,
+			AvailableCredit:      availableCredit(limit.Float64, account.Balance),
+		}
+	}
+	if err == nil && account.IsInvestment() {
+		account.Investment = &InvestmentTerms{CostMethod: costMethod.ValueOrZero()}
+		if account.Investment.CostMethod == "" {
+			account.Investment.CostMethod = CostFIFO
+		}
+	}
+	return account, err
+}
+
+// balanceEffect is the change a transaction, aliased as t, made to the
+// balance of the account given as a placeholder: the sum of its postings on
+// the account, see entryPostings.
+func balanceEffect(account string) string {
+	return `COALESCE((SELECT SUM(p.amount) FROM postings p WHERE p.transaction_id = t.id AND p.account_id = ` + account + `), 0)`
+}
+
+// GetAccounts retrieves the personal accounts of a user and the accounts of
+// their households, in the order of the user's account groups. Archived
+// accounts are left out unless asked for.
+func GetAccounts(id string, includeArchived bool, uid string) ([]Account, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := "SELECT id, name, type, currency, balance FROM accounts WHERE user_id = $1"
+	query := "SELECT " + accountColumns + accountsFrom
 	args := []interface{}{uid}
 
 	if id != "" {
-		query += " AND id = $2"
+		query += " AND a.id::text = $2"
 		args = append(args, id)
 	}
+	if !includeArchived {
+		query += " AND a.archived_at IS NULL"
+	}
+	query += accountOrder
 
 	rows, err := db.Query(ctx, query, args...)
 	if err != nil {
@@ -40,8 +140,8 @@ func GetAccounts(id string, uid string) ([]Account, error) {
 
 	var accounts []Account
 	for rows.Next() {
-		var account Account
-		if err := rows.Scan(&account.ID, &account.Name, &account.Type, &account.Currency, &account.Balance); err != nil {
+		account, err := scanAccount(rows)
+		if err != nil {
 			return nil, err
 		}
 		accounts = append(accounts, account)
@@ -50,24 +150,15 @@ func GetAccounts(id string, uid string) ([]Account, error) {
 	return accounts, nil
 }
 
-// GetAccountByID retrieves a single account by ID and user ID
+// GetAccountByID retrieves a single account visible to the user
 func GetAccountByID(id null.String, uid string) (Account, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := "SELECT id, name, type, currency, balance FROM accounts WHERE id = $1 AND user_id = $2"
-
-	var account Account
-	err := db.QueryRow(ctx, query, id, uid).Scan(
-		&account.ID,
-		&account.Name,
-		&account.Type,
-		&account.Currency,
-		&account.Balance,
-	)
+	account, err := scanAccount(db.QueryRow(ctx, "SELECT "+accountColumns+accountsFrom+" AND a.id::text = $2", uid, id.String))
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return Account{}, fmt.Errorf("no account found with ID %d", id)
+			return Account{}, fmt.Errorf("%w: no account found with ID %s", ErrNotFound, id.String)
 		}
 		return Account{}, err
 	}
@@ -75,64 +166,269 @@ func GetAccountByID(id null.String, uid string) (Account, error) {
 	return account, nil
 }
 
-// AddAccount inserts a new account into the database
-func AddAccount(account Account) (Account, error) {
+// getWritableAccount retrieves an account the user can record transactions in
+func getWritableAccount(id null.String, uid string) (Account, error) {
+	account, err := GetAccountByID(id, uid)
+	if err != nil {
+		return Account{}, err
+	}
+	if !canWrite(account.Role) {
+		return Account{}, fmt.Errorf("%w: viewers can't change account %s", ErrForbidden, account.Name)
+	}
+	if err := account.checkOpen(); err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+// AddAccount inserts a new account into the database, in a household when
+// the user can edit it. The balance is posted as an opening balance
+// adjustment.
+func AddAccount(account Account, actor Actor) (Account, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO accounts (name, type, currency, balance, user_id)
-	          VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	if err := prepareCreditTerms(&account); err != nil {
+		return Account{}, err
+	}
+	if err := prepareLoanTerms(&account); err != nil {
+		return Account{}, err
+	}
+	if err := prepareInvestmentTerms(&account); err != nil {
+		return Account{}, err
+	}
+
+	if !account.IncludeInNetWorth.Valid {
+		account.IncludeInNetWorth = null.BoolFrom(true)
+	}
+	if !account.IncludeInBudget.Valid {
+		account.IncludeInBudget = null.BoolFrom(true)
+	}
+
+	account.Role = RoleOwner
+	if account.HouseholdID.String == "" {
+		account.HouseholdID = null.String{}
+	} else {
+		role, err := requireHouseholdRole(ctx, db, account.HouseholdID.String, account.UserID, RoleOwner, RoleEditor)
+		if err != nil {
+			return Account{}, err
+		}
+		account.Role = role
+	}
+
+	query := `INSERT INTO accounts (name, type, currency, balance, user_id, household_id,
+	            credit_limit, statement_closing_day, payment_due_day, minimum_payment_rate, minimum_payment_amount, cost_method,
+	            include_in_net_worth, include_in_budget)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id, version`
 
-	err := db.QueryRow(ctx, query, account.Name, account.Type, account.Currency, account.Balance, account.UserID).Scan(&account.ID)
+	tx, err := db.Begin(ctx)
 	if err != nil {
+		return Account{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	limit, closingDay, dueDay, rate, minimum := account.Credit.columns()
+	err = tx.QueryRow(ctx, query, account.Name, account.Type, account.Currency, 0, account.UserID, account.HouseholdID,
+		limit, closingDay, dueDay, rate, minimum, account.Investment.costMethod(),
+		account.IncludeInNetWorth, account.IncludeInBudget).Scan(&account.ID, &account.Version)
+	if err != nil {
+		return Account{}, err
+	}
+	if roundCents(account.Balance) != 0 {
+		opening := balanceAdjustment(account, account.Balance, SubcategoryOpeningBalance, account.UserID)
+		if _, err := postAdjustment(ctx, tx, opening, actor); err != nil {
+			return Account{}, err
+		}
+	}
+	account.ClearedBalance = account.Balance
+	if account.Loan != nil {
+		if err := saveLoanTerms(ctx, tx, account.ID, account.Loan); err != nil {
+			return Account{}, err
+		}
+	}
+	if err := auditAccount(ctx, tx, actor, AuditCreate, nil, account.ID); err != nil {
 		return Account{}, err
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return Account{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	if account.Credit != nil {
+		account.Credit.AvailableCredit = availableCredit(account.Credit.Limit, account.Balance)
+	}
+
 	return account, nil
 }
 
-// UpdateAccount updates an existing account
-func UpdateAccount(account Account) (Account, error) {
+// UpdateAccount updates an existing account. Household accounts can be
+// updated by owners and editors, the household is changed through the
+// household endpoints. The balance isn't written directly, a new balance is
+// reached with an adjustment transaction. A version in the account must be
+// the stored one.
+func UpdateAccount(account Account, balance null.Float, actor Actor) (Account, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	existing, err := getWritableAccount(null.StringFrom(account.ID), account.UserID)
+	if err != nil {
+		return Account{}, err
+	}
+
+	// Credit, loan and investment terms not sent are kept, they are dropped when the
+	// account changes type
+	if account.Type == "" {
+		account.Type = existing.Type
+	}
+	if account.Credit == nil {
+		account.Credit = existing.Credit
+	}
+	if account.Loan == nil {
+		account.Loan = existing.Loan
+	}
+	if account.Investment == nil {
+		account.Investment = existing.Investment
+	}
+	if err := prepareCreditTerms(&account); err != nil {
+		return Account{}, err
+	}
+	if err := prepareLoanTerms(&account); err != nil {
+		return Account{}, err
+	}
+	if err := prepareInvestmentTerms(&account); err != nil {
+		return Account{}, err
+	}
+
 	query := `
 		UPDATE accounts
 		SET name = COALESCE(NULLIF($1, ''), name),
 			type = COALESCE(NULLIF($2, ''), type),
 			currency = COALESCE(NULLIF($3, ''), currency),
-			balance = COALESCE(NULLIF($4, 0), balance)
-		WHERE id = $5 AND user_id = $6`
+			credit_limit = $5,
+			statement_closing_day = $6,
+			payment_due_day = $7,
+			minimum_payment_rate = $8,
+			minimum_payment_amount = $9,
+			cost_method = $10,
+			include_in_net_worth = COALESCE($11, include_in_net_worth),
+			include_in_budget = COALESCE($12, include_in_budget)
+		WHERE id = $4`
 
-	result, err := db.Exec(ctx, query, account.Name, account.Type, account.Currency, account.Balance, account.ID, account.UserID)
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := accountSnapshot(ctx, tx, existing.ID, actor)
+	if err != nil {
+		return Account{}, err
+	}
+	if before == nil {
+		return Account{}, fmt.Errorf("%w: no account found with ID %s", ErrNotFound, existing.ID)
+	}
+	if err := checkVersion(account.Version, before.Version, "the account"); err != nil {
+		return Account{}, err
+	}
+
+	limit, closingDay, dueDay, rate, minimum := account.Credit.columns()
+	result, err := tx.Exec(ctx, query, account.Name, account.Type, account.Currency, account.ID,
+		limit, closingDay, dueDay, rate, minimum, account.Investment.costMethod(), account.IncludeInNetWorth, account.IncludeInBudget)
 	if err != nil {
 		return Account{}, fmt.Errorf("failed to update account: %v", err)
 	}
 
 	rowsAffected := result.RowsAffected()
 	if rowsAffected == 0 {
-		return Account{}, fmt.Errorf("no account found with ID %d", account.ID)
+		return Account{}, fmt.Errorf("no account found with ID %s", account.ID)
 	}
 
-	return account, nil
+	if err := saveLoanTerms(ctx, tx, account.ID, account.Loan); err != nil {
+		return Account{}, err
+	}
+
+	if balance.Valid {
+		var current float64
+		if err := tx.QueryRow(ctx, "SELECT balance FROM accounts WHERE id = $1 FOR UPDATE", account.ID).Scan(&current); err != nil {
+			return Account{}, fmt.Errorf("failed to lock account: %v", err)
+		}
+		if difference := roundCents(balance.Float64 - current); difference != 0 {
+			if account.Currency != "" {
+				existing.Currency = account.Currency
+			}
+			adjustment := balanceAdjustment(existing, difference, SubcategoryBalanceAdjustment, account.UserID)
+			if _, err := postAdjustment(ctx, tx, adjustment, actor); err != nil {
+				return Account{}, err
+			}
+		}
+	}
+	if err := auditAccount(ctx, tx, actor, AuditUpdate, before, account.ID); err != nil {
+		return Account{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Account{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return GetAccountByID(null.StringFrom(account.ID), account.UserID)
 }
 
-// DeleteAccount removes an account from the database
-func DeleteAccount(id string, uid string) error {
+// DeleteAccount removes an empty account from the database. Accounts with
+// transactions are archived instead, or purged with PurgeAccount. Household
+// accounts can only be deleted by the household's owners. A version other
+// than 0 must be the stored one.
+func DeleteAccount(id string, version int, actor Actor) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	uid := actor.UserID
 
-	query := "DELETE FROM accounts WHERE id = $1 AND user_id = $2"
+	account, err := GetAccountByID(null.StringFrom(id), uid)
+	if err != nil {
+		return err
+	}
+	if account.Role != RoleOwner {
+		return fmt.Errorf("%w: only owners can delete account %s", ErrForbidden, account.Name)
+	}
 
-	result, err := db.Exec(ctx, query, id, uid)
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := accountSnapshot(ctx, tx, account.ID, actor)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return fmt.Errorf("%w: no account found with ID %s", ErrNotFound, id)
+	}
+	if err := checkVersion(version, before.Version, "the account"); err != nil {
+		return err
+	}
+	var count int
+	err = tx.QueryRow(ctx, "SELECT COUNT(*) FROM transactions WHERE account_id = $1 OR related_account_id = $1", account.ID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to count transactions: %v", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s has %d transactions, archive it or purge it", ErrAccountNotEmpty, account.Name, count)
+	}
+
+	result, err := tx.Exec(ctx, "DELETE FROM accounts WHERE id = $1", account.ID)
 	if err != nil {
 		return err
 	}
 
 	rowsAffected := result.RowsAffected()
 	if rowsAffected == 0 {
-		return fmt.Errorf("no account found with ID %d", id)
+		return fmt.Errorf("no account found with ID %s", id)
+	}
+	if err := auditAccount(ctx, tx, actor, AuditDelete, before, account.ID); err != nil {
+		return err
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
 	return nil
 }
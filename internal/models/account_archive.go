@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/guregu/null/v5"
+)
+
+var (
+	// ErrAccountArchived is returned when recording transactions in an archived account
+	ErrAccountArchived = errors.New("account is archived")
+	// ErrAccountNotEmpty is returned when deleting an account that has transactions
+	ErrAccountNotEmpty = errors.New("account has transactions")
+	// ErrPurgeNotConfirmed is returned when a purge isn't confirmed with the account's name
+	ErrPurgeNotConfirmed = errors.New("purge not confirmed")
+)
+
+// checkOpen refuses changes to the transactions of an archived account
+func (a Account) checkOpen() error {
+	if !a.ArchivedAt.IsZero() {
+		return fmt.Errorf("%w: reopen %s to record transactions in it", ErrAccountArchived, a.Name)
+	}
+	return nil
+}
+
+// ArchiveAccount closes an account. It is left out of the account lists and
+// refuses new transactions, its transactions stay in the history and its
+// balance in the net worth.
+func ArchiveAccount(id string, actor Actor) (Account, error) {
+	return setArchived(id, true, actor)
+}
+
+// UnarchiveAccount reopens an archived account
+func UnarchiveAccount(id string, actor Actor) (Account, error) {
+	return setArchived(id, false, actor)
+}
+
+func setArchived(id string, archived bool, actor Actor) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	account, err := GetAccountByID(null.StringFrom(id), uid)
+	if err != nil {
+		return Account{}, err
+	}
+	if !canWrite(account.Role) {
+		return Account{}, fmt.Errorf("%w: viewers can't change account %s", ErrForbidden, account.Name)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := accountSnapshot(ctx, tx, account.ID, actor)
+	if err != nil {
+		return Account{}, err
+	}
+	query := "UPDATE accounts SET archived_at = CURRENT_TIMESTAMP WHERE id = $1 AND archived_at IS NULL"
+	if !archived {
+		query = "UPDATE accounts SET archived_at = NULL WHERE id = $1"
+	}
+	if _, err := tx.Exec(ctx, query, account.ID); err != nil {
+		return Account{}, fmt.Errorf("failed to archive account: %v", err)
+	}
+	if err := auditAccount(ctx, tx, actor, AuditUpdate, before, account.ID); err != nil {
+		return Account{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Account{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return GetAccountByID(null.StringFrom(account.ID), uid)
+}
+
+// PurgeAccount deletes an account with all its transactions, confirmed by
+// the account's name. Transfers from other accounts lose their related
+// account and stay as plain transfers, refunds of its expenses stay as plain
+// income. Only the household's owners can purge household accounts.
+func PurgeAccount(id string, confirm string, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	account, err := GetAccountByID(null.StringFrom(id), uid)
+	if err != nil {
+		return err
+	}
+	if account.Role != RoleOwner {
+		return fmt.Errorf("%w: only owners can purge account %s", ErrForbidden, account.Name)
+	}
+	if confirm != account.Name {
+		return fmt.Errorf("%w: confirm with the name of the account, '%s'", ErrPurgeNotConfirmed, account.Name)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := accountSnapshot(ctx, tx, account.ID, actor)
+	if err != nil {
+		return err
+	}
+
+	owned, err := transactionIDs(ctx, tx, "account_id = $1", account.ID)
+	if err != nil {
+		return err
+	}
+	related, err := transactionIDs(ctx, tx, "related_account_id = $1 AND account_id IS DISTINCT FROM $1", account.ID)
+	if err != nil {
+		return err
+	}
+
+	// Take the transactions of the account out of the balances of the
+	// accounts they moved money to, the related ones post to a nominal
+	// account instead of this one
+	if err := unpostEntries(ctx, tx, owned...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE transactions SET related_account_id = NULL WHERE id::text = ANY($1)", related); err != nil {
+		return fmt.Errorf("failed to detach transfers: %v", err)
+	}
+	if err := postEntries(ctx, tx, related...); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "UPDATE transactions SET refund_of_id = NULL WHERE refund_of_id::text = ANY($1) AND NOT (id::text = ANY($1))", owned)
+	if err != nil {
+		return fmt.Errorf("failed to detach refunds: %v", err)
+	}
+
+	// The transactions of the account cascade, so do their attachments
+	attachments, err := attachmentKeys(ctx, tx, "t.account_id = $1", account.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM accounts WHERE id = $1", account.ID); err != nil {
+		return fmt.Errorf("failed to purge account: %v", err)
+	}
+	if err := auditAccount(ctx, tx, actor, AuditPurge, before, account.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	log.Printf("Purged account %s with %d transactions", account.ID, len(owned))
+
+	deleteBlobs(attachments)
+	return nil
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInvalidGroup is returned when an account group or its order is invalid
+var ErrInvalidGroup = errors.New("invalid account group")
+
+// AccountGroup arranges the accounts of a user on dashboards. Groups are
+// personal: members of a household place its accounts in their own groups.
+type AccountGroup struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Position int       `json:"position"`
+	Subtotal float64   `json:"subtotal"` // Balances of its accounts in the base currency, read only
+	Accounts []Account `json:"accounts"` // In their order in the group, read only
+}
+
+// GetAccountGroups lists the account groups of a user in order with their
+// open accounts. The accounts in no group come last, in a group without ID.
+func GetAccountGroups(uid string) ([]AccountGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT id, name, position FROM account_groups
+		WHERE user_id = $1
+		ORDER BY position, name`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve account groups: %v", err)
+	}
+	defer rows.Close()
+
+	groups := []AccountGroup{}
+	index := map[string]int{}
+	for rows.Next() {
+		group := AccountGroup{Accounts: []Account{}}
+		if err := rows.Scan(&group.ID, &group.Name, &group.Position); err != nil {
+			return nil, fmt.Errorf("failed to scan account group: %v", err)
+		}
+		index[group.ID] = len(groups)
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	accounts, err := GetAccounts("", false, uid)
+	if err != nil {
+		return nil, err
+	}
+	ungrouped := AccountGroup{Position: len(groups), Accounts: []Account{}}
+	for _, account := range accounts {
+		group := &ungrouped
+		if i, ok := index[account.GroupID.String]; ok {
+			group = &groups[i]
+		}
+		group.Accounts = append(group.Accounts, account)
+		group.Subtotal += account.Balance * exchangeRateOf(account.Currency)
+	}
+	if len(ungrouped.Accounts) > 0 {
+		groups = append(groups, ungrouped)
+	}
+	for i := range groups {
+		groups[i].Subtotal = roundCents(groups[i].Subtotal)
+	}
+	return groups, nil
+}
+
+// AddAccountGroup adds an empty group after the other groups of the user
+func AddAccountGroup(group AccountGroup, uid string) (AccountGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return AccountGroup{}, fmt.Errorf("%w: a name is required", ErrInvalidGroup)
+	}
+
+	err := db.QueryRow(ctx, `
+		INSERT INTO account_groups (user_id, name, position)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(position) + 1, 0) FROM account_groups WHERE user_id = $1))
+		RETURNING id, position`, uid, group.Name).Scan(&group.ID, &group.Position)
+	if err != nil {
+		return AccountGroup{}, fmt.Errorf("failed to add account group: %v", err)
+	}
+	group.Accounts = []Account{}
+	return group, nil
+}
+
+// RenameAccountGroup changes the name of a group of the user
+func RenameAccountGroup(id string, name string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("%w: a name is required", ErrInvalidGroup)
+	}
+
+	result, err := db.Exec(ctx, "UPDATE account_groups SET name = $3 WHERE id::text = $1 AND user_id = $2", id, uid, name)
+	if err != nil {
+		return fmt.Errorf("failed to rename account group: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: account group %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// DeleteAccountGroup removes a group of the user, its accounts become
+// ungrouped
+func DeleteAccountGroup(id string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, "DELETE FROM account_groups WHERE id::text = $1 AND user_id = $2", id, uid)
+	if err != nil {
+		return fmt.Errorf("failed to delete account group: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: account group %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// OrderAccountGroups sets the order of the groups of the user, which must
+// all be given
+func OrderAccountGroups(ids []string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var count, matched int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE id::text = ANY($2))
+		FROM account_groups WHERE user_id = $1`, uid, ids).Scan(&count, &matched)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve account groups: %v", err)
+	}
+	if len(ids) != count || matched != count {
+		return fmt.Errorf("%w: give each of your %d groups once", ErrInvalidGroup, count)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE account_groups g SET position = o.n - 1
+		FROM unnest($2::text[]) WITH ORDINALITY o(id, n)
+		WHERE g.id::text = o.id AND g.user_id = $1`, uid, ids)
+	if err != nil {
+		return fmt.Errorf("failed to order account groups: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return nil
+}
+
+// SetGroupAccounts places accounts in a group of the user in the given
+// order, moving them from their previous group. The accounts the group had
+// and aren't given become ungrouped.
+func SetGroupAccounts(groupID string, accountIDs []string, uid string) (AccountGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if accountIDs == nil {
+		accountIDs = []string{}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return AccountGroup{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	group := AccountGroup{Accounts: []Account{}}
+	err = tx.QueryRow(ctx, "SELECT id, name, position FROM account_groups WHERE id::text = $1 AND user_id = $2 FOR UPDATE",
+		groupID, uid).Scan(&group.ID, &group.Name, &group.Position)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return AccountGroup{}, fmt.Errorf("%w: account group %s", ErrNotFound, groupID)
+		}
+		return AccountGroup{}, fmt.Errorf("failed to retrieve account group: %v", err)
+	}
+
+	var visible int
+	err = tx.QueryRow(ctx, "SELECT COUNT(*) FROM accounts WHERE id::text = ANY($2) AND id IN ("+visibleAccounts("$1")+")",
+		uid, accountIDs).Scan(&visible)
+	if err != nil {
+		return AccountGroup{}, fmt.Errorf("failed to retrieve accounts: %v", err)
+	}
+	if visible != len(accountIDs) {
+		return AccountGroup{}, fmt.Errorf("%w: give each account once, among the accounts you can see", ErrInvalidGroup)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE account_positions SET group_id = NULL, position = 0
+		WHERE user_id = $1 AND group_id = $2 AND NOT (account_id::text = ANY($3))`, uid, group.ID, accountIDs)
+	if err != nil {
+		return AccountGroup{}, fmt.Errorf("failed to ungroup accounts: %v", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO account_positions (user_id, account_id, group_id, position)
+		SELECT $1, a.id, $2, o.n - 1
+		FROM unnest($3::text[]) WITH ORDINALITY o(id, n)
+		JOIN accounts a ON a.id::text = o.id
+		ON CONFLICT (user_id, account_id) DO UPDATE SET
+			group_id = EXCLUDED.group_id,
+			position = EXCLUDED.position`, uid, group.ID, accountIDs)
+	if err != nil {
+		return AccountGroup{}, fmt.Errorf("failed to group accounts: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return AccountGroup{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	groups, err := GetAccountGroups(uid)
+	if err != nil {
+		return AccountGroup{}, err
+	}
+	for _, g := range groups {
+		if g.ID == group.ID {
+			return g, nil
+		}
+	}
+	return group, nil
+}
@@ -0,0 +1,311 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/storage"
+	"guilliman/internal/utils/imageutils"
+	"guilliman/internal/utils/timeutils"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxAttachmentSize is the largest file accepted as an attachment
+const MaxAttachmentSize = 10 << 20
+
+// attachmentTypes are the accepted content types, sniffed from the file
+// itself rather than trusted from the client
+var attachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+}
+
+// thumbnailTypes are the image types imageutils can decode
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// ErrInvalidAttachment wraps errors caused by the uploaded file
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// Attachment is a receipt or document kept with a transaction
+type Attachment struct {
+	ID            string              `json:"id"`
+	TransactionID string              `json:"transaction_id"`
+	FileName      string              `json:"file_name"`
+	ContentType   string              `json:"content_type"`
+	Size          int64               `json:"size"`
+	HasThumbnail  bool                `json:"has_thumbnail"`
+	CreatedAt     timeutils.Timestamp `json:"created_at"`
+	UserID        string              `json:"user_id"`
+
+	StorageKey   string      `json:"-"`
+	ThumbnailKey null.String `json:"-"`
+}
+
+var blobs storage.BlobStore
+
+// InitializeBlobStore opens the attachment storage selected in the config
+func InitializeBlobStore() error {
+	store, err := storage.NewFromConfig()
+	if err != nil {
+		return err
+	}
+	blobs = store
+	return nil
+}
+
+const attachmentColumns = "id, transaction_id, file_name, content_type, size, created_at, user_id, storage_key, thumbnail_key"
+
+func scanAttachment(row pgx.Row) (Attachment, error) {
+	var attachment Attachment
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.TransactionID,
+		&attachment.FileName,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.CreatedAt,
+		&attachment.UserID,
+		&attachment.StorageKey,
+		&attachment.ThumbnailKey,
+	)
+	attachment.HasThumbnail = attachment.ThumbnailKey.Valid
+	return attachment, err
+}
+
+// GetAttachments lists the attachments of a transaction
+func GetAttachments(transactionID string, uid string) ([]Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, "SELECT "+attachmentColumns+`
+		FROM attachments
+		WHERE transaction_id::text = $1
+		  AND transaction_id IN (SELECT t.id FROM transactions t WHERE `+visibleTransaction("$2")+`)
+		ORDER BY created_at`, transactionID, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve attachments: %v", err)
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %v", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+// GetAttachment returns an attachment of a transaction visible to the user
+func GetAttachment(id string, uid string) (Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	attachment, err := scanAttachment(db.QueryRow(ctx, "SELECT "+attachmentColumns+`
+		FROM attachments
+		WHERE id::text = $1
+		  AND transaction_id IN (SELECT t.id FROM transactions t WHERE `+visibleTransaction("$2")+`)`, id, uid))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Attachment{}, fmt.Errorf("%w: attachment %s", ErrNotFound, id)
+		}
+		return Attachment{}, fmt.Errorf("failed to retrieve attachment: %v", err)
+	}
+
+	return attachment, nil
+}
+
+// AddAttachment stores a file for a transaction of the user, with a
+// thumbnail when it is an image
+func AddAttachment(transactionID string, uid string, fileName string, data []byte) (Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if len(data) == 0 {
+		return Attachment{}, fmt.Errorf("%w: the file is empty", ErrInvalidAttachment)
+	}
+	if len(data) > MaxAttachmentSize {
+		return Attachment{}, fmt.Errorf("%w: files can be at most %d MB", ErrInvalidAttachment, MaxAttachmentSize>>20)
+	}
+
+	contentType := strings.TrimSpace(strings.Split(http.DetectContentType(data), ";")[0])
+	if !attachmentTypes[contentType] {
+		return Attachment{}, fmt.Errorf("%w: %s files are not accepted, use PDF, JPEG, PNG, GIF or WebP", ErrInvalidAttachment, contentType)
+	}
+
+	var writable bool
+	err := db.QueryRow(ctx, "SELECT "+writableTransaction("$2")+" FROM transactions t WHERE t.id::text = $1 AND "+visibleTransaction("$2"),
+		transactionID, uid).Scan(&writable)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Attachment{}, fmt.Errorf("%w: transaction %s", ErrNotFound, transactionID)
+		}
+		return Attachment{}, fmt.Errorf("failed to retrieve transaction: %v", err)
+	}
+	if !writable {
+		return Attachment{}, fmt.Errorf("%w: viewers can't attach files", ErrForbidden)
+	}
+
+	attachment := Attachment{
+		TransactionID: transactionID,
+		FileName:      fileName,
+		ContentType:   contentType,
+		Size:          int64(len(data)),
+		UserID:        uid,
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO attachments (transaction_id, file_name, content_type, size, user_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		transactionID, fileName, contentType, attachment.Size, uid,
+	).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to insert attachment: %v", err)
+	}
+
+	// Keys are only known to the server, file names never reach the store
+	attachment.StorageKey = fmt.Sprintf("%s/%s/%s", uid, transactionID, attachment.ID)
+	if err := blobs.Put(ctx, attachment.StorageKey, data, contentType); err != nil {
+		return Attachment{}, fmt.Errorf("failed to store attachment: %v", err)
+	}
+
+	if thumbnailTypes[contentType] {
+		thumbnail, err := imageutils.Thumbnail(data)
+		if err != nil {
+			log.Printf("Warning: no thumbnail for attachment %s: %v", attachment.ID, err)
+		} else {
+			key := attachment.StorageKey + "-thumbnail.jpg"
+			if err := blobs.Put(ctx, key, thumbnail, "image/jpeg"); err != nil {
+				log.Printf("Warning: failed to store thumbnail of attachment %s: %v", attachment.ID, err)
+			} else {
+				attachment.ThumbnailKey = null.StringFrom(key)
+				attachment.HasThumbnail = true
+			}
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE attachments SET storage_key = $1, thumbnail_key = $2 WHERE id = $3",
+		attachment.StorageKey, attachment.ThumbnailKey, attachment.ID)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		deleteBlobs([]string{attachment.StorageKey, attachment.ThumbnailKey.String})
+		return Attachment{}, fmt.Errorf("failed to save attachment: %v", err)
+	}
+
+	return attachment, nil
+}
+
+// OpenAttachment reads the file of an attachment, or its thumbnail. The
+// caller closes the reader once the file has been sent.
+func OpenAttachment(attachment Attachment, thumbnail bool) (io.ReadCloser, error) {
+	key := attachment.StorageKey
+	if thumbnail {
+		if !attachment.ThumbnailKey.Valid {
+			return nil, fmt.Errorf("%w: attachment %s has no thumbnail", ErrNotFound, attachment.ID)
+		}
+		key = attachment.ThumbnailKey.String
+	}
+
+	// No deadline here since the body is streamed after this returns
+	reader, err := blobs.Get(context.Background(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("%w: file of attachment %s", ErrNotFound, attachment.ID)
+		}
+		return nil, err
+	}
+	return reader, nil
+}
+
+// DeleteAttachment removes an attachment and its files
+func DeleteAttachment(id string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var key string
+	var thumbnailKey null.String
+	err := db.QueryRow(ctx, `
+		DELETE FROM attachments
+		WHERE id::text = $1
+		  AND transaction_id IN (SELECT t.id FROM transactions t WHERE `+writableTransaction("$2")+`)
+		RETURNING storage_key, thumbnail_key`,
+		id, uid).Scan(&key, &thumbnailKey)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("%w: attachment %s", ErrNotFound, id)
+		}
+		return fmt.Errorf("failed to delete attachment: %v", err)
+	}
+
+	deleteBlobs([]string{key, thumbnailKey.String})
+	return nil
+}
+
+// attachmentKeys returns the storage keys of the attachments of the
+// transactions matching the condition, to delete their files once the
+// rows are gone
+func attachmentKeys(ctx context.Context, tx pgx.Tx, condition string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT a.storage_key, a.thumbnail_key
+		FROM attachments a
+		JOIN transactions t ON t.id = a.transaction_id
+		WHERE `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve attachments: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		var thumbnailKey null.String
+		if err := rows.Scan(&key, &thumbnailKey); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key, thumbnailKey.String)
+	}
+
+	return keys, rows.Err()
+}
+
+// deleteBlobs removes files from the store. Failures only leave orphaned
+// files behind, so they are logged instead of failing the caller.
+func deleteBlobs(keys []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := blobs.Delete(ctx, key); err != nil {
+			log.Printf("Warning: failed to delete blob %s: %v", key, err)
+		}
+	}
+}
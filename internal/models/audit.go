@@ -0,0 +1,344 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	AuditSourceAPI       = "api"       // Requests of the user
+	AuditSourceImport    = "import"    // Requests of an importer acting for the user
+	AuditSourceRecurring = "recurring" // Requests of a recurring transactions scheduler
+	AuditSourceJob       = "job"       // Background jobs of the server, without actor
+)
+
+const (
+	AuditTransaction = "transaction"
+	AuditAccount     = "account"
+	AuditCategory    = "category"
+)
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"  // Deleted, transactions go to the trash
+	AuditRestore = "restore" // Taken out of the trash
+	AuditPurge   = "purge"   // Deleted for good
+)
+
+// auditSources are the sources clients can declare for their requests
+var auditSources = map[string]bool{AuditSourceAPI: true, AuditSourceImport: true, AuditSourceRecurring: true}
+
+// ValidAuditSource tells whether a client can declare a source
+func ValidAuditSource(source string) bool {
+	return auditSources[source]
+}
+
+// AuditChange is the value of a field before and after a change, null when
+// the entity didn't exist before or doesn't after
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditEntry records a change to a transaction, account or category.
+// Entries are only ever added, the database refuses changing them.
+type AuditEntry struct {
+	ID         int64                  `json:"id"`
+	CreatedAt  timeutils.Timestamp    `json:"created_at"`
+	UserID     string                 `json:"user_id"`  // Whose log the entry is in
+	ActorID    null.String            `json:"actor_id"` // Null for background jobs
+	RequestID  null.String            `json:"request_id"`
+	Source     string                 `json:"source"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   string                 `json:"entity_id"`
+	Action     string                 `json:"action"`
+	Changes    map[string]AuditChange `json:"changes"` // Changed fields only
+}
+
+// AuditQuery selects entries of the audit log of a user, last first
+type AuditQuery struct {
+	EntityType string
+	EntityID   string
+	Source     string
+	Before     int64 // Only entries older than this id, to page
+	Limit      int
+}
+
+// auditChanges compares the JSON fields of an entity before and after a
+// change. Either side can be nil for creations and deletions.
+func auditChanges(before any, after any) (map[string]AuditChange, error) {
+	fields := func(entity any) (map[string]any, error) {
+		values := map[string]any{}
+		if entity == nil {
+			return values, nil
+		}
+		data, err := json.Marshal(entity)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]AuditChange{}
+	for field, value := range old {
+		if next, ok := updated[field]; !ok || !reflect.DeepEqual(value, next) {
+			changes[field] = AuditChange{Before: value, After: updated[field]}
+		}
+	}
+	for field, value := range updated {
+		if _, ok := old[field]; !ok {
+			changes[field] = AuditChange{After: value}
+		}
+	}
+	return changes, nil
+}
+
+// Actor is who makes a change and through what, recorded with the change in
+// the audit log
+type Actor struct {
+	UserID    string
+	RequestID null.String
+	Source    string // One of the sources clients can declare, AuditSourceAPI when empty
+}
+
+// recordAudit adds an entry to the audit log with the changes the actor made
+// to an entity, within the database transaction making them so that the
+// change fails when it can't be recorded. Updates that changed nothing
+// aren't recorded.
+func recordAudit(ctx context.Context, tx pgx.Tx, actor Actor, entityType string, entityID string, action string, before any, after any) error {
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return fmt.Errorf("failed to compare %s %s: %v", entityType, entityID, err)
+	}
+	if len(changes) == 0 && action == AuditUpdate {
+		return nil
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode changes: %v", err)
+	}
+	if !ValidAuditSource(actor.Source) {
+		actor.Source = AuditSourceAPI
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO audit_log (user_id, actor_id, request_id, source, entity_type, entity_id, action, changes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		actor.UserID, actor.UserID, actor.RequestID, actor.Source, entityType, entityID, action, data)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %v", err)
+	}
+	return nil
+}
+
+// transactionSnapshots reads and locks transactions as they are in a
+// database transaction, for the audit log. Those in the trash are left out.
+func transactionSnapshots(ctx context.Context, tx pgx.Tx, ids []string) (map[string]*Transaction, error) {
+	snapshots, _, err := lockSnapshots(ctx, tx, " WHERE t.id::text = ANY($1) AND t.deleted_at IS NULL", ids)
+	return snapshots, err
+}
+
+// lockSnapshots reads and locks the transactions, aliased as t, matching a
+// WHERE clause and returns them with their ids
+func lockSnapshots(ctx context.Context, tx pgx.Tx, where string, args ...any) (map[string]*Transaction, []string, error) {
+	rows, err := tx.Query(ctx, "SELECT "+transactionColumns+" FROM transactions t"+where+" FOR UPDATE OF t", args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve transactions: %v", err)
+	}
+	defer rows.Close()
+
+	snapshots := map[string]*Transaction{}
+	ids := []string{}
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		snapshots[transaction.ID] = &transaction
+		ids = append(ids, transaction.ID)
+	}
+	return snapshots, ids, rows.Err()
+}
+
+// auditTransactions records a change the actor made to transactions,
+// comparing their snapshots from before the change with how they are now
+func auditTransactions(ctx context.Context, tx pgx.Tx, actor Actor, action string, before map[string]*Transaction, ids ...string) error {
+	after, err := transactionSnapshots(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if before[id] == nil && after[id] == nil {
+			continue
+		}
+		if err := recordAudit(ctx, tx, actor, AuditTransaction, id, action, before[id], after[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountSnapshot reads and locks an account the actor can see as it is in a
+// database transaction, for the audit log. It is nil once deleted.
+func accountSnapshot(ctx context.Context, tx pgx.Tx, id string, actor Actor) (*Account, error) {
+	account, err := scanAccount(tx.QueryRow(ctx, "SELECT "+accountColumns+accountsFrom+" AND a.id::text = $2 FOR UPDATE OF a", actor.UserID, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve account: %v", err)
+	}
+	return &account, nil
+}
+
+// auditAccount records a change the actor made to an account, comparing its
+// snapshot from before the change with how it is now
+func auditAccount(ctx context.Context, tx pgx.Tx, actor Actor, action string, before *Account, id string) error {
+	after, err := accountSnapshot(ctx, tx, id, actor)
+	if err != nil {
+		return err
+	}
+	if before == nil && after == nil {
+		return nil
+	}
+	return recordAudit(ctx, tx, actor, AuditAccount, id, action, before, after)
+}
+
+// recordJobAudit logs a change a background job made to transactions in the
+// log of the users who added them, within the job's database transaction
+func recordJobAudit(ctx context.Context, tx pgx.Tx, action string, changes map[string]AuditChange, ids []string) error {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode changes: %v", err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO audit_log (user_id, source, entity_type, entity_id, action, changes)
+		SELECT user_id, $2, $3, id::text, $4, $5 FROM transactions
+		WHERE id::text = ANY($1) AND user_id IS NOT NULL`, ids, AuditSourceJob, AuditTransaction, action, data)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entries: %v", err)
+	}
+	return nil
+}
+
+// auditedEntity is the condition matching the entities of a type the user
+// can see, the entity id being $1 and the user $2
+func auditedEntity(entityType string) (string, error) {
+	switch entityType {
+	case AuditTransaction:
+		return "EXISTS (SELECT 1 FROM transactions t WHERE t.id::text = $1 AND (" + visibleTransaction("$2") + " OR " + trashedTransaction("$2") + "))", nil
+	case AuditAccount:
+		return "EXISTS (SELECT 1 FROM accounts a WHERE a.id::text = $1 AND a.id IN (" + visibleAccounts("$2") + "))", nil
+	default:
+		return "", fmt.Errorf("no history of %s entities", entityType)
+	}
+}
+
+// GetEntityHistory lists the changes to a transaction or account, first
+// change first. Household members see the changes of everyone, entities that
+// are gone only keep their history for the users who changed them.
+func GetEntityHistory(entityType string, id string, uid string) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	visible, err := auditedEntity(entityType)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = db.QueryRow(ctx, "SELECT "+visible+" OR EXISTS (SELECT 1 FROM audit_log WHERE entity_type = $3 AND entity_id = $1 AND user_id = $2)",
+		id, uid, entityType).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %v", entityType, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotFound, entityType, id)
+	}
+
+	return queryAudit(ctx, `
+		WHERE entity_type = $3 AND entity_id = $1 AND (`+visible+` OR user_id = $2)
+		ORDER BY id`, id, uid, entityType)
+}
+
+// GetAuditLog lists the entries of the log of a user, last first
+func GetAuditLog(query AuditQuery, uid string) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if query.Limit <= 0 {
+		query.Limit = DefaultTransactionLimit
+	}
+	if query.Limit > MaxTransactionLimit {
+		query.Limit = MaxTransactionLimit
+	}
+
+	condition := "WHERE user_id = $1"
+	args := []any{uid}
+	add := func(clause string, value any) {
+		args = append(args, value)
+		condition += " AND " + clause + " $" + strconv.Itoa(len(args))
+	}
+	if query.EntityType != "" {
+		add("entity_type =", query.EntityType)
+	}
+	if query.EntityID != "" {
+		add("entity_id =", query.EntityID)
+	}
+	if query.Source != "" {
+		add("source =", query.Source)
+	}
+	if query.Before > 0 {
+		add("id <", query.Before)
+	}
+	args = append(args, query.Limit)
+
+	return queryAudit(ctx, condition+" ORDER BY id DESC LIMIT $"+strconv.Itoa(len(args)), args...)
+}
+
+func queryAudit(ctx context.Context, condition string, args ...any) ([]AuditEntry, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, created_at, user_id, actor_id, request_id, source, entity_type, entity_id, action, changes
+		FROM audit_log `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit log: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+		err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.UserID, &entry.ActorID, &entry.RequestID, &entry.Source,
+			&entry.EntityType, &entry.EntityID, &entry.Action, &changes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %v", err)
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry %d: %v", entry.ID, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
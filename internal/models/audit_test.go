@@ -0,0 +1,103 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditChanges(t *testing.T) {
+	type entity struct {
+		Name   string   `json:"name"`
+		Amount float64  `json:"amount"`
+		Tags   []string `json:"tags"`
+	}
+	lunch := entity{Name: "Lunch", Amount: 12.5, Tags: []string{"work"}}
+
+	tests := []struct {
+		name   string
+		before any
+		after  any
+		want   map[string]AuditChange
+	}{
+		{
+			name:  "creation",
+			after: lunch,
+			want: map[string]AuditChange{
+				"name":   {After: "Lunch"},
+				"amount": {After: 12.5},
+				"tags":   {After: []any{"work"}},
+			},
+		},
+		{
+			name:   "deletion",
+			before: lunch,
+			want: map[string]AuditChange{
+				"name":   {Before: "Lunch"},
+				"amount": {Before: 12.5},
+				"tags":   {Before: []any{"work"}},
+			},
+		},
+		{
+			name:   "deletion of a nil pointer",
+			before: &lunch,
+			after:  (*entity)(nil),
+			want: map[string]AuditChange{
+				"name":   {Before: "Lunch"},
+				"amount": {Before: 12.5},
+				"tags":   {Before: []any{"work"}},
+			},
+		},
+		{
+			name:   "changed fields only",
+			before: lunch,
+			after:  entity{Name: "Lunch", Amount: 14, Tags: []string{"work"}},
+			want:   map[string]AuditChange{"amount": {Before: 12.5, After: 14.0}},
+		},
+		{
+			name:   "changed lists",
+			before: lunch,
+			after:  entity{Name: "Lunch", Amount: 12.5, Tags: []string{"work", "team"}},
+			want:   map[string]AuditChange{"tags": {Before: []any{"work"}, After: []any{"work", "team"}}},
+		},
+		{
+			name:   "cleared field",
+			before: lunch,
+			after:  entity{Name: "Lunch", Amount: 12.5},
+			want:   map[string]AuditChange{"tags": {Before: []any{"work"}}},
+		},
+		{
+			name:   "sharing a transaction",
+			before: map[string]any{"shared_expense": nil},
+			after:  map[string]any{"shared_expense": map[string]any{"method": "equal"}},
+			want:   map[string]AuditChange{"shared_expense": {After: map[string]any{"method": "equal"}}},
+		},
+		{
+			name:   "nothing changed",
+			before: lunch,
+			after:  entity{Name: "Lunch", Amount: 12.5, Tags: []string{"work"}},
+			want:   map[string]AuditChange{},
+		},
+		{
+			name: "nothing at all",
+			want: map[string]AuditChange{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := auditChanges(tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditChanges() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditChangesRefusesWhatIsNotJSON(t *testing.T) {
+	if _, err := auditChanges(nil, map[string]any{"f": func() {}}); err == nil {
+		t.Error("values that can't be encoded should be refused")
+	}
+}
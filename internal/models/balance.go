@@ -0,0 +1,218 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"math"
+	"time"
+
+	"github.com/guregu/null/v5"
+)
+
+const (
+	SubcategoryOpeningBalance    = "Opening balance"    // Adjustment posted when an account is added
+	SubcategoryBalanceAdjustment = "Balance adjustment" // Adjustment posted when a balance is edited
+)
+
+// balanceTolerance absorbs the rounding of the REAL amount and balance
+// columns when comparing a stored balance with its ledger
+const balanceTolerance = 0.01
+
+// ledgerBalance is the balance of the account aliased as a according to its
+// postings
+const ledgerBalance = `COALESCE((SELECT SUM(p.amount) FROM postings p WHERE p.account_id = a.id), 0)`
+
+// BalanceCheck compares the stored balance of an account with the balance
+// its transactions add up to
+type BalanceCheck struct {
+	AccountID     string              `json:"account_id"`
+	AccountName   string              `json:"account_name"`
+	StoredBalance float64             `json:"stored_balance"`
+	LedgerBalance float64             `json:"ledger_balance"`
+	Difference    float64             `json:"difference"` // Stored minus ledger balance
+	Corrected     bool                `json:"corrected"`  // The stored balance was set to the ledger balance
+	DetectedAt    timeutils.Timestamp `json:"detected_at"`
+}
+
+// balanceAdjustment is the adjustment transaction moving the balance of an
+// account by an amount
+func balanceAdjustment(account Account, amount float64, subcategory string, uid string) Transaction {
+	return Transaction{
+		Description:     subcategory,
+		Amount:          roundCents(amount),
+		Currency:        account.Currency,
+		Date:            timeutils.NewTimestamp(time.Now()),
+		MainCategory:    TransactionTypeAdjustment,
+		Subcategory:     subcategory,
+		AccountID:       null.StringFrom(account.ID),
+		TransactionType: TransactionTypeAdjustment,
+		UserID:          uid,
+	}
+}
+
+// RecomputeBalance posts the transactions of an account again and sets its
+// stored balance to the sum of its postings, archived accounts included
+func RecomputeBalance(accountID string, actor Actor) (BalanceCheck, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	account, err := GetAccountByID(null.StringFrom(accountID), uid)
+	if err != nil {
+		return BalanceCheck{}, err
+	}
+	if !canWrite(account.Role) {
+		return BalanceCheck{}, fmt.Errorf("%w: viewers can't change account %s", ErrForbidden, account.Name)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return BalanceCheck{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := accountSnapshot(ctx, tx, account.ID, actor)
+	if err != nil {
+		return BalanceCheck{}, err
+	}
+	if before == nil {
+		return BalanceCheck{}, fmt.Errorf("%w: no account found with ID %s", ErrNotFound, accountID)
+	}
+	check := BalanceCheck{AccountID: account.ID, AccountName: account.Name, StoredBalance: before.Balance, DetectedAt: timeutils.NewTimestamp(time.Now())}
+
+	ids, err := transactionIDs(ctx, tx, "account_id = $1 OR related_account_id = $1", account.ID)
+	if err != nil {
+		return BalanceCheck{}, err
+	}
+	if err := postEntries(ctx, tx, ids...); err != nil {
+		return BalanceCheck{}, err
+	}
+
+	err = tx.QueryRow(ctx, "SELECT "+ledgerBalance+" FROM accounts a WHERE a.id = $1", account.ID).Scan(&check.LedgerBalance)
+	if err != nil {
+		return BalanceCheck{}, fmt.Errorf("failed to compute ledger balance: %v", err)
+	}
+	check.LedgerBalance = roundCents(check.LedgerBalance)
+	check.Difference = roundCents(check.StoredBalance - check.LedgerBalance)
+
+	if math.Abs(check.Difference) >= balanceTolerance {
+		if _, err := tx.Exec(ctx, "UPDATE accounts SET balance = $2 WHERE id = $1", account.ID, check.LedgerBalance); err != nil {
+			return BalanceCheck{}, fmt.Errorf("failed to update account balance: %v", err)
+		}
+		check.Corrected = true
+		log.Printf("Recomputed balance of account %s: stored %.2f, ledger %.2f", account.ID, check.StoredBalance, check.LedgerBalance)
+		if err := auditAccount(ctx, tx, actor, AuditUpdate, before, account.ID); err != nil {
+			return BalanceCheck{}, err
+		}
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM balance_discrepancies WHERE account_id = $1", account.ID); err != nil {
+		return BalanceCheck{}, fmt.Errorf("failed to clear balance discrepancy: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return BalanceCheck{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return check, nil
+}
+
+// GetBalanceDiscrepancies lists the accounts of the user whose stored
+// balance didn't match their ledger at the last integrity check
+func GetBalanceDiscrepancies(uid string) ([]BalanceCheck, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT d.account_id::text, a.name, d.stored_balance, d.ledger_balance, d.detected_at
+		FROM balance_discrepancies d
+		JOIN accounts a ON a.id = d.account_id
+		WHERE a.id IN (`+visibleAccounts("$1")+`)
+		ORDER BY a.name`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve balance discrepancies: %v", err)
+	}
+	defer rows.Close()
+
+	checks := []BalanceCheck{}
+	for rows.Next() {
+		var check BalanceCheck
+		if err := rows.Scan(&check.AccountID, &check.AccountName, &check.StoredBalance, &check.LedgerBalance, &check.DetectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan balance discrepancy: %v", err)
+		}
+		check.Difference = roundCents(check.StoredBalance - check.LedgerBalance)
+		checks = append(checks, check)
+	}
+	return checks, rows.Err()
+}
+
+// CheckLedger checks the invariants of the journal: the postings of every
+// entry sum to zero and the stored balance of every account is the sum of
+// its postings. Unbalanced entries are logged, balance discrepancies are
+// also kept until the next check or a recompute of the account. Nothing is
+// corrected.
+func CheckLedger() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT c.id::text, c.name, c.balance, c.ledger
+		FROM (SELECT a.id, a.name, a.balance, `+ledgerBalance+` AS ledger FROM accounts a) c
+		WHERE ABS(c.balance - c.ledger) >= $1`, balanceTolerance)
+	if err != nil {
+		return fmt.Errorf("failed to compute ledger balances: %v", err)
+	}
+	var checks []BalanceCheck
+	for rows.Next() {
+		var check BalanceCheck
+		if err := rows.Scan(&check.AccountID, &check.AccountName, &check.StoredBalance, &check.LedgerBalance); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan ledger balance: %v", err)
+		}
+		checks = append(checks, check)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ids := []string{}
+	for _, check := range checks {
+		ids = append(ids, check.AccountID)
+		log.Printf("Balance discrepancy in account %s (%s): stored %.2f, ledger %.2f",
+			check.AccountID, check.AccountName, check.StoredBalance, check.LedgerBalance)
+		_, err := tx.Exec(ctx, `
+			INSERT INTO balance_discrepancies (account_id, stored_balance, ledger_balance)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (account_id) DO UPDATE SET
+				stored_balance = EXCLUDED.stored_balance,
+				ledger_balance = EXCLUDED.ledger_balance,
+				checked_at = CURRENT_TIMESTAMP`, check.AccountID, check.StoredBalance, roundCents(check.LedgerBalance))
+		if err != nil {
+			return fmt.Errorf("failed to record balance discrepancy: %v", err)
+		}
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM balance_discrepancies WHERE NOT (account_id::text = ANY($1))", ids); err != nil {
+		return fmt.Errorf("failed to clear balance discrepancies: %v", err)
+	}
+
+	unbalanced, err := unbalancedEntries(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for id, sum := range unbalanced {
+		log.Printf("Unbalanced journal entry for transaction %s: postings sum to %.2f", id, sum)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	log.Printf("Checked the ledger, %d balance discrepancies and %d unbalanced entries", len(checks), len(unbalanced))
+	return nil
+}
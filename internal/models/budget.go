@@ -4,77 +4,101 @@ import (
 	"context"
 	"fmt"
 	"guilliman/internal/utils/timeutils"
+	"sort"
 	"time"
+
+	"github.com/guregu/null/v5"
 )
 
 // BudgetSummary struct
 type BudgetSummary struct {
-	TotalIncome       float64 `json:"total_income"`
-	TotalExpenses     float64 `json:"total_expenses"`
-	NetBalance        float64 `json:"net_balance"`
-	NeedsAmount       float64 `json:"needs_amount"`
-	WantsAmount       float64 `json:"wants_amount"`
-	SavingsAmount     float64 `json:"savings_amount"`
-	NeedsPercentage   float64 `json:"needs_percentage"`
-	WantsPercentage   float64 `json:"wants_percentage"`
-	SavingsPercentage float64 `json:"savings_percentage"`
-	NeedsBudget       float64 `json:"needs_budget"`
-	WantsBudget       float64 `json:"wants_budget"`
-	SavingsBudget     float64 `json:"savings_budget"`
-	NetWorth          float64 `json:"net_worth"`
+	TotalIncome       float64             `json:"total_income"`
+	TotalExpenses     float64             `json:"total_expenses"`
+	NetBalance        float64             `json:"net_balance"`
+	NeedsAmount       float64             `json:"needs_amount"`
+	WantsAmount       float64             `json:"wants_amount"`
+	SavingsAmount     float64             `json:"savings_amount"`
+	NeedsPercentage   float64             `json:"needs_percentage"`
+	WantsPercentage   float64             `json:"wants_percentage"`
+	SavingsPercentage float64             `json:"savings_percentage"`
+	NeedsBudget       float64             `json:"needs_budget"`
+	WantsBudget       float64             `json:"wants_budget"`
+	SavingsBudget     float64             `json:"savings_budget"`
+	NetWorth          float64             `json:"net_worth"`
+	PeriodStart       timeutils.Timestamp `json:"period_start"`
+	PeriodEnd         timeutils.Timestamp `json:"period_end"`
 }
 
-// GetBudgetSummary retrieves the budget summary based on a salary period
-func GetBudgetSummary(startDay string, endDay string) (BudgetSummary, error) {
+// GetBudgetSummary retrieves the budget summary for a pay period. When tags
+// are given only transactions with any of them are counted. The summary
+// covers the user's personal accounts unless a household is given.
+func GetBudgetSummary(start time.Time, end time.Time, tags []string, household string, uid string) (BudgetSummary, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var summary BudgetSummary
-	var start, end int64
+	summary.PeriodStart = timeutils.NewTimestamp(start)
+	summary.PeriodEnd = timeutils.NewTimestamp(end)
 
-	startDate, endDate := timeutils.GetSalaryMonthRange(startDay, endDay)
-	start = startDate.Unix()
-	end = endDate.Unix()
+	filter, err := spendingFilter(start, end, tags, household, uid)
+	if err != nil {
+		return summary, err
+	}
 
 	// Fetch total income
-	err := db.QueryRow(ctx, `
-        SELECT COALESCE(SUM(amount), 0) 
-        FROM transactions
-        WHERE transaction_type = 'Income' 
-          AND date BETWEEN $1 AND $2`, start, end).Scan(&summary.TotalIncome)
+	var args queryArgs
+	err = db.QueryRow(ctx, `
+        SELECT COALESCE(SUM(t.amount), 0)
+        FROM transactions t`+filter.where(&args)+`
+          AND t.transaction_type = 'Income'`, args...).Scan(&summary.TotalIncome)
 	if err != nil {
 		return summary, fmt.Errorf("failed to retrieve total income: %v", err)
 	}
 
-	// Fetch total expenses
+	// Fetch total expenses, net of refunds. Only the user's share of shared
+	// expenses counts, including their shares of expenses paid by others.
+	args = nil
 	err = db.QueryRow(ctx, `
-        SELECT COALESCE(SUM(amount), 0) 
-        FROM transactions
-        WHERE transaction_type = 'Expense' 
-          AND date BETWEEN $1 AND $2`, start, end).Scan(&summary.TotalExpenses)
+        SELECT COALESCE(SUM(t.amount * `+payerShareFactor+`), 0)
+        FROM transactions t`+filter.where(&args)+`
+          AND t.transaction_type IN ('Expense', 'Refund')`, args...).Scan(&summary.TotalExpenses)
 	if err != nil {
 		return summary, fmt.Errorf("failed to retrieve total expenses: %v", err)
 	}
+
+	shared, err := sharedSpendingLines(ctx, filter)
+	if err != nil {
+		return summary, err
+	}
+	for _, line := range shared {
+		summary.TotalExpenses += line.Amount
+	}
 	// Convert expenses to a positive number
 	summary.TotalExpenses = -summary.TotalExpenses
 
 	// Calculate net balance
 	summary.NetBalance = summary.TotalIncome - summary.TotalExpenses
 
-	// Calculate net worth: sum of all account balances
+	// Calculate net worth: sum of all account balances in scope, archived
+	// accounts included, unless left out of the net worth
 	err = db.QueryRow(ctx, `
-        SELECT COALESCE(SUM(balance), 0) FROM accounts`).Scan(&summary.NetWorth)
+        SELECT COALESCE(SUM(balance), 0) FROM accounts
+        WHERE (($2 = '' AND household_id IS NULL AND user_id = $1) OR household_id::text = $2)
+          AND include_in_net_worth`, uid, household).Scan(&summary.NetWorth)
 	if err != nil {
 		return summary, fmt.Errorf("failed to retrieve net worth: %v", err)
 	}
 
-	// Fetch total expenses grouped by main_category
+	// Fetch total expenses grouped by main_category, split transactions count
+	// towards the main category of each line and refunds reduce the category
+	// of the refunded expense
+	args = nil
 	rows, err := db.Query(ctx, `
-        SELECT main_category, COALESCE(SUM(amount), 0) 
-        FROM transactions
-        WHERE transaction_type = 'Expense' 
-          AND date BETWEEN $1 AND $2
-        GROUP BY main_category`, start, end)
+        SELECT `+lineMainCategory+`, COALESCE(SUM(`+lineAmount+` * `+payerShareFactor+`), 0)
+        FROM transactions t
+        LEFT JOIN transaction_splits s ON s.transaction_id = t.id`+filter.where(&args)+`
+          AND t.transaction_type IN ('Expense', 'Refund')
+        GROUP BY 1`, args...)
 	if err != nil {
 		return summary, fmt.Errorf("failed to retrieve expenses: %v", err)
 	}
@@ -105,6 +129,17 @@ func GetBudgetSummary(startDay string, endDay string) (BudgetSummary, error) {
 		return summary, fmt.Errorf("error iterating expense rows: %v", err)
 	}
 
+	for _, line := range shared {
+		switch line.MainCategory {
+		case "Needs":
+			needsAmount -= line.Amount
+		case "Wants":
+			wantsAmount -= line.Amount
+		case "Savings":
+			savingsAmount -= line.Amount
+		}
+	}
+
 	summary.NeedsAmount = needsAmount
 	summary.WantsAmount = wantsAmount
 	summary.SavingsAmount = savingsAmount
@@ -127,3 +162,82 @@ func GetBudgetSummary(startDay string, endDay string) (BudgetSummary, error) {
 
 	return summary, nil
 }
+
+// CategorySpending is the amount spent on a category during a period
+type CategorySpending struct {
+	CategoryID       null.String `json:"category_id"`
+	Category         string      `json:"category"`
+	MainCategory     string      `json:"main_category"`
+	Amount           float64     `json:"amount"`
+	TransactionCount int         `json:"transaction_count"`
+}
+
+// GetCategoryReport retrieves the expenses of a period grouped by category,
+// largest first. Split transactions count towards the category of each line,
+// refunds are deducted from the category of the refunded expense and shared
+// expenses count for the user's share.
+func GetCategoryReport(start time.Time, end time.Time, tags []string, household string, uid string) ([]CategorySpending, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter, err := spendingFilter(start, end, tags, household, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	var args queryArgs
+	rows, err := db.Query(ctx, `
+        SELECT `+lineCategoryID+`::text, `+lineSubcategory+`, `+lineMainCategory+`,
+               -COALESCE(SUM(`+lineAmount+` * `+payerShareFactor+`), 0), COUNT(DISTINCT t.id) FILTER (WHERE t.transaction_type = 'Expense')
+        FROM transactions t
+        LEFT JOIN transaction_splits s ON s.transaction_id = t.id`+filter.where(&args)+`
+          AND t.transaction_type IN ('Expense', 'Refund')
+        GROUP BY 1, 2, 3
+        ORDER BY 4 DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve category report: %v", err)
+	}
+	defer rows.Close()
+
+	report := []CategorySpending{}
+	for rows.Next() {
+		var line CategorySpending
+		if err := rows.Scan(&line.CategoryID, &line.Category, &line.MainCategory, &line.Amount, &line.TransactionCount); err != nil {
+			return nil, fmt.Errorf("failed to scan category row: %v", err)
+		}
+		report = append(report, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating category rows: %v", err)
+	}
+
+	shared, err := sharedSpendingLines(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSharedSpending(report, shared), nil
+}
+
+// mergeSharedSpending adds the user's shares of expenses paid by others to a
+// category report, keeping it sorted by amount
+func mergeSharedSpending(report []CategorySpending, shared []CategorySpending) []CategorySpending {
+	for _, line := range shared {
+		merged := false
+		for i := range report {
+			if report[i].CategoryID == line.CategoryID && report[i].Category == line.Category {
+				report[i].Amount -= line.Amount
+				report[i].TransactionCount += line.TransactionCount
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			line.Amount = -line.Amount
+			report = append(report, line)
+		}
+	}
+
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Amount > report[j].Amount })
+	return report
+}
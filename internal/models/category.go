@@ -6,20 +6,27 @@ import (
 	"log"
 	"time"
 
+	"github.com/guregu/null/v5"
 	"github.com/jackc/pgx/v5"
 )
 
 type Category struct {
-	ID           string `json:"id"`
-	Name         string `json:"name"`
-	MainCategory string `json:"main_category"`
+	ID           string      `json:"id"`
+	Name         string      `json:"name"`
+	MainCategory string      `json:"main_category"`
+	HouseholdID  null.String `json:"household_id"` // Only visible to the household's members, shared by everyone when null
+	Version      int         `json:"version"`      // Changes with every update, sent as the ETag. Read only.
 }
 
-func GetCategories() ([]Category, error) {
+// GetCategories returns the shared categories and those of the user's households
+func GetCategories(uid string) ([]Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := db.Query(ctx, "SELECT id, name, main_category FROM categories")
+	rows, err := db.Query(ctx, `
+		SELECT id, name, main_category, household_id::text, version FROM categories
+		WHERE household_id IS NULL
+		   OR household_id IN (SELECT household_id FROM household_members WHERE user_id = $1)`, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -28,7 +35,7 @@ func GetCategories() ([]Category, error) {
 	var categories []Category
 	for rows.Next() {
 		var category Category
-		if err := rows.Scan(&category.ID, &category.Name, &category.MainCategory); err != nil {
+		if err := rows.Scan(&category.ID, &category.Name, &category.MainCategory, &category.HouseholdID, &category.Version); err != nil {
 			return nil, err
 		}
 		categories = append(categories, category)
@@ -41,55 +48,129 @@ func GetCategories() ([]Category, error) {
 	return categories, nil
 }
 
-func AddCategory(category Category) (Category, error) {
+// AddCategory inserts a category, in a household when the user can edit it
+func AddCategory(category Category, actor Actor) (Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO categories (name, main_category) VALUES ($1, $2) RETURNING id"
-	err := db.QueryRow(ctx, query, category.Name, category.MainCategory).Scan(&category.ID)
+	if category.HouseholdID.String == "" {
+		category.HouseholdID = null.String{}
+	} else if _, err := requireHouseholdRole(ctx, db, category.HouseholdID.String, actor.UserID, RoleOwner, RoleEditor); err != nil {
+		return Category{}, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Category{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := "INSERT INTO categories (name, main_category, household_id) VALUES ($1, $2, $3) RETURNING id, version"
+	err = tx.QueryRow(ctx, query, category.Name, category.MainCategory, category.HouseholdID).Scan(&category.ID, &category.Version)
 	if err != nil {
 		return Category{}, err
 	}
+	if err := recordAudit(ctx, tx, actor, AuditCategory, category.ID, AuditCreate, nil, category); err != nil {
+		return Category{}, err
+	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return Category{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
 	return category, nil
 }
 
-// UpdateCategory updates an existing category
-func UpdateCategory(category Category) (Category, error) {
+// UpdateCategory updates an existing category. A version in the category
+// must be the stored one.
+func UpdateCategory(category Category, actor Actor) (Category, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := db.Exec(ctx,
-		"UPDATE categories SET name = $1, main_category = $2 WHERE id = $3",
-		category.Name, category.MainCategory, category.ID,
-	)
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Category{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := lockCategory(ctx, tx, category.ID, category.Version, actor.UserID)
+	if err != nil {
+		return Category{}, err
+	}
+	category.HouseholdID = before.HouseholdID
 
+	err = tx.QueryRow(ctx,
+		"UPDATE categories SET name = $1, main_category = $2 WHERE id = $3 RETURNING version",
+		category.Name, category.MainCategory, before.ID,
+	).Scan(&category.Version)
 	if err != nil {
 		log.Println("Error updating category:", err)
 		return Category{}, err
 	}
+	if err := recordAudit(ctx, tx, actor, AuditCategory, before.ID, AuditUpdate, before, category); err != nil {
+		return Category{}, err
+	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return Category{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
 	return category, nil
 }
 
-// DeleteCategory removes a category from the database
-func DeleteCategory(category Category) error {
+// DeleteCategory removes a category from the database. A version in the
+// category must be the stored one.
+func DeleteCategory(category Category, actor Actor) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := db.Exec(ctx,
-		"DELETE FROM categories WHERE id = $1",
-		category.ID,
-	)
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
 
+	before, err := lockCategory(ctx, tx, category.ID, category.Version, actor.UserID)
 	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM categories WHERE id = $1", before.ID); err != nil {
 		log.Println("Error deleting category:", err)
 		return err
 	}
+	if err := recordAudit(ctx, tx, actor, AuditCategory, before.ID, AuditDelete, before, nil); err != nil {
+		return err
+	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
 	return nil
 }
 
+// lockCategory locks a category the user can edit, checking its version
+// unless 0. Shared categories are used by everyone and can't be changed.
+func lockCategory(ctx context.Context, tx pgx.Tx, id string, version int, uid string) (Category, error) {
+	var category Category
+	err := tx.QueryRow(ctx, "SELECT id, name, main_category, household_id::text, version FROM categories WHERE id::text = $1 FOR UPDATE", id).
+		Scan(&category.ID, &category.Name, &category.MainCategory, &category.HouseholdID, &category.Version)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Category{}, fmt.Errorf("%w: category %s", ErrNotFound, id)
+		}
+		return Category{}, err
+	}
+
+	if !category.HouseholdID.Valid {
+		return Category{}, fmt.Errorf("%w: shared categories can't be changed", ErrForbidden)
+	}
+	if _, err := requireHouseholdRole(ctx, tx, category.HouseholdID.String, uid, RoleOwner, RoleEditor); err != nil {
+		return Category{}, err
+	}
+	if err := checkVersion(version, category.Version, "the category"); err != nil {
+		return Category{}, err
+	}
+	return category, nil
+}
+
 // GetMainCategory returns the main category based on the category ID
 func GetMainCategory(id string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -99,7 +180,7 @@ func GetMainCategory(id string) (string, error) {
 	err := db.QueryRow(ctx, "SELECT main_category FROM categories WHERE id = $1", id).Scan(&mainCategory)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return "", fmt.Errorf("subcategory '%d' not found in categories table", id)
+			return "", fmt.Errorf("subcategory '%s' not found in categories table", id)
 		}
 		return "", err
 	}
@@ -115,7 +196,7 @@ func GetSubCategory(id string) (string, error) {
 	err := db.QueryRow(ctx, "SELECT name FROM categories WHERE id = $1", id).Scan(&subCategory)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return "", fmt.Errorf("subcategory '%d' not found in categories table", id)
+			return "", fmt.Errorf("subcategory '%s' not found in categories table", id)
 		}
 		return "", err
 	}
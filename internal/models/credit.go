@@ -0,0 +1,521 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const AccountTypeCreditCard = "Credit Card"
+
+// DefaultMinimumPaymentRate is the share of the statement balance due at
+// least when the card doesn't set one
+const DefaultMinimumPaymentRate = 0.02
+
+const (
+	StatementPaid        = "paid"
+	StatementMinimumPaid = "minimum_paid"
+	StatementDue         = "due"
+	StatementOverdue     = "overdue"
+)
+
+// ErrInvalidCredit wraps errors caused by credit card requests sent by the client
+var ErrInvalidCredit = errors.New("invalid credit card")
+
+// ErrCreditLimit is returned when an expense exceeds the available credit
+var ErrCreditLimit = errors.New("credit limit exceeded")
+
+// CreditTerms are the settings of a credit card account. The balance of a
+// card is negative while money is owed, so the available credit is the
+// limit plus the balance.
+type CreditTerms struct {
+	Limit                float64 `json:"limit"`
+	StatementClosingDay  int     `json:"statement_closing_day"`  // Last day of each cycle, the last day of shorter months
+	PaymentDueDay        int     `json:"payment_due_day"`        // First such day after the closing day
+	MinimumPaymentRate   float64 `json:"minimum_payment_rate"`   // Share of the statement balance, 0.02 by default
+	MinimumPaymentAmount float64 `json:"minimum_payment_amount"` // Lowest minimum payment unless less is owed
+	AvailableCredit      float64 `json:"available_credit"`
+}
+
+// Statement is a closed cycle of a credit card. Amounts are positive when
+// owed. Paid sums the payments made to the card since the closing date,
+// until the next closing.
+type Statement struct {
+	ID              string              `json:"id"`
+	AccountID       string              `json:"account_id"`
+	PeriodStart     timeutils.Timestamp `json:"period_start"`
+	PeriodEnd       timeutils.Timestamp `json:"period_end"` // Exclusive, the midnight after the closing date
+	ClosingDate     timeutils.Timestamp `json:"closing_date"`
+	DueDate         timeutils.Timestamp `json:"due_date"`
+	PreviousBalance float64             `json:"previous_balance"`
+	Purchases       float64             `json:"purchases"`
+	Credits         float64             `json:"credits"` // Payments and refunds during the cycle
+	Balance         float64             `json:"balance"`
+	MinimumPayment  float64             `json:"minimum_payment"`
+	Paid            float64             `json:"paid"`
+	Remaining       float64             `json:"remaining"`
+	Status          string              `json:"status"` // "paid", "minimum_paid", "due" or "overdue"
+}
+
+// UpcomingDue is the latest statement of a card still to be paid
+type UpcomingDue struct {
+	AccountName string `json:"account_name"`
+	Currency    string `json:"currency"`
+	Statement
+}
+
+// IsCreditCard tells whether the account is a credit card
+func (a Account) IsCreditCard() bool {
+	return strings.EqualFold(strings.TrimSpace(a.Type), AccountTypeCreditCard)
+}
+
+// columns returns the values stored in the credit columns of accounts,
+// nulls for accounts without credit terms
+func (c *CreditTerms) columns() (null.Float, null.Int, null.Int, null.Float, null.Float) {
+	if c == nil {
+		return null.Float{}, null.Int{}, null.Int{}, null.Float{}, null.Float{}
+	}
+	return null.FloatFrom(c.Limit), null.IntFrom(int64(c.StatementClosingDay)), null.IntFrom(int64(c.PaymentDueDay)),
+		null.FloatFrom(c.MinimumPaymentRate), null.FloatFrom(c.MinimumPaymentAmount)
+}
+
+// prepareCreditTerms validates the credit terms of a credit card account and
+// drops them from other accounts
+func prepareCreditTerms(account *Account) error {
+	if !account.IsCreditCard() {
+		account.Credit = nil
+		return nil
+	}
+
+	account.Type = AccountTypeCreditCard
+	credit := account.Credit
+	if credit == nil {
+		return fmt.Errorf("%w: credit cards need a limit, a statement closing day and a payment due day", ErrInvalidCredit)
+	}
+	if credit.Limit < 0 {
+		return fmt.Errorf("%w: the limit can't be negative", ErrInvalidCredit)
+	}
+	if credit.StatementClosingDay < 1 || credit.StatementClosingDay > 31 {
+		return fmt.Errorf("%w: statement_closing_day must be between 1 and 31", ErrInvalidCredit)
+	}
+	if credit.PaymentDueDay < 1 || credit.PaymentDueDay > 31 {
+		return fmt.Errorf("%w: payment_due_day must be between 1 and 31", ErrInvalidCredit)
+	}
+	if credit.MinimumPaymentRate == 0 {
+		credit.MinimumPaymentRate = DefaultMinimumPaymentRate
+	}
+	if credit.MinimumPaymentRate < 0 || credit.MinimumPaymentRate > 1 {
+		return fmt.Errorf("%w: minimum_payment_rate must be between 0 and 1", ErrInvalidCredit)
+	}
+	if credit.MinimumPaymentAmount < 0 {
+		return fmt.Errorf("%w: minimum_payment_amount can't be negative", ErrInvalidCredit)
+	}
+	return nil
+}
+
+func availableCredit(limit float64, balance float64) float64 {
+	return roundCents(limit + balance)
+}
+
+// checkCredit ensures an expense fits in the available credit of a card
+func checkCredit(account Account, transaction Transaction) error {
+	if transaction.TransactionType != TransactionTypeExpense || account.Credit == nil {
+		return nil
+	}
+	if math.Abs(transaction.Amount) > account.Credit.AvailableCredit+splitTolerance {
+		return fmt.Errorf("%w: only %.2f of credit is available on %s", ErrCreditLimit, account.Credit.AvailableCredit, account.Name)
+	}
+	return nil
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// minimumPayment is the least due for a statement balance
+func minimumPayment(balance float64, credit CreditTerms) float64 {
+	if balance <= 0 {
+		return 0
+	}
+	minimum := math.Max(balance*credit.MinimumPaymentRate, credit.MinimumPaymentAmount)
+	return roundCents(math.Min(minimum, balance))
+}
+
+// dayOf returns the midnight of a day of a month, the last day of the month
+// when it is shorter
+func dayOf(year int, month time.Month, day int, loc *time.Location) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// closingDateAfter returns the first closing date whose cycle ends after
+// the given instant
+func closingDateAfter(after time.Time, closingDay int, loc *time.Location) time.Time {
+	after = after.In(loc)
+	closing := dayOf(after.Year(), after.Month(), closingDay, loc)
+	if !closing.AddDate(0, 0, 1).After(after) {
+		closing = dayOf(after.Year(), after.Month()+1, closingDay, loc)
+	}
+	return closing
+}
+
+// previousClosingDate returns the closing date of the cycle before the one
+// closing on the given date
+func previousClosingDate(closing time.Time, closingDay int, loc *time.Location) time.Time {
+	return dayOf(closing.Year(), closing.Month()-1, closingDay, loc)
+}
+
+// dueDateAfter returns the first due day after a closing date
+func dueDateAfter(closing time.Time, dueDay int, loc *time.Location) time.Time {
+	due := dayOf(closing.Year(), closing.Month(), dueDay, loc)
+	if !due.After(closing) {
+		due = dayOf(closing.Year(), closing.Month()+1, dueDay, loc)
+	}
+	return due
+}
+
+// GenerateStatements closes the cycles of every credit card that ended since
+// their last statement, so that reading statements never writes them
+func GenerateStatements() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	rows, err := db.Query(ctx, "SELECT "+accountColumns+` FROM accounts a
+		LEFT JOIN household_members hm ON hm.household_id = a.household_id AND hm.user_id = a.user_id
+		LEFT JOIN account_positions ap ON ap.account_id = a.id AND ap.user_id = a.user_id
+		WHERE a.statement_closing_day IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve credit cards: %v", err)
+	}
+	var cards []Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan account: %v", err)
+		}
+		cards = append(cards, account)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error occurred during rows iteration: %v", err)
+	}
+
+	// A card failing is retried at the next run without holding the others
+	failed := 0
+	for _, card := range cards {
+		if err := generateStatements(ctx, card); err != nil {
+			log.Printf("Failed to generate the statements of account %s: %v", card.ID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to generate the statements of %d cards", failed)
+	}
+	return nil
+}
+
+// generateStatements closes the cycles of a card that ended since its last
+// statement. Statements are snapshots, later changes to the transactions of
+// a closed cycle show in the next statement.
+func generateStatements(ctx context.Context, account Account) error {
+	if account.Credit == nil {
+		return nil
+	}
+
+	settings, err := GetUserSettings(account.UserID)
+	if err != nil {
+		return err
+	}
+	loc, err := settings.Location()
+	if err != nil {
+		return err
+	}
+	dueDates, err := GetDueDateRule(settings)
+	if err != nil {
+		return err
+	}
+
+	var lastEnd, firstDate null.Time
+	err = db.QueryRow(ctx, `
+		SELECT (SELECT MAX(period_end) FROM card_statements WHERE account_id = $1),
+			(SELECT MIN(t.date) FROM transactions t WHERE t.account_id = $1 OR t.related_account_id = $1)`,
+		account.ID).Scan(&lastEnd, &firstDate)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve statement cycles: %v", err)
+	}
+
+	var closing time.Time
+	switch {
+	case lastEnd.Valid:
+		closing = closingDateAfter(lastEnd.Time, account.Credit.StatementClosingDay, loc)
+	case firstDate.Valid:
+		closing = closingDateAfter(firstDate.Time, account.Credit.StatementClosingDay, loc)
+	default:
+		return nil
+	}
+
+	now := time.Now()
+	// Bounded so a card with very old transactions catches up over a few runs
+	for i := 0; i < 120; i++ {
+		end := closing.AddDate(0, 0, 1)
+		if end.After(now) {
+			break
+		}
+		start := previousClosingDate(closing, account.Credit.StatementClosingDay, loc).AddDate(0, 0, 1)
+
+		var balance, after, debits, credits float64
+		err := db.QueryRow(ctx, `
+			SELECT (SELECT balance FROM accounts WHERE id = $1),
+				COALESCE(SUM(e) FILTER (WHERE date >= $3), 0),
+				COALESCE(SUM(e) FILTER (WHERE date >= $2 AND date < $3 AND e < 0), 0),
+				COALESCE(SUM(e) FILTER (WHERE date >= $2 AND date < $3 AND e > 0), 0)
+			FROM (
+				SELECT t.date, `+balanceEffect("$1")+` AS e
+				FROM transactions t
+				WHERE t.account_id = $1 OR t.related_account_id = $1
+			) effects`, account.ID, start, end).Scan(&balance, &after, &debits, &credits)
+		if err != nil {
+			return fmt.Errorf("failed to compute statement: %v", err)
+		}
+
+		owed := roundCents(-(balance - after))
+		purchases := roundCents(-debits)
+		credits = roundCents(credits)
+
+		_, err = db.Exec(ctx, `
+			INSERT INTO card_statements (account_id, period_start, period_end, closing_date, due_date,
+				previous_balance, purchases, credits, balance, minimum_payment)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (account_id, period_end) DO NOTHING`,
+			account.ID, start, end, closing, dueDates.Adjust(dueDateAfter(closing, account.Credit.PaymentDueDay, loc)),
+			roundCents(owed-purchases+credits), purchases, credits, owed, minimumPayment(owed, *account.Credit))
+		if err != nil {
+			return fmt.Errorf("failed to insert statement: %v", err)
+		}
+
+		closing = dayOf(closing.Year(), closing.Month()+1, account.Credit.StatementClosingDay, loc)
+	}
+
+	return nil
+}
+
+// findStatements reads the statements of a card, latest first, with what
+// was paid since each closing
+func findStatements(ctx context.Context, account Account, statementID string, limit int) ([]Statement, error) {
+	loc, err := GetUserLocation(account.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT s.id, s.account_id, s.period_start, s.period_end, s.closing_date, s.due_date,
+			s.previous_balance, s.purchases, s.credits, s.balance, s.minimum_payment,
+			COALESCE((
+				SELECT SUM(e) FROM (
+					SELECT ` + balanceEffect("$1") + ` AS e
+					FROM transactions t
+					WHERE (t.account_id = $1 OR t.related_account_id = $1)
+					  AND t.date >= s.period_end AND t.date < COALESCE(n.period_end, 'infinity')
+				) effects WHERE e > 0
+			), 0)
+		FROM card_statements s
+		LEFT JOIN LATERAL (
+			SELECT period_end FROM card_statements
+			WHERE account_id = s.account_id AND period_end > s.period_end
+			ORDER BY period_end LIMIT 1
+		) n ON true
+		WHERE s.account_id = $1 AND ($2 = '' OR s.id::text = $2)
+		ORDER BY s.period_end DESC`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := db.Query(ctx, query, account.ID, statementID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve statements: %v", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	statements := []Statement{}
+	for rows.Next() {
+		var s Statement
+		if err := rows.Scan(&s.ID, &s.AccountID, &s.PeriodStart, &s.PeriodEnd, &s.ClosingDate, &s.DueDate,
+			&s.PreviousBalance, &s.Purchases, &s.Credits, &s.Balance, &s.MinimumPayment, &s.Paid); err != nil {
+			return nil, fmt.Errorf("failed to scan statement: %v", err)
+		}
+
+		s.Paid = roundCents(s.Paid)
+		s.Remaining = roundCents(math.Max(s.Balance-s.Paid, 0))
+		switch {
+		case s.Remaining < splitTolerance:
+			s.Status = StatementPaid
+		case s.Paid+splitTolerance >= s.MinimumPayment:
+			s.Status = StatementMinimumPaid
+		case now.After(s.DueDate.AddDate(0, 0, 1)):
+			s.Status = StatementOverdue
+		default:
+			s.Status = StatementDue
+		}
+
+		s.PeriodStart = s.PeriodStart.In(loc)
+		s.PeriodEnd = s.PeriodEnd.In(loc)
+		s.ClosingDate = s.ClosingDate.In(loc)
+		s.DueDate = s.DueDate.In(loc)
+		statements = append(statements, s)
+	}
+
+	return statements, rows.Err()
+}
+
+// getCreditCard returns a credit card account visible to the user
+func getCreditCard(accountID string, uid string) (Account, error) {
+	account, err := GetAccountByID(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Account{}, err
+	}
+	if !account.IsCreditCard() || account.Credit == nil {
+		return Account{}, fmt.Errorf("%w: %s is not a credit card", ErrInvalidCredit, account.Name)
+	}
+	return account, nil
+}
+
+// GetStatements lists the statements of the closed cycles of a card, latest
+// first. They are generated by GenerateStatements.
+func GetStatements(accountID string, uid string) ([]Statement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	account, err := getCreditCard(accountID, uid)
+	if err != nil {
+		return nil, err
+	}
+	return findStatements(ctx, account, "", 0)
+}
+
+// PayStatement transfers money from a bank account to a card. The amount
+// defaults to what remains of the statement, or to what remains of its
+// minimum payment.
+func PayStatement(accountID string, statementID string, fromAccountID string, amount float64, minimum bool, actor Actor) (Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	card, err := getCreditCard(accountID, uid)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	statements, err := findStatements(ctx, card, statementID, 0)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(statements) == 0 {
+		return Transaction{}, fmt.Errorf("%w: statement %s", ErrNotFound, statementID)
+	}
+	statement := statements[0]
+
+	from, err := GetAccountByID(null.StringFrom(fromAccountID), uid)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("%w: invalid account: %v", ErrInvalidCredit, err)
+	}
+	if from.ID == card.ID || from.IsCreditCard() {
+		return Transaction{}, fmt.Errorf("%w: pay the statement from a bank or cash account", ErrInvalidCredit)
+	}
+	if from.Currency != card.Currency {
+		return Transaction{}, fmt.Errorf("%w: %s is in %s and the card in %s", ErrInvalidCredit, from.Name, from.Currency, card.Currency)
+	}
+
+	if amount <= 0 {
+		amount = statement.Remaining
+		if minimum {
+			amount = roundCents(math.Max(statement.MinimumPayment-statement.Paid, 0))
+		}
+	}
+	if amount <= 0 {
+		return Transaction{}, fmt.Errorf("%w: nothing left to pay on this statement", ErrInvalidCredit)
+	}
+
+	categoryID, err := transferCategoryID(ctx)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	return AddTransfer(Transaction{
+		Description:      fmt.Sprintf("%s statement payment", card.Name),
+		Amount:           amount,
+		Currency:         card.Currency,
+		Date:             timeutils.NewTimestamp(time.Now()),
+		CategoryID:       null.StringFrom(categoryID),
+		AccountID:        null.StringFrom(from.ID),
+		RelatedAccountID: null.StringFrom(card.ID),
+		TransactionType:  TransactionTypeTransfer,
+		UserID:           uid,
+	}, actor)
+}
+
+// transferCategoryID returns the shared category of transfers
+func transferCategoryID(ctx context.Context) (string, error) {
+	var id string
+	err := db.QueryRow(ctx, `
+		SELECT id FROM categories
+		WHERE main_category = $1 AND household_id IS NULL
+		ORDER BY name = $1 DESC LIMIT 1`, MainCategoryTransfer).Scan(&id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", fmt.Errorf("no %s category to record the payment", MainCategoryTransfer)
+		}
+		return "", fmt.Errorf("failed to retrieve transfer category: %v", err)
+	}
+	return id, nil
+}
+
+// GetUpcomingDues lists the latest statement of each card of the user still
+// to be paid and due within the given number of days, overdue ones included
+func GetUpcomingDues(days int, uid string) ([]UpcomingDue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accounts, err := GetAccounts("", false, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	horizon := time.Now().AddDate(0, 0, days)
+	dues := []UpcomingDue{}
+	for _, account := range accounts {
+		if account.Credit == nil {
+			continue
+		}
+
+		statements, err := findStatements(ctx, account, "", 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(statements) == 0 {
+			continue
+		}
+
+		statement := statements[0]
+		if statement.Remaining < splitTolerance || statement.DueDate.After(horizon) {
+			continue
+		}
+		dues = append(dues, UpcomingDue{AccountName: account.Name, Currency: account.Currency, Statement: statement})
+	}
+
+	sort.Slice(dues, func(i, j int) bool { return dues[i].DueDate.Before(dues[j].DueDate.Time) })
+	return dues, nil
+}
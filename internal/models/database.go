@@ -90,7 +90,7 @@ func CreateTables() error {
 		currency TEXT NOT NULL,
 		amount_in_base_currency REAL,
 		exchange_rate REAL,
-		date INTEGER NOT NULL,
+		date TIMESTAMPTZ NOT NULL,
 		main_category TEXT NOT NULL,
 		subcategory TEXT NOT NULL,
 		category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
@@ -120,13 +120,300 @@ func CreateTables() error {
 		display_name TEXT NOT NULL
 	);`
 
+	userSettingsTable := `CREATE TABLE IF NOT EXISTS user_settings (
+		user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		pay_cycle TEXT NOT NULL DEFAULT 'monthly',
+		pay_day INTEGER NOT NULL DEFAULT 25,
+		pay_anchor_date DATE,
+		pay_weekday INTEGER NOT NULL DEFAULT 5,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	holidayCalendarTable := `CREATE TABLE IF NOT EXISTS holiday_calendars (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		content TEXT NOT NULL,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE
+	);`
+
+	tagTable := `CREATE TABLE IF NOT EXISTS tags (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		color TEXT NOT NULL DEFAULT '',
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		UNIQUE (user_id, name)
+	);`
+
+	transactionTagTable := `CREATE TABLE IF NOT EXISTS transaction_tags (
+		transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+		tag_id UUID REFERENCES tags(id) ON DELETE CASCADE,
+		PRIMARY KEY (transaction_id, tag_id)
+	);`
+
+	transactionSplitTable := `CREATE TABLE IF NOT EXISTS transaction_splits (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL DEFAULT 0,
+		amount REAL NOT NULL,
+		main_category TEXT NOT NULL,
+		subcategory TEXT NOT NULL,
+		category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
+		note TEXT NOT NULL DEFAULT ''
+	);`
+
+	attachmentTable := `CREATE TABLE IF NOT EXISTS attachments (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+		file_name TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		storage_key TEXT NOT NULL DEFAULT '',
+		thumbnail_key TEXT,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE
+	);`
+
+	payeeTable := `CREATE TABLE IF NOT EXISTS payees (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		default_category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		UNIQUE (user_id, name)
+	);`
+
+	payeeAliasTable := `CREATE TABLE IF NOT EXISTS payee_aliases (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		payee_id UUID REFERENCES payees(id) ON DELETE CASCADE,
+		pattern TEXT NOT NULL,
+		match_type TEXT NOT NULL DEFAULT 'exact'
+	);`
+
+	sharedExpenseTable := `CREATE TABLE IF NOT EXISTS shared_expenses (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		transaction_id UUID UNIQUE REFERENCES transactions(id) ON DELETE CASCADE,
+		payer_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		method TEXT NOT NULL
+	);`
+
+	sharedExpenseShareTable := `CREATE TABLE IF NOT EXISTS shared_expense_shares (
+		shared_expense_id UUID REFERENCES shared_expenses(id) ON DELETE CASCADE,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		amount REAL NOT NULL,
+		percentage REAL,
+		PRIMARY KEY (shared_expense_id, user_id)
+	);`
+
+	settlementTable := `CREATE TABLE IF NOT EXISTS settlements (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		from_user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		to_user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		amount REAL NOT NULL,
+		transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE
+	);`
+
+	householdTable := `CREATE TABLE IF NOT EXISTS households (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		created_by TEXT REFERENCES users(id) ON DELETE SET NULL
+	);`
+
+	householdMemberTable := `CREATE TABLE IF NOT EXISTS household_members (
+		household_id UUID REFERENCES households(id) ON DELETE CASCADE,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		joined_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (household_id, user_id)
+	);`
+
+	householdInvitationTable := `CREATE TABLE IF NOT EXISTS household_invitations (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		household_id UUID REFERENCES households(id) ON DELETE CASCADE,
+		token_hash TEXT NOT NULL UNIQUE,
+		role TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		invited_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		accepted_at TIMESTAMPTZ,
+		accepted_by TEXT REFERENCES users(id) ON DELETE SET NULL
+	);`
+
+	cardStatementTable := `CREATE TABLE IF NOT EXISTS card_statements (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+		period_start TIMESTAMPTZ NOT NULL,
+		period_end TIMESTAMPTZ NOT NULL,
+		closing_date TIMESTAMPTZ NOT NULL,
+		due_date TIMESTAMPTZ NOT NULL,
+		previous_balance REAL NOT NULL,
+		purchases REAL NOT NULL,
+		credits REAL NOT NULL,
+		balance REAL NOT NULL,
+		minimum_payment REAL NOT NULL,
+		UNIQUE (account_id, period_end)
+	);`
+
+	loanTable := `CREATE TABLE IF NOT EXISTS loans (
+		account_id UUID PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+		principal REAL NOT NULL,
+		start_date TIMESTAMPTZ NOT NULL,
+		term INTEGER NOT NULL,
+		frequency TEXT NOT NULL,
+		rate_type TEXT NOT NULL,
+		interest_category_id UUID REFERENCES categories(id) ON DELETE SET NULL
+	);`
+
+	loanRateTable := `CREATE TABLE IF NOT EXISTS loan_rates (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+		effective_date TIMESTAMPTZ NOT NULL,
+		annual_rate REAL NOT NULL,
+		UNIQUE (account_id, effective_date)
+	);`
+
+	loanPaymentTable := `CREATE TABLE IF NOT EXISTS loan_payments (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+		from_account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+		date TIMESTAMPTZ NOT NULL,
+		amount REAL NOT NULL,
+		interest REAL NOT NULL,
+		principal REAL NOT NULL,
+		interest_transaction_id UUID REFERENCES transactions(id) ON DELETE SET NULL,
+		principal_transaction_id UUID REFERENCES transactions(id) ON DELETE SET NULL
+	);`
+
+	tradeTable := `CREATE TABLE IF NOT EXISTS investment_trades (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		date TIMESTAMPTZ NOT NULL,
+		quantity DOUBLE PRECISION NOT NULL DEFAULT 0,
+		price DOUBLE PRECISION NOT NULL DEFAULT 0,
+		fees DOUBLE PRECISION NOT NULL DEFAULT 0,
+		ratio DOUBLE PRECISION NOT NULL DEFAULT 0,
+		amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+		transaction_id UUID REFERENCES transactions(id) ON DELETE SET NULL,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE
+	);`
+
+	securityPriceTable := `CREATE TABLE IF NOT EXISTS security_prices (
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		symbol TEXT NOT NULL,
+		date DATE NOT NULL,
+		price DOUBLE PRECISION NOT NULL,
+		currency TEXT,
+		source TEXT NOT NULL,
+		PRIMARY KEY (user_id, symbol, date)
+	);`
+
+	reconciliationTable := `CREATE TABLE IF NOT EXISTS reconciliations (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+		statement_date TIMESTAMPTZ NOT NULL,
+		statement_balance REAL NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open',
+		cleared_balance REAL,
+		adjustment_transaction_id UUID REFERENCES transactions(id) ON DELETE SET NULL,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		finished_at TIMESTAMPTZ
+	);`
+
+	reconciliationItemTable := `CREATE TABLE IF NOT EXISTS reconciliation_items (
+		reconciliation_id UUID REFERENCES reconciliations(id) ON DELETE CASCADE,
+		transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+		PRIMARY KEY (reconciliation_id, transaction_id)
+	);`
+
+	postingTable := `CREATE TABLE IF NOT EXISTS postings (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+		account_id UUID REFERENCES accounts(id) ON DELETE SET NULL,
+		category_id UUID REFERENCES categories(id) ON DELETE SET NULL,
+		nominal TEXT NOT NULL DEFAULT '',
+		amount REAL NOT NULL
+	);`
+
+	balanceDiscrepancyTable := `CREATE TABLE IF NOT EXISTS balance_discrepancies (
+		account_id UUID PRIMARY KEY REFERENCES accounts(id) ON DELETE CASCADE,
+		stored_balance REAL NOT NULL,
+		ledger_balance REAL NOT NULL,
+		detected_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		checked_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	accountGroupTable := `CREATE TABLE IF NOT EXISTS account_groups (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0
+	);`
+
+	accountPositionTable := `CREATE TABLE IF NOT EXISTS account_positions (
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+		group_id UUID REFERENCES account_groups(id) ON DELETE SET NULL,
+		position INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, account_id)
+	);`
+
+	undoTokenTable := `CREATE TABLE IF NOT EXISTS undo_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		transaction_id UUID REFERENCES transactions(id) ON DELETE CASCADE,
+		action TEXT NOT NULL,
+		previous JSONB,
+		expires_at TIMESTAMPTZ NOT NULL
+	);`
+
+	// The response is stored once the first request with the key is handled
+	idempotencyKeyTable := `CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		request_hash TEXT NOT NULL,
+		status INTEGER,
+		headers JSONB,
+		body BYTEA,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (user_id, key)
+	);`
+
+	// Entries stay when their user or entity is deleted, see migration 0021
+	// for the trigger keeping the log append-only
+	auditLogTable := `CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		actor_id TEXT,
+		request_id TEXT,
+		source TEXT NOT NULL,
+		entity_type TEXT NOT NULL,
+		entity_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		changes JSONB NOT NULL
+	);`
+
 	migrationTable := `CREATE TABLE IF NOT EXISTS migrations (
 		id SERIAL PRIMARY KEY,
 		name TEXT NOT NULL UNIQUE,
 		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);`
 
-	tableStatements := []string{userTable, accountsTable, categoryTable, transactionsTable, migrationTable}
+	tableStatements := []string{userTable, userSettingsTable, accountsTable, categoryTable, transactionsTable, holidayCalendarTable, tagTable, transactionTagTable, transactionSplitTable, attachmentTable, payeeTable, payeeAliasTable, sharedExpenseTable, sharedExpenseShareTable, settlementTable, householdTable, householdMemberTable, householdInvitationTable, cardStatementTable, loanTable, loanRateTable, loanPaymentTable, tradeTable, securityPriceTable, reconciliationTable, reconciliationItemTable, postingTable, balanceDiscrepancyTable, accountGroupTable, accountPositionTable, undoTokenTable, auditLogTable, idempotencyKeyTable, migrationTable}
 
 	for _, stmt := range tableStatements {
 		_, err := db.Exec(ctx, stmt)
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"time"
+)
+
+// HolidayCalendarFile is an iCalendar file uploaded by a user
+type HolidayCalendarFile struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Content    string `json:"-"`
+	EventCount int    `json:"event_count"`
+	UserID     string `json:"user_id"`
+}
+
+// GetHolidayCalendarFiles retrieves the iCalendar files of a user
+func GetHolidayCalendarFiles(uid string) ([]HolidayCalendarFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, "SELECT id, name, content, user_id FROM holiday_calendars WHERE user_id = $1 ORDER BY created_at", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []HolidayCalendarFile
+	for rows.Next() {
+		var file HolidayCalendarFile
+		if err := rows.Scan(&file.ID, &file.Name, &file.Content, &file.UserID); err != nil {
+			return nil, err
+		}
+		if calendar, err := timeutils.ParseICal(file.Name, file.Content); err == nil {
+			file.EventCount = calendar.EventCount()
+		}
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// AddHolidayCalendarFile validates and stores an iCalendar file
+func AddHolidayCalendarFile(file HolidayCalendarFile) (HolidayCalendarFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	calendar, err := timeutils.ParseICal(file.Name, file.Content)
+	if err != nil {
+		return HolidayCalendarFile{}, fmt.Errorf("invalid iCalendar file: %v", err)
+	}
+	file.EventCount = calendar.EventCount()
+
+	query := "INSERT INTO holiday_calendars (name, content, user_id) VALUES ($1, $2, $3) RETURNING id"
+	err = db.QueryRow(ctx, query, file.Name, file.Content, file.UserID).Scan(&file.ID)
+	if err != nil {
+		return HolidayCalendarFile{}, err
+	}
+
+	return file, nil
+}
+
+// DeleteHolidayCalendarFile removes an iCalendar file of a user
+func DeleteHolidayCalendarFile(id string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, "DELETE FROM holiday_calendars WHERE id = $1 AND user_id = $2", id, uid)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("no holiday calendar found with ID %s", id)
+	}
+
+	return nil
+}
+
+// GetHolidayCalendar combines the built-in country calendar chosen in the
+// settings with every iCalendar file the user uploaded
+func GetHolidayCalendar(settings UserSettings) (timeutils.HolidayCalendar, error) {
+	var calendar timeutils.CombinedCalendar
+
+	if settings.HolidayCountry.Valid && settings.HolidayCountry.String != "" {
+		country, err := timeutils.CountryCalendar(settings.HolidayCountry.String)
+		if err != nil {
+			return nil, err
+		}
+		calendar = append(calendar, country)
+	}
+
+	files, err := GetHolidayCalendarFiles(settings.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve holiday calendars: %v", err)
+	}
+
+	for _, file := range files {
+		parsed, err := timeutils.ParseICal(file.Name, file.Content)
+		if err != nil {
+			log.Printf("Warning: skipping holiday calendar %s: %v", file.ID, err)
+			continue
+		}
+		calendar = append(calendar, parsed)
+	}
+
+	return calendar, nil
+}
+
+// GetDueDateRule returns how the user's card due dates and loan payment
+// dates move off weekends and holidays. Calendars are only loaded when
+// due dates are adjusted.
+func GetDueDateRule(settings UserSettings) (timeutils.BusinessDayRule, error) {
+	if settings.DueDateAdjustment == "" || settings.DueDateAdjustment == timeutils.AdjustNone {
+		return timeutils.BusinessDayRule{}, nil
+	}
+
+	calendar, err := GetHolidayCalendar(settings)
+	if err != nil {
+		return timeutils.BusinessDayRule{}, err
+	}
+
+	return timeutils.BusinessDayRule{Calendar: calendar, Adjustment: settings.DueDateAdjustment}, nil
+}
+
+// GetHolidays lists the holidays of a year that apply to a user
+func GetHolidays(uid string, year int) ([]timeutils.Holiday, error) {
+	settings, err := GetUserSettings(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar, err := GetHolidayCalendar(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return calendar.Holidays(year), nil
+}
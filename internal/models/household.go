@@ -0,0 +1,591 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"strings"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// InvitationTTL is how long an invitation token can be accepted
+const InvitationTTL = 7 * 24 * time.Hour
+
+// ErrForbidden is returned when the user can see a resource but their role
+// doesn't allow the change
+var ErrForbidden = errors.New("forbidden")
+
+// ErrInvalidHousehold wraps errors caused by household requests sent by the client
+var ErrInvalidHousehold = errors.New("invalid household")
+
+// Household is a shared ledger. Its accounts, their transactions and its
+// categories are visible to all members, accounts without a household stay
+// private to the user who created them.
+type Household struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	Role      string              `json:"role"` // Role of the requesting user
+	CreatedAt timeutils.Timestamp `json:"created_at"`
+	Members   []HouseholdMember   `json:"members"`
+}
+
+type HouseholdMember struct {
+	UserID      string              `json:"user_id"`
+	Email       string              `json:"email"`
+	DisplayName string              `json:"display_name"`
+	Role        string              `json:"role"`
+	JoinedAt    timeutils.Timestamp `json:"joined_at"`
+}
+
+// Invitation lets the holder of its token join a household. The token is
+// only returned when the invitation is created, the database keeps a hash.
+type Invitation struct {
+	ID          string              `json:"id"`
+	HouseholdID string              `json:"household_id"`
+	Role        string              `json:"role"`
+	Email       string              `json:"email"` // When set only this user can accept
+	Token       string              `json:"token,omitempty"`
+	InvitedBy   string              `json:"invited_by"`
+	CreatedAt   timeutils.Timestamp `json:"created_at"`
+	ExpiresAt   timeutils.Timestamp `json:"expires_at"`
+}
+
+// visibleAccounts selects the ids of the accounts the user identified by
+// the placeholder can see: their personal accounts and the accounts of
+// their households
+func visibleAccounts(uid string) string {
+	return `SELECT a.id FROM accounts a
+		WHERE (a.household_id IS NULL AND a.user_id = ` + uid + `)
+		   OR a.household_id IN (SELECT hm.household_id FROM household_members hm WHERE hm.user_id = ` + uid + `)`
+}
+
+// writableAccounts is like visibleAccounts, leaving out the accounts of
+// households where the user is a viewer
+func writableAccounts(uid string) string {
+	return `SELECT a.id FROM accounts a
+		WHERE (a.household_id IS NULL AND a.user_id = ` + uid + `)
+		   OR a.household_id IN (SELECT hm.household_id FROM household_members hm
+		                         WHERE hm.user_id = ` + uid + ` AND hm.role IN ('owner', 'editor'))`
+}
+
+// visibleTransaction is the condition matching the transactions, aliased as
+// t, the user can see. Transactions follow the visibility of their account,
+// the ones in the trash are only seen through GetTrash.
+func visibleTransaction(uid string) string {
+	return "(t.deleted_at IS NULL AND (t.account_id IN (" + visibleAccounts(uid) + ") OR (t.account_id IS NULL AND t.user_id = " + uid + ")))"
+}
+
+// writableTransaction is the condition matching the transactions the user can change
+func writableTransaction(uid string) string {
+	return "(t.account_id IN (" + writableAccounts(uid) + ") OR (t.account_id IS NULL AND t.user_id = " + uid + "))"
+}
+
+// canWrite tells whether a role allows changing shared data
+func canWrite(role string) bool {
+	return role == RoleOwner || role == RoleEditor
+}
+
+func isValidRole(role string) bool {
+	return role == RoleOwner || role == RoleEditor || role == RoleViewer
+}
+
+// householdRole returns the role of the user in a household
+func householdRole(ctx context.Context, q pgxQuerier, householdID string, uid string) (string, error) {
+	var role string
+	err := q.QueryRow(ctx, "SELECT role FROM household_members WHERE household_id::text = $1 AND user_id = $2",
+		householdID, uid).Scan(&role)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", fmt.Errorf("%w: household %s", ErrNotFound, householdID)
+		}
+		return "", fmt.Errorf("failed to retrieve household role: %v", err)
+	}
+	return role, nil
+}
+
+// requireHouseholdRole fails with ErrForbidden when the user's role in the
+// household isn't one of the given roles
+func requireHouseholdRole(ctx context.Context, q pgxQuerier, householdID string, uid string, roles ...string) (string, error) {
+	role, err := householdRole(ctx, q, householdID, uid)
+	if err != nil {
+		return "", err
+	}
+	for _, allowed := range roles {
+		if role == allowed {
+			return role, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s members can't do this", ErrForbidden, role)
+}
+
+// pgxQuerier is implemented by the pool and by database transactions
+type pgxQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// GetHouseholds lists the households of the user with their members
+func GetHouseholds(uid string) ([]Household, error) {
+	return findHouseholds("", uid)
+}
+
+// GetHousehold returns a household of the user with its members
+func GetHousehold(id string, uid string) (Household, error) {
+	households, err := findHouseholds(id, uid)
+	if err != nil {
+		return Household{}, err
+	}
+	if len(households) == 0 {
+		return Household{}, fmt.Errorf("%w: household %s", ErrNotFound, id)
+	}
+	return households[0], nil
+}
+
+func findHouseholds(id string, uid string) ([]Household, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT h.id, h.name, me.role, h.created_at,
+			(SELECT json_agg(json_build_object(
+				'user_id', m.user_id, 'email', COALESCE(u.email, ''),
+				'display_name', COALESCE(u.display_name, ''), 'role', m.role, 'joined_at', m.joined_at
+			) ORDER BY m.joined_at)
+			FROM household_members m LEFT JOIN users u ON u.id = m.user_id
+			WHERE m.household_id = h.id)
+		FROM households h
+		JOIN household_members me ON me.household_id = h.id AND me.user_id = $1
+		WHERE $2 = '' OR h.id::text = $2
+		ORDER BY h.name`, uid, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve households: %v", err)
+	}
+	defer rows.Close()
+
+	households := []Household{}
+	for rows.Next() {
+		var household Household
+		if err := rows.Scan(&household.ID, &household.Name, &household.Role, &household.CreatedAt, &household.Members); err != nil {
+			return nil, fmt.Errorf("failed to scan household: %v", err)
+		}
+		households = append(households, household)
+	}
+
+	return households, rows.Err()
+}
+
+// AddHousehold creates a household owned by the user
+func AddHousehold(name string, uid string) (Household, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Household{}, fmt.Errorf("%w: the name is required", ErrInvalidHousehold)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	err = tx.QueryRow(ctx, "INSERT INTO households (name, created_by) VALUES ($1, $2) RETURNING id", name, uid).Scan(&id)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to insert household: %v", err)
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO household_members (household_id, user_id, role) VALUES ($1, $2, $3)", id, uid, RoleOwner)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to add household owner: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Household{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return GetHousehold(id, uid)
+}
+
+// RenameHousehold changes the name of a household, owners only
+func RenameHousehold(id string, name string, uid string) (Household, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Household{}, fmt.Errorf("%w: the name is required", ErrInvalidHousehold)
+	}
+
+	if _, err := requireHouseholdRole(ctx, db, id, uid, RoleOwner); err != nil {
+		return Household{}, err
+	}
+
+	if _, err := db.Exec(ctx, "UPDATE households SET name = $1 WHERE id::text = $2", name, id); err != nil {
+		return Household{}, fmt.Errorf("failed to update household: %v", err)
+	}
+
+	return GetHousehold(id, uid)
+}
+
+// DeleteHousehold removes a household, owners only. Its accounts go back
+// to the members who created them and its categories are removed.
+func DeleteHousehold(id string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := requireHouseholdRole(ctx, db, id, uid, RoleOwner); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(ctx, "DELETE FROM households WHERE id::text = $1", id); err != nil {
+		return fmt.Errorf("failed to delete household: %v", err)
+	}
+	return nil
+}
+
+// InviteToHousehold creates an invitation with the given role, owners only
+func InviteToHousehold(householdID string, role string, email string, uid string) (Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if role == "" {
+		role = RoleEditor
+	}
+	if !isValidRole(role) {
+		return Invitation{}, fmt.Errorf("%w: role must be owner, editor or viewer", ErrInvalidHousehold)
+	}
+
+	if _, err := requireHouseholdRole(ctx, db, householdID, uid, RoleOwner); err != nil {
+		return Invitation{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Invitation{}, fmt.Errorf("failed to generate invitation token: %v", err)
+	}
+
+	invitation := Invitation{
+		HouseholdID: householdID,
+		Role:        role,
+		Email:       strings.TrimSpace(email),
+		Token:       base64.RawURLEncoding.EncodeToString(secret),
+		InvitedBy:   uid,
+	}
+
+	err := db.QueryRow(ctx, `
+		INSERT INTO household_invitations (household_id, token_hash, role, email, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, expires_at`,
+		householdID, hashToken(invitation.Token), role, invitation.Email, uid, time.Now().Add(InvitationTTL),
+	).Scan(&invitation.ID, &invitation.CreatedAt, &invitation.ExpiresAt)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("failed to insert invitation: %v", err)
+	}
+
+	return invitation, nil
+}
+
+// GetInvitations lists the pending invitations of a household, owners only
+func GetInvitations(householdID string, uid string) ([]Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := requireHouseholdRole(ctx, db, householdID, uid, RoleOwner); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, household_id, role, email, invited_by, created_at, expires_at
+		FROM household_invitations
+		WHERE household_id::text = $1 AND accepted_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at`, householdID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve invitations: %v", err)
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		if err := rows.Scan(&invitation.ID, &invitation.HouseholdID, &invitation.Role, &invitation.Email,
+			&invitation.InvitedBy, &invitation.CreatedAt, &invitation.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invitation: %v", err)
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation deletes a pending invitation, owners only
+func RevokeInvitation(householdID string, invitationID string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := requireHouseholdRole(ctx, db, householdID, uid, RoleOwner); err != nil {
+		return err
+	}
+
+	result, err := db.Exec(ctx, `
+		DELETE FROM household_invitations
+		WHERE id::text = $1 AND household_id::text = $2 AND accepted_at IS NULL`, invitationID, householdID)
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: invitation %s", ErrNotFound, invitationID)
+	}
+	return nil
+}
+
+// AcceptInvitation makes the user a member of the household of the
+// invitation. Tokens can only be used once and before they expire.
+func AcceptInvitation(token string, uid string) (Household, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id, householdID, role, email string
+	var expiresAt time.Time
+	var acceptedAt null.Time
+	err = tx.QueryRow(ctx, `
+		SELECT id, household_id, role, email, expires_at, accepted_at
+		FROM household_invitations
+		WHERE token_hash = $1
+		FOR UPDATE`, hashToken(strings.TrimSpace(token))).Scan(&id, &householdID, &role, &email, &expiresAt, &acceptedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Household{}, fmt.Errorf("%w: unknown invitation", ErrInvalidHousehold)
+		}
+		return Household{}, fmt.Errorf("failed to retrieve invitation: %v", err)
+	}
+	if acceptedAt.Valid {
+		return Household{}, fmt.Errorf("%w: the invitation was already used", ErrInvalidHousehold)
+	}
+	if time.Now().After(expiresAt) {
+		return Household{}, fmt.Errorf("%w: the invitation expired", ErrInvalidHousehold)
+	}
+
+	if email != "" {
+		var matches bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND LOWER(email) = LOWER($2))", uid, email).Scan(&matches)
+		if err != nil {
+			return Household{}, fmt.Errorf("failed to check invitation email: %v", err)
+		}
+		if !matches {
+			return Household{}, fmt.Errorf("%w: the invitation is for another user", ErrForbidden)
+		}
+	}
+
+	result, err := tx.Exec(ctx, `
+		INSERT INTO household_members (household_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (household_id, user_id) DO NOTHING`, householdID, uid, role)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to add household member: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return Household{}, fmt.Errorf("%w: you are already a member of this household", ErrInvalidHousehold)
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE household_invitations SET accepted_at = NOW(), accepted_by = $1 WHERE id = $2", uid, id)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to update invitation: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Household{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return GetHousehold(householdID, uid)
+}
+
+// SetMemberRole changes the role of a member, owners only. A household
+// always keeps at least one owner.
+func SetMemberRole(householdID string, memberID string, role string, uid string) (Household, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !isValidRole(role) {
+		return Household{}, fmt.Errorf("%w: role must be owner, editor or viewer", ErrInvalidHousehold)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockHousehold(ctx, tx, householdID); err != nil {
+		return Household{}, err
+	}
+	if _, err := requireHouseholdRole(ctx, tx, householdID, uid, RoleOwner); err != nil {
+		return Household{}, err
+	}
+
+	current, err := householdRole(ctx, tx, householdID, memberID)
+	if err != nil {
+		return Household{}, err
+	}
+	if current == RoleOwner && role != RoleOwner {
+		if err := checkOtherOwner(ctx, tx, householdID, memberID); err != nil {
+			return Household{}, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE household_members SET role = $1 WHERE household_id::text = $2 AND user_id = $3",
+		role, householdID, memberID)
+	if err != nil {
+		return Household{}, fmt.Errorf("failed to update household member: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Household{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return GetHousehold(householdID, uid)
+}
+
+// RemoveMember removes a member from a household. Owners can remove anyone
+// and every member can leave, as long as an owner remains.
+func RemoveMember(householdID string, memberID string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockHousehold(ctx, tx, householdID); err != nil {
+		return err
+	}
+	if memberID == uid {
+		if _, err := householdRole(ctx, tx, householdID, uid); err != nil {
+			return err
+		}
+	} else if _, err := requireHouseholdRole(ctx, tx, householdID, uid, RoleOwner); err != nil {
+		return err
+	}
+
+	current, err := householdRole(ctx, tx, householdID, memberID)
+	if err != nil {
+		return err
+	}
+	if current == RoleOwner {
+		if err := checkOtherOwner(ctx, tx, householdID, memberID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM household_members WHERE household_id::text = $1 AND user_id = $2", householdID, memberID)
+	if err != nil {
+		return fmt.Errorf("failed to remove household member: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return nil
+}
+
+// MoveAccountToHousehold shares a personal account of the user with a
+// household where they can edit
+func MoveAccountToHousehold(householdID string, accountID string, uid string) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := requireHouseholdRole(ctx, db, householdID, uid, RoleOwner, RoleEditor); err != nil {
+		return Account{}, err
+	}
+
+	result, err := db.Exec(ctx, `
+		UPDATE accounts SET household_id = $1
+		WHERE id::text = $2 AND user_id = $3 AND household_id IS NULL`, householdID, accountID, uid)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to move account: %v", err)
+	}
+	if result.RowsAffected() == 0 {
+		return Account{}, fmt.Errorf("%w: personal account %s", ErrNotFound, accountID)
+	}
+
+	return GetAccountByID(null.StringFrom(accountID), uid)
+}
+
+// RemoveAccountFromHousehold makes a household account personal again, for
+// the member who created it. Owners and that member can do it.
+func RemoveAccountFromHousehold(householdID string, accountID string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	role, err := householdRole(ctx, db, householdID, uid)
+	if err != nil {
+		return err
+	}
+
+	var creator string
+	err = db.QueryRow(ctx, "SELECT user_id FROM accounts WHERE id::text = $1 AND household_id::text = $2",
+		accountID, householdID).Scan(&creator)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("%w: account %s", ErrNotFound, accountID)
+		}
+		return fmt.Errorf("failed to retrieve account: %v", err)
+	}
+	if role != RoleOwner && creator != uid {
+		return fmt.Errorf("%w: only owners and the creator of the account can remove it", ErrForbidden)
+	}
+
+	if _, err := db.Exec(ctx, "UPDATE accounts SET household_id = NULL WHERE id::text = $1", accountID); err != nil {
+		return fmt.Errorf("failed to remove account from household: %v", err)
+	}
+	return nil
+}
+
+// lockHousehold serializes membership changes of a household
+func lockHousehold(ctx context.Context, tx pgx.Tx, householdID string) error {
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM households WHERE id::text = $1 FOR UPDATE", householdID); err != nil {
+		return fmt.Errorf("failed to lock household: %v", err)
+	}
+	return nil
+}
+
+// checkOtherOwner fails when the member is the last owner of the household
+func checkOtherOwner(ctx context.Context, tx pgx.Tx, householdID string, memberID string) error {
+	var owners int
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM household_members
+		WHERE household_id::text = $1 AND role = $2 AND user_id <> $3`, householdID, RoleOwner, memberID).Scan(&owners)
+	if err != nil {
+		return fmt.Errorf("failed to count household owners: %v", err)
+	}
+	if owners == 0 {
+		return fmt.Errorf("%w: a household needs another owner first", ErrInvalidHousehold)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
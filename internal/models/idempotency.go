@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes back with another request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+	// ErrIdempotencyKeyInProgress is returned while the first request with a key is handled
+	ErrIdempotencyKeyInProgress = errors.New("idempotency key in progress")
+)
+
+// abandonedReservation is how long a key stays reserved for a request that
+// never finished. Handlers chain timeouts of up to 60 seconds (an upload to
+// the blob store then its queries), so it is well past the slowest request
+// that can still finish and replaying it twice is never a risk.
+const abandonedReservation = 10 * time.Minute
+
+// IdempotentResponse is the response to the first request sent with an
+// idempotency key, replayed to the retries of the request
+type IdempotentResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+// ReserveIdempotencyKey claims a key of the user for a request, identified
+// by its hash, until ttl. It returns the stored response when the request was
+// already handled, nil when the caller got the key and must handle it. Expired
+// keys and those of abandoned requests can be claimed again.
+func ReserveIdempotencyKey(uid string, key string, requestHash string, ttl time.Duration) (*IdempotentResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO UPDATE SET
+			created_at = CURRENT_TIMESTAMP,
+			request_hash = EXCLUDED.request_hash,
+			status = NULL,
+			headers = NULL,
+			body = NULL,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < CURRENT_TIMESTAMP
+		   OR (idempotency_keys.status IS NULL AND idempotency_keys.created_at < CURRENT_TIMESTAMP - make_interval(secs => $5))`,
+		uid, key, requestHash, time.Now().Add(ttl), abandonedReservation.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	if result.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var storedHash string
+	var status *int
+	var headers, body []byte
+	err = db.QueryRow(ctx, "SELECT request_hash, status, headers, body FROM idempotency_keys WHERE user_id = $1 AND key = $2",
+		uid, key).Scan(&storedHash, &status, &headers, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve idempotency key: %v", err)
+	}
+	if storedHash != requestHash {
+		return nil, fmt.Errorf("%w: the key was used for another request", ErrIdempotencyKeyReused)
+	}
+	if status == nil {
+		return nil, fmt.Errorf("%w: the first request with this key isn't finished", ErrIdempotencyKeyInProgress)
+	}
+
+	response := &IdempotentResponse{Status: *status, Body: body}
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &response.Headers); err != nil {
+			return nil, fmt.Errorf("failed to decode stored headers: %v", err)
+		}
+	}
+	return response, nil
+}
+
+// SaveIdempotentResponse stores the response to the request a key of the
+// user was reserved for
+func SaveIdempotentResponse(uid string, key string, response IdempotentResponse) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %v", err)
+	}
+	_, err = db.Exec(ctx, `
+		UPDATE idempotency_keys SET status = $3, headers = $4, body = $5
+		WHERE user_id = $1 AND key = $2 AND status IS NULL`, uid, key, response.Status, headers, response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey frees a key of the user whose request failed, so
+// that a retry is handled again
+func ReleaseIdempotencyKey(uid string, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Exec(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status IS NULL", uid, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys removes the expired idempotency keys
+func PurgeIdempotencyKeys() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return fmt.Errorf("failed to purge idempotency keys: %v", err)
+	}
+	if result.RowsAffected() > 0 {
+		log.Printf("Purged %d expired idempotency keys", result.RowsAffected())
+	}
+	return nil
+}
@@ -0,0 +1,1165 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/prices"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// TransactionTypeTrade is the cash side of buying and selling securities
+const TransactionTypeTrade = "Trade"
+
+const (
+	TradeBuy      = "buy"
+	TradeSell     = "sell"
+	TradeDividend = "dividend"
+	TradeSplit    = "split"
+)
+
+const (
+	CostFIFO    = "fifo"
+	CostAverage = "average"
+)
+
+// investmentTypes are the account types holding securities
+var investmentTypes = []string{"Investment", "Brokerage", "Pension"}
+
+// quantityEpsilon absorbs float rounding when comparing share quantities
+const quantityEpsilon = 1e-9
+
+// ErrInvalidInvestment wraps errors caused by trades and prices sent by the client
+var ErrInvalidInvestment = errors.New("invalid investment")
+
+// InvestmentTerms are the settings of an investment account. Its balance
+// is the cash it holds, securities are valued from their prices.
+type InvestmentTerms struct {
+	CostMethod string `json:"cost_method"` // "fifo" or "average", how sales consume the cost basis
+}
+
+// Trade is a buy, sell, dividend or split of a security in an investment
+// account. Buys, sells and dividends move cash through a transaction of the
+// account.
+type Trade struct {
+	ID            string              `json:"id"`
+	AccountID     string              `json:"account_id"`
+	Type          string              `json:"type"`
+	Symbol        string              `json:"symbol"`
+	Date          timeutils.Timestamp `json:"date"`
+	Quantity      float64             `json:"quantity"` // Shares bought or sold
+	Price         float64             `json:"price"`    // Price of one share
+	Fees          float64             `json:"fees"`
+	Ratio         float64             `json:"ratio"`  // Splits only, shares after the split per share before it
+	Amount        float64             `json:"amount"` // Cash received by the account, negative when paid. Sent for dividends.
+	TransactionID null.String         `json:"transaction_id"`
+	CreatedAt     timeutils.Timestamp `json:"created_at"`
+}
+
+// Lot is a purchase still held, with what it cost including fees. With the
+// average cost method all the shares of a security are a single lot.
+type Lot struct {
+	Date     timeutils.Timestamp `json:"date"`
+	Quantity float64             `json:"quantity"`
+	Cost     float64             `json:"cost"`
+	UnitCost float64             `json:"unit_cost"`
+}
+
+// Holding is a security held in an account. Without a known price it is
+// valued at the price of its last trade and PriceDate is null.
+type Holding struct {
+	Symbol          string              `json:"symbol"`
+	Quantity        float64             `json:"quantity"`
+	CostBasis       float64             `json:"cost_basis"`
+	AverageCost     float64             `json:"average_cost"`
+	Price           float64             `json:"price"`
+	PriceDate       timeutils.Timestamp `json:"price_date"`
+	MarketValue     float64             `json:"market_value"`
+	MarketValueBase float64             `json:"market_value_base"` // In the base currency
+	Unrealized      float64             `json:"unrealized"`
+	Realized        float64             `json:"realized"`
+	Dividends       float64             `json:"dividends"`
+	Lots            []Lot               `json:"lots"`
+}
+
+// InvestmentSummary is the value of an investment account: its cash and
+// its holdings, sold securities included for their realized gains
+type InvestmentSummary struct {
+	AccountID       string    `json:"account_id"`
+	Name            string    `json:"name"`
+	Currency        string    `json:"currency"`
+	CostMethod      string    `json:"cost_method"`
+	Cash            float64   `json:"cash"`
+	MarketValue     float64   `json:"market_value"` // Cash included
+	MarketValueBase float64   `json:"market_value_base"`
+	CostBasis       float64   `json:"cost_basis"`
+	Unrealized      float64   `json:"unrealized"`
+	Realized        float64   `json:"realized"`
+	Dividends       float64   `json:"dividends"`
+	Holdings        []Holding `json:"holdings"`
+}
+
+// Performance is the return of an account, or of all of them in the base
+// currency, over a period. The time-weighted return ignores when money was
+// deposited or withdrawn, the money-weighted return (XIRR) is annualized
+// and accounts for it. Either is null when there is nothing to measure.
+type Performance struct {
+	AccountID           string              `json:"account_id,omitempty"`
+	Name                string              `json:"name,omitempty"`
+	Currency            string              `json:"currency,omitempty"` // Empty for the base currency
+	From                timeutils.Timestamp `json:"from"`
+	To                  timeutils.Timestamp `json:"to"`
+	StartValue          float64             `json:"start_value"`
+	EndValue            float64             `json:"end_value"`
+	NetContributions    float64             `json:"net_contributions"` // Deposits minus withdrawals
+	Gain                float64             `json:"gain"`
+	TimeWeightedReturn  null.Float          `json:"time_weighted_return"`
+	MoneyWeightedReturn null.Float          `json:"money_weighted_return"`
+}
+
+// PortfolioPerformance is the performance of all investment accounts
+type PortfolioPerformance struct {
+	Total    Performance   `json:"total"`
+	Accounts []Performance `json:"accounts"`
+}
+
+// SecurityPrice is a stored closing price
+type SecurityPrice struct {
+	Symbol   string              `json:"symbol"`
+	Date     timeutils.Timestamp `json:"date"`
+	Price    float64             `json:"price"`
+	Currency null.String         `json:"currency"`
+	Source   string              `json:"source"`
+}
+
+// PriceRefresh reports the prices fetched for a symbol
+type PriceRefresh struct {
+	Symbol   string `json:"symbol"`
+	Imported int    `json:"imported"`
+	Error    string `json:"error,omitempty"`
+}
+
+var priceProvider prices.Provider = prices.ManualProvider{}
+
+// InitializePriceProvider opens the price provider selected in the config
+func InitializePriceProvider() error {
+	provider, err := prices.NewFromConfig()
+	if err != nil {
+		return err
+	}
+	priceProvider = provider
+	return nil
+}
+
+// IsInvestment tells whether the account holds securities
+func (a Account) IsInvestment() bool {
+	for _, t := range investmentTypes {
+		if strings.EqualFold(strings.TrimSpace(a.Type), t) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareInvestmentTerms validates the settings of an investment account
+// and drops them from other accounts
+func prepareInvestmentTerms(account *Account) error {
+	if !account.IsInvestment() {
+		account.Investment = nil
+		return nil
+	}
+	if account.Investment == nil {
+		account.Investment = &InvestmentTerms{}
+	}
+	account.Investment.CostMethod = strings.ToLower(strings.TrimSpace(account.Investment.CostMethod))
+	switch account.Investment.CostMethod {
+	case "":
+		account.Investment.CostMethod = CostFIFO
+	case CostFIFO, CostAverage:
+	default:
+		return fmt.Errorf("%w: cost_method must be fifo or average", ErrInvalidInvestment)
+	}
+	return nil
+}
+
+// costMethod returns the cost_method column of an account
+func (i *InvestmentTerms) costMethod() null.String {
+	if i == nil {
+		return null.String{}
+	}
+	return null.StringFrom(i.CostMethod)
+}
+
+// position is a security while replaying the trades of an account
+type position struct {
+	lots      []Lot
+	realized  float64
+	dividends float64
+	lastPrice float64
+}
+
+func (p *position) quantity() float64 {
+	quantity := 0.0
+	for _, lot := range p.lots {
+		quantity += lot.Quantity
+	}
+	return quantity
+}
+
+func (p *position) cost() float64 {
+	cost := 0.0
+	for _, lot := range p.lots {
+		cost += lot.Cost
+	}
+	return cost
+}
+
+// replayTrades rebuilds the positions of an account from its trades, in
+// date order, up to a time (all of them when zero). Selling more shares
+// than held at the time of the sale is an error.
+func replayTrades(trades []Trade, method string, until time.Time) (map[string]*position, error) {
+	positions := map[string]*position{}
+	for _, trade := range trades {
+		if !until.IsZero() && trade.Date.After(until) {
+			break
+		}
+
+		p := positions[trade.Symbol]
+		if p == nil {
+			p = &position{}
+			positions[trade.Symbol] = p
+		}
+
+		switch trade.Type {
+		case TradeBuy:
+			lot := Lot{Date: trade.Date, Quantity: trade.Quantity, Cost: trade.Quantity*trade.Price + trade.Fees}
+			if method == CostAverage && len(p.lots) > 0 {
+				p.lots[0].Quantity += lot.Quantity
+				p.lots[0].Cost += lot.Cost
+			} else {
+				p.lots = append(p.lots, lot)
+			}
+			p.lastPrice = trade.Price
+
+		case TradeSell:
+			held := p.quantity()
+			if trade.Quantity > held+quantityEpsilon {
+				return nil, fmt.Errorf("%w: only %g %s held on %s", ErrInvalidInvestment, held, trade.Symbol, trade.Date.Format("2006-01-02"))
+			}
+			// The oldest lots are sold first, with the average cost method
+			// there is a single lot
+			remaining, cost := trade.Quantity, 0.0
+			for remaining > quantityEpsilon && len(p.lots) > 0 {
+				lot := &p.lots[0]
+				sold := math.Min(remaining, lot.Quantity)
+				part := lot.Cost * sold / lot.Quantity
+				cost += part
+				lot.Cost -= part
+				lot.Quantity -= sold
+				remaining -= sold
+				if lot.Quantity <= quantityEpsilon {
+					p.lots = p.lots[1:]
+				}
+			}
+			p.realized += trade.Quantity*trade.Price - trade.Fees - cost
+			p.lastPrice = trade.Price
+
+		case TradeDividend:
+			p.dividends += trade.Amount
+
+		case TradeSplit:
+			for i := range p.lots {
+				p.lots[i].Quantity *= trade.Ratio
+			}
+			p.lastPrice /= trade.Ratio
+		}
+	}
+	return positions, nil
+}
+
+// pricePoint is a closing price, dates are midnight UTC
+type pricePoint struct {
+	date  time.Time
+	price float64
+}
+
+// priceHistory holds the prices of symbols in date order
+type priceHistory map[string][]pricePoint
+
+// at returns the last price of a symbol on or before a time
+func (h priceHistory) at(symbol string, at time.Time) (pricePoint, bool) {
+	points := h[symbol]
+	i := sort.Search(len(points), func(i int) bool { return points[i].date.After(at) })
+	if i == 0 {
+		return pricePoint{}, false
+	}
+	return points[i-1], true
+}
+
+// accountFlow is a transaction changing the cash of an investment account.
+// External flows are deposits and withdrawals, the others come from trades.
+type accountFlow struct {
+	date     time.Time
+	amount   float64
+	external bool
+}
+
+// valuation values an investment account at any time from its trades,
+// prices and transactions. Values are multiplied by rate, 1 to stay in the
+// account currency.
+type valuation struct {
+	account Account
+	trades  []Trade
+	prices  priceHistory
+	flows   []accountFlow
+	rate    float64
+}
+
+// cash is the balance of the account at a time, the current balance
+// without the transactions made after it
+func (v *valuation) cash(at time.Time) float64 {
+	cash := v.account.Balance
+	for _, flow := range v.flows {
+		if flow.date.After(at) {
+			cash -= flow.amount
+		}
+	}
+	return cash
+}
+
+// value is the cash and the market value of the holdings at a time
+func (v *valuation) value(at time.Time) float64 {
+	positions, _ := replayTrades(v.trades, v.account.Investment.CostMethod, at)
+	value := v.cash(at)
+	for symbol, p := range positions {
+		price := p.lastPrice
+		if point, ok := v.prices.at(symbol, at); ok {
+			price = point.price
+		}
+		value += p.quantity() * price
+	}
+	return value * v.rate
+}
+
+// getInvestmentAccount returns an investment account visible to the user
+func getInvestmentAccount(accountID string, uid string) (Account, error) {
+	account, err := GetAccountByID(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Account{}, err
+	}
+	if !account.IsInvestment() || account.Investment == nil {
+		return Account{}, fmt.Errorf("%w: %s is not an investment account", ErrInvalidInvestment, account.Name)
+	}
+	return account, nil
+}
+
+// pgxRowsQuerier is implemented by both the pool and transactions
+type pgxRowsQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// findTrades reads the trades of an account in the order they are replayed
+func findTrades(ctx context.Context, q pgxRowsQuerier, accountID string) ([]Trade, error) {
+	rows, err := q.Query(ctx, `
+		SELECT id, account_id, type, symbol, date, quantity, price, fees, ratio, amount, transaction_id::text, created_at
+		FROM investment_trades
+		WHERE account_id = $1
+		ORDER BY date, created_at, id`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve trades: %v", err)
+	}
+	defer rows.Close()
+
+	trades := []Trade{}
+	for rows.Next() {
+		var trade Trade
+		if err := rows.Scan(&trade.ID, &trade.AccountID, &trade.Type, &trade.Symbol, &trade.Date, &trade.Quantity, &trade.Price,
+			&trade.Fees, &trade.Ratio, &trade.Amount, &trade.TransactionID, &trade.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan trade: %v", err)
+		}
+		trades = append(trades, trade)
+	}
+	return trades, rows.Err()
+}
+
+// loadValuation reads what is needed to value an account. Prices uploaded
+// by the user take precedence over those of the account's owner.
+func loadValuation(ctx context.Context, account Account, uid string) (*valuation, error) {
+	trades, err := findTrades(ctx, db, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := replayTrades(trades, account.Investment.CostMethod, time.Time{}); err != nil {
+		return nil, err
+	}
+
+	v := &valuation{account: account, trades: trades, prices: priceHistory{}, rate: 1}
+
+	rows, err := db.Query(ctx, `
+		SELECT DISTINCT ON (p.symbol, p.date) p.symbol, p.date, p.price
+		FROM security_prices p
+		WHERE p.user_id IN ($1, $2)
+		  AND p.symbol IN (SELECT symbol FROM investment_trades WHERE account_id = $3)
+		ORDER BY p.symbol, p.date, p.user_id = $1 DESC`, uid, account.UserID, account.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve prices: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var symbol string
+		var point pricePoint
+		if err := rows.Scan(&symbol, &point.date, &point.price); err != nil {
+			return nil, fmt.Errorf("failed to scan price: %v", err)
+		}
+		v.prices[symbol] = append(v.prices[symbol], point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	flows, err := db.Query(ctx, `
+		SELECT t.date, `+balanceEffect("$1")+`,
+			NOT EXISTS (SELECT 1 FROM investment_trades it WHERE it.transaction_id = t.id)
+		FROM transactions t
+		WHERE t.account_id = $1 OR t.related_account_id = $1
+		ORDER BY t.date`, account.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve account transactions: %v", err)
+	}
+	defer flows.Close()
+	for flows.Next() {
+		var flow accountFlow
+		if err := flows.Scan(&flow.date, &flow.amount, &flow.external); err != nil {
+			return nil, fmt.Errorf("failed to scan account transaction: %v", err)
+		}
+		v.flows = append(v.flows, flow)
+	}
+
+	return v, flows.Err()
+}
+
+// exchangeRateOf converts amounts in a currency to the base currency, 0
+// when the rate is unknown
+func exchangeRateOf(currency string) float64 {
+	rate, err := utils.GetExchangeRate(currency)
+	if err != nil {
+		log.Printf("Warning: Exchange rate not found for currency '%s'. Values won't be converted.", currency)
+		return 0
+	}
+	return rate
+}
+
+// GetHoldings values an investment account and its holdings at the latest
+// prices
+func GetHoldings(accountID string, uid string) (InvestmentSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := getInvestmentAccount(accountID, uid)
+	if err != nil {
+		return InvestmentSummary{}, err
+	}
+	v, err := loadValuation(ctx, account, uid)
+	if err != nil {
+		return InvestmentSummary{}, err
+	}
+	positions, err := replayTrades(v.trades, account.Investment.CostMethod, time.Time{})
+	if err != nil {
+		return InvestmentSummary{}, err
+	}
+
+	rate := exchangeRateOf(account.Currency)
+	now := time.Now()
+	summary := InvestmentSummary{
+		AccountID:  account.ID,
+		Name:       account.Name,
+		Currency:   account.Currency,
+		CostMethod: account.Investment.CostMethod,
+		Cash:       roundCents(account.Balance),
+		Holdings:   []Holding{},
+	}
+	summary.MarketValue = summary.Cash
+
+	for symbol, p := range positions {
+		holding := Holding{
+			Symbol:    symbol,
+			Quantity:  p.quantity(),
+			CostBasis: roundCents(p.cost()),
+			Price:     p.lastPrice,
+			Realized:  roundCents(p.realized),
+			Dividends: roundCents(p.dividends),
+			Lots:      []Lot{},
+		}
+		if point, ok := v.prices.at(symbol, now); ok {
+			holding.Price = point.price
+			holding.PriceDate = timeutils.NewTimestamp(point.date)
+		}
+		if holding.Quantity > quantityEpsilon {
+			holding.AverageCost = p.cost() / holding.Quantity
+		} else {
+			holding.Quantity = 0
+		}
+		holding.MarketValue = roundCents(holding.Quantity * holding.Price)
+		holding.MarketValueBase = roundCents(holding.MarketValue * rate)
+		holding.Unrealized = roundCents(holding.MarketValue - holding.CostBasis)
+		for _, lot := range p.lots {
+			lot.UnitCost = lot.Cost / lot.Quantity
+			lot.Cost = roundCents(lot.Cost)
+			holding.Lots = append(holding.Lots, lot)
+		}
+
+		summary.MarketValue += holding.MarketValue
+		summary.CostBasis += holding.CostBasis
+		summary.Unrealized += holding.Unrealized
+		summary.Realized += holding.Realized
+		summary.Dividends += holding.Dividends
+		summary.Holdings = append(summary.Holdings, holding)
+	}
+
+	sort.Slice(summary.Holdings, func(i, j int) bool { return summary.Holdings[i].Symbol < summary.Holdings[j].Symbol })
+	summary.MarketValue = roundCents(summary.MarketValue)
+	summary.MarketValueBase = roundCents(summary.MarketValue * rate)
+	summary.CostBasis = roundCents(summary.CostBasis)
+	summary.Unrealized = roundCents(summary.Unrealized)
+	summary.Realized = roundCents(summary.Realized)
+	summary.Dividends = roundCents(summary.Dividends)
+
+	return summary, nil
+}
+
+// GetTrades lists the trades of an investment account, latest first
+func GetTrades(accountID string, uid string) ([]Trade, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := getInvestmentAccount(accountID, uid)
+	if err != nil {
+		return nil, err
+	}
+	trades, err := findTrades(ctx, db, account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := GetUserLocation(uid)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(trades)-1; i < j; i, j = i+1, j-1 {
+		trades[i], trades[j] = trades[j], trades[i]
+	}
+	for i := range trades {
+		trades[i].Date = trades[i].Date.In(loc)
+	}
+	return trades, nil
+}
+
+// prepareTrade validates a trade and computes the cash it moves
+func prepareTrade(trade *Trade) error {
+	trade.Type = strings.ToLower(strings.TrimSpace(trade.Type))
+	trade.Symbol = prices.NormalizeSymbol(trade.Symbol)
+	if trade.Symbol == "" {
+		return fmt.Errorf("%w: symbol is required", ErrInvalidInvestment)
+	}
+	if trade.Date.IsZero() {
+		trade.Date = timeutils.NewTimestamp(time.Now())
+	}
+	if trade.Fees < 0 {
+		return fmt.Errorf("%w: fees can't be negative", ErrInvalidInvestment)
+	}
+
+	switch trade.Type {
+	case TradeBuy, TradeSell:
+		if trade.Quantity <= 0 || trade.Price < 0 {
+			return fmt.Errorf("%w: a %s needs a positive quantity and a price", ErrInvalidInvestment, trade.Type)
+		}
+		trade.Ratio = 0
+		trade.Amount = roundCents(trade.Quantity*trade.Price + trade.Fees)
+		if trade.Type == TradeBuy {
+			trade.Amount = -trade.Amount
+		} else {
+			trade.Amount = roundCents(trade.Quantity*trade.Price - trade.Fees)
+		}
+	case TradeDividend:
+		if trade.Amount <= 0 {
+			return fmt.Errorf("%w: a dividend needs the positive amount received", ErrInvalidInvestment)
+		}
+		trade.Amount = roundCents(trade.Amount)
+		trade.Quantity, trade.Price, trade.Fees, trade.Ratio = 0, 0, 0, 0
+	case TradeSplit:
+		if trade.Ratio <= 0 {
+			return fmt.Errorf("%w: a split needs a positive ratio, e.g. 2 for a 2-for-1 split", ErrInvalidInvestment)
+		}
+		trade.Quantity, trade.Price, trade.Fees, trade.Amount = 0, 0, 0, 0
+	default:
+		return fmt.Errorf("%w: type must be buy, sell, dividend or split", ErrInvalidInvestment)
+	}
+	return nil
+}
+
+// sortTrades orders trades like findTrades, new trades last on their date
+func sortTrades(trades []Trade) {
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].Date.Before(trades[j].Date.Time) })
+}
+
+// AddTrade records a trade. Buys and sells are recorded as Trade
+// transactions of the account and dividends as income, splits move no cash.
+func AddTrade(accountID string, trade Trade, actor Actor) (Trade, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	account, err := getInvestmentAccount(accountID, uid)
+	if err != nil {
+		return Trade{}, err
+	}
+	if !canWrite(account.Role) {
+		return Trade{}, fmt.Errorf("%w: viewers can't change account %s", ErrForbidden, account.Name)
+	}
+	if err := account.checkOpen(); err != nil {
+		return Trade{}, err
+	}
+	if err := prepareTrade(&trade); err != nil {
+		return Trade{}, err
+	}
+	trade.AccountID = account.ID
+
+	exchangeRate, err := utils.GetExchangeRate(account.Currency)
+	if err != nil {
+		log.Printf("Warning: Exchange rate not found for currency '%s'. Transaction will be saved without conversion.", account.Currency)
+		exchangeRate = 0
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Trade{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Trades of an account are checked one at a time
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM accounts WHERE id = $1 FOR UPDATE", account.ID); err != nil {
+		return Trade{}, fmt.Errorf("failed to lock account: %v", err)
+	}
+	trades, err := findTrades(ctx, tx, account.ID)
+	if err != nil {
+		return Trade{}, err
+	}
+	trades = append(trades, trade)
+	sortTrades(trades)
+	if _, err := replayTrades(trades, account.Investment.CostMethod, time.Time{}); err != nil {
+		return Trade{}, err
+	}
+
+	if trade.Amount != 0 {
+		description := fmt.Sprintf("%s %g %s @ %g", strings.ToUpper(trade.Type[:1])+trade.Type[1:], trade.Quantity, trade.Symbol, trade.Price)
+		transactionType, mainCategory, subcategory := TransactionTypeTrade, MainCategorySavings, "Investments"
+		if trade.Type == TradeDividend {
+			description = "Dividend " + trade.Symbol
+			transactionType, mainCategory, subcategory = TransactionTypeIncome, TransactionTypeIncome, "Dividends"
+		}
+
+		err = tx.QueryRow(ctx, `
+			INSERT INTO transactions (description, amount, currency, amount_in_base_currency, exchange_rate, date,
+				main_category, subcategory, account_id, transaction_type, user_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id`,
+			description, trade.Amount, account.Currency, trade.Amount*exchangeRate, exchangeRate, trade.Date,
+			mainCategory, subcategory, account.ID, transactionType, uid,
+		).Scan(&trade.TransactionID)
+		if err != nil {
+			return Trade{}, fmt.Errorf("failed to insert trade transaction: %v", err)
+		}
+
+		if err := postEntries(ctx, tx, trade.TransactionID.String); err != nil {
+			return Trade{}, err
+		}
+		if err := auditTransactions(ctx, tx, actor, AuditCreate, nil, trade.TransactionID.String); err != nil {
+			return Trade{}, err
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO investment_trades (account_id, type, symbol, date, quantity, price, fees, ratio, amount, transaction_id, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at`,
+		trade.AccountID, trade.Type, trade.Symbol, trade.Date, trade.Quantity, trade.Price, trade.Fees, trade.Ratio,
+		trade.Amount, trade.TransactionID, uid,
+	).Scan(&trade.ID, &trade.CreatedAt)
+	if err != nil {
+		return Trade{}, fmt.Errorf("failed to insert trade: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Trade{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return trade, nil
+}
+
+// DeleteTrade removes a trade and its transaction, for good. A buy can't be
+// removed while later sales need its shares.
+func DeleteTrade(accountID string, tradeID string, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	account, err := getInvestmentAccount(accountID, uid)
+	if err != nil {
+		return err
+	}
+	if !canWrite(account.Role) {
+		return fmt.Errorf("%w: viewers can't change account %s", ErrForbidden, account.Name)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM accounts WHERE id = $1 FOR UPDATE", account.ID); err != nil {
+		return fmt.Errorf("failed to lock account: %v", err)
+	}
+	trades, err := findTrades(ctx, tx, account.ID)
+	if err != nil {
+		return err
+	}
+
+	var deleted *Trade
+	remaining := []Trade{}
+	for i := range trades {
+		if trades[i].ID == tradeID {
+			deleted = &trades[i]
+			continue
+		}
+		remaining = append(remaining, trades[i])
+	}
+	if deleted == nil {
+		return fmt.Errorf("%w: no trade found with ID %s", ErrNotFound, tradeID)
+	}
+	if deleted.TransactionID.Valid {
+		if err := checkNotReconciled(ctx, tx, deleted.TransactionID.String, false); err != nil {
+			return err
+		}
+	}
+	if _, err := replayTrades(remaining, account.Investment.CostMethod, time.Time{}); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM investment_trades WHERE id = $1", deleted.ID); err != nil {
+		return fmt.Errorf("failed to delete trade: %v", err)
+	}
+
+	if deleted.TransactionID.Valid {
+		before, err := transactionSnapshots(ctx, tx, []string{deleted.TransactionID.String})
+		if err != nil {
+			return err
+		}
+		// The transaction may have been edited since, its current postings are reversed
+		if err := unpostEntries(ctx, tx, deleted.TransactionID.String); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM transactions WHERE id = $1", deleted.TransactionID); err != nil {
+			return fmt.Errorf("failed to delete trade transaction: %v", err)
+		}
+		if err := auditTransactions(ctx, tx, actor, AuditPurge, before, deleted.TransactionID.String); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return nil
+}
+
+// startOfDay returns midnight of the day of t in loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// endOfDay returns the last instant of the day of t in loc
+func endOfDay(t time.Time, loc *time.Location) time.Time {
+	return startOfDay(t, loc).AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
+
+// measurePerformance computes the returns of the valuations taken together
+// between two times. By default the period starts the day before the first
+// activity, so that everything is a contribution, and ends now.
+func measurePerformance(valuations []*valuation, from time.Time, to time.Time, loc *time.Location) Performance {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to
+		for _, v := range valuations {
+			if len(v.trades) > 0 && v.trades[0].Date.Before(from) {
+				from = v.trades[0].Date.Time
+			}
+			if len(v.flows) > 0 && v.flows[0].date.Before(from) {
+				from = v.flows[0].date
+			}
+		}
+		from = endOfDay(from.AddDate(0, 0, -1), loc)
+	}
+
+	value := func(at time.Time) float64 {
+		total := 0.0
+		for _, v := range valuations {
+			total += v.value(at)
+		}
+		return total
+	}
+
+	// External flows are grouped by day, a sub-period ends before each of them
+	contributions := map[time.Time]float64{}
+	for _, v := range valuations {
+		for _, flow := range v.flows {
+			if flow.external && flow.date.After(from) && !flow.date.After(to) {
+				contributions[startOfDay(flow.date, loc)] += flow.amount * v.rate
+			}
+		}
+	}
+	days := make([]time.Time, 0, len(contributions))
+	for day := range contributions {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	performance := Performance{
+		From:       timeutils.NewTimestamp(from.In(loc)),
+		To:         timeutils.NewTimestamp(to.In(loc)),
+		StartValue: value(from),
+	}
+
+	cashFlows := []cashFlow{{date: from, amount: -performance.StartValue}}
+	growth, measured, previous := 1.0, false, performance.StartValue
+	for _, day := range days {
+		end := endOfDay(day, loc)
+		if end.After(to) {
+			end = to
+		}
+		current := value(end)
+		if previous > quantityEpsilon {
+			growth *= (current - contributions[day]) / previous
+			measured = true
+		}
+		previous = current
+		performance.NetContributions += contributions[day]
+		cashFlows = append(cashFlows, cashFlow{date: day, amount: -contributions[day]})
+	}
+
+	performance.EndValue = value(to)
+	if previous > quantityEpsilon {
+		growth *= performance.EndValue / previous
+		measured = true
+	}
+	cashFlows = append(cashFlows, cashFlow{date: to, amount: performance.EndValue})
+
+	if measured {
+		performance.TimeWeightedReturn = null.FloatFrom(roundReturn(growth - 1))
+	}
+	if rate, ok := xirr(cashFlows); ok {
+		performance.MoneyWeightedReturn = null.FloatFrom(roundReturn(rate))
+	}
+
+	performance.Gain = roundCents(performance.EndValue - performance.StartValue - performance.NetContributions)
+	performance.StartValue = roundCents(performance.StartValue)
+	performance.EndValue = roundCents(performance.EndValue)
+	performance.NetContributions = roundCents(performance.NetContributions)
+	return performance
+}
+
+func roundReturn(r float64) float64 {
+	return math.Round(r*1e6) / 1e6
+}
+
+// cashFlow is money paid into (negative) or out of (positive) an investment
+type cashFlow struct {
+	date   time.Time
+	amount float64
+}
+
+// xirr finds the annual rate at which the cash flows are worth nothing
+// today, by Newton's method and by bisection when it doesn't converge
+func xirr(flows []cashFlow) (float64, bool) {
+	var hasIn, hasOut bool
+	for _, flow := range flows {
+		hasIn = hasIn || flow.amount < -quantityEpsilon
+		hasOut = hasOut || flow.amount > quantityEpsilon
+	}
+	if !hasIn || !hasOut {
+		return 0, false
+	}
+
+	first := flows[0].date
+	npv := func(rate float64) (float64, float64) {
+		value, derivative := 0.0, 0.0
+		for _, flow := range flows {
+			years := flow.date.Sub(first).Hours() / 24 / 365
+			discount := math.Pow(1+rate, years)
+			value += flow.amount / discount
+			derivative -= years * flow.amount / (discount * (1 + rate))
+		}
+		return value, derivative
+	}
+
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		value, derivative := npv(rate)
+		if math.Abs(value) < 1e-7 {
+			return rate, true
+		}
+		if derivative == 0 {
+			break
+		}
+		next := rate - value/derivative
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-rate) < 1e-10 {
+			return next, true
+		}
+		rate = next
+	}
+
+	low, high := -0.9999, 100.0
+	lowValue, _ := npv(low)
+	highValue, _ := npv(high)
+	if lowValue*highValue > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		value, _ := npv(mid)
+		if math.Abs(value) < 1e-7 || high-low < 1e-10 {
+			return mid, true
+		}
+		if value*lowValue < 0 {
+			high = mid
+		} else {
+			low, lowValue = mid, value
+		}
+	}
+	return (low + high) / 2, true
+}
+
+// GetPerformance returns the returns of an investment account in its
+// currency between two times, zero for the defaults
+func GetPerformance(accountID string, from time.Time, to time.Time, uid string) (Performance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := getInvestmentAccount(accountID, uid)
+	if err != nil {
+		return Performance{}, err
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return Performance{}, fmt.Errorf("%w: from must be before to", ErrInvalidInvestment)
+	}
+	loc, err := GetUserLocation(uid)
+	if err != nil {
+		return Performance{}, err
+	}
+
+	v, err := loadValuation(ctx, account, uid)
+	if err != nil {
+		return Performance{}, err
+	}
+
+	performance := measurePerformance([]*valuation{v}, from, to, loc)
+	performance.AccountID = account.ID
+	performance.Name = account.Name
+	performance.Currency = account.Currency
+	return performance, nil
+}
+
+// GetPortfolioPerformance returns the returns of every investment account
+// visible to the user and of all of them in the base currency
+func GetPortfolioPerformance(from time.Time, to time.Time, uid string) (PortfolioPerformance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return PortfolioPerformance{}, fmt.Errorf("%w: from must be before to", ErrInvalidInvestment)
+	}
+	loc, err := GetUserLocation(uid)
+	if err != nil {
+		return PortfolioPerformance{}, err
+	}
+	accounts, err := GetAccounts("", false, uid)
+	if err != nil {
+		return PortfolioPerformance{}, err
+	}
+
+	portfolio := PortfolioPerformance{Accounts: []Performance{}}
+	var valuations []*valuation
+	for _, account := range accounts {
+		if !account.IsInvestment() || account.Investment == nil {
+			continue
+		}
+		v, err := loadValuation(ctx, account, uid)
+		if err != nil {
+			return PortfolioPerformance{}, err
+		}
+
+		performance := measurePerformance([]*valuation{v}, from, to, loc)
+		performance.AccountID = account.ID
+		performance.Name = account.Name
+		performance.Currency = account.Currency
+		portfolio.Accounts = append(portfolio.Accounts, performance)
+
+		v.rate = exchangeRateOf(account.Currency)
+		valuations = append(valuations, v)
+	}
+
+	portfolio.Total = measurePerformance(valuations, from, to, loc)
+	return portfolio, nil
+}
+
+// GetPrices lists the stored prices of a symbol, latest first
+func GetPrices(symbol string, uid string) ([]SecurityPrice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT symbol, date, price, currency, source
+		FROM security_prices
+		WHERE user_id = $1 AND symbol = $2
+		ORDER BY date DESC`, uid, prices.NormalizeSymbol(symbol))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve prices: %v", err)
+	}
+	defer rows.Close()
+
+	history := []SecurityPrice{}
+	for rows.Next() {
+		var price SecurityPrice
+		if err := rows.Scan(&price.Symbol, &price.Date, &price.Price, &price.Currency, &price.Source); err != nil {
+			return nil, fmt.Errorf("failed to scan price: %v", err)
+		}
+		history = append(history, price)
+	}
+	return history, rows.Err()
+}
+
+// ImportPrices stores prices for the user, replacing those of the same days
+func ImportPrices(quotes []prices.Quote, source string, uid string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return importPrices(ctx, quotes, source, uid)
+}
+
+func importPrices(ctx context.Context, quotes []prices.Quote, source string, uid string) (int, error) {
+	if len(quotes) == 0 {
+		return 0, nil
+	}
+
+	// Later rows of a file win over earlier ones for the same day
+	latest := map[string]int{}
+	for i, quote := range quotes {
+		if quote.Symbol == "" {
+			return 0, fmt.Errorf("%w: prices need a symbol", ErrInvalidInvestment)
+		}
+		latest[quote.Symbol+quote.Date.Format("2006-01-02")] = i
+	}
+
+	var symbols, currencies []string
+	var dates []time.Time
+	var values []float64
+	for i, quote := range quotes {
+		if latest[quote.Symbol+quote.Date.Format("2006-01-02")] != i {
+			continue
+		}
+		symbols = append(symbols, quote.Symbol)
+		dates = append(dates, quote.Date)
+		values = append(values, quote.Price)
+		currencies = append(currencies, quote.Currency)
+	}
+
+	result, err := db.Exec(ctx, `
+		INSERT INTO security_prices (user_id, symbol, date, price, currency, source)
+		SELECT $1, q.symbol, q.date, q.price, NULLIF(q.currency, ''), $6
+		FROM unnest($2::text[], $3::date[], $4::float8[], $5::text[]) AS q(symbol, date, price, currency)
+		ON CONFLICT (user_id, symbol, date) DO UPDATE
+		SET price = EXCLUDED.price, currency = EXCLUDED.currency, source = EXCLUDED.source`,
+		uid, symbols, dates, values, currencies, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store prices: %v", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// RefreshPrices fetches from the price provider the prices missing since
+// the last stored price of every symbol traded in the user's accounts
+func RefreshPrices(uid string) ([]PriceRefresh, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if _, ok := priceProvider.(prices.ManualProvider); ok {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInvestment, prices.ErrNoProvider)
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT it.symbol, MIN(it.date), (SELECT MAX(p.date) FROM security_prices p WHERE p.user_id = $1 AND p.symbol = it.symbol)
+		FROM investment_trades it
+		WHERE it.account_id IN (`+visibleAccounts("$1")+`)
+		GROUP BY it.symbol
+		ORDER BY it.symbol`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve symbols: %v", err)
+	}
+	type pending struct {
+		symbol    string
+		firstDate time.Time
+		lastPrice null.Time
+	}
+	var symbols []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.symbol, &p.firstDate, &p.lastPrice); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan symbol: %v", err)
+		}
+		symbols = append(symbols, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	refreshes := []PriceRefresh{}
+	today := time.Now().UTC()
+	for _, p := range symbols {
+		refresh := PriceRefresh{Symbol: p.symbol}
+		from := p.firstDate
+		if p.lastPrice.Valid {
+			from = p.lastPrice.Time.AddDate(0, 0, 1)
+		}
+
+		if !from.After(today) {
+			quotes, err := priceProvider.Quotes(ctx, p.symbol, from, today)
+			if err == nil {
+				for i := range quotes {
+					quotes[i].Symbol = p.symbol
+				}
+				refresh.Imported, err = importPrices(ctx, quotes, priceProvider.Name(), uid)
+			}
+			if err != nil {
+				refresh.Error = err.Error()
+			}
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	return refreshes, nil
+}
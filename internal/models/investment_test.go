@@ -0,0 +1,335 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"guilliman/internal/utils/timeutils"
+
+	"github.com/guregu/null/v5"
+)
+
+func trade(tradeType string, symbol string, month time.Month, day int, quantity float64, price float64, fees float64) Trade {
+	return Trade{
+		Type:     tradeType,
+		Symbol:   symbol,
+		Date:     timeutils.NewTimestamp(utcDay(2025, month, day)),
+		Quantity: quantity,
+		Price:    price,
+		Fees:     fees,
+	}
+}
+
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestReplayTrades(t *testing.T) {
+	split := Trade{Type: TradeSplit, Symbol: "ACME", Date: timeutils.NewTimestamp(utcDay(2025, time.March, 1)), Ratio: 2}
+	dividend := Trade{Type: TradeDividend, Symbol: "ACME", Date: timeutils.NewTimestamp(utcDay(2025, time.March, 1)), Amount: 25}
+
+	type want struct {
+		quantity  float64
+		cost      float64
+		lots      int
+		realized  float64
+		dividends float64
+		lastPrice float64
+	}
+	tests := []struct {
+		name    string
+		trades  []Trade
+		method  string
+		until   time.Time
+		want    map[string]want
+		wantErr error
+	}{
+		{
+			name: "fifo sells the oldest lots first",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 5),
+				trade(TradeBuy, "ACME", time.February, 1, 10, 120, 0),
+				trade(TradeSell, "ACME", time.March, 1, 15, 130, 10),
+			},
+			method: CostFIFO,
+			want:   map[string]want{"ACME": {quantity: 5, cost: 600, lots: 1, realized: 335, lastPrice: 130}},
+		},
+		{
+			name: "average cost keeps a single lot",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 5),
+				trade(TradeBuy, "ACME", time.February, 1, 10, 120, 0),
+				trade(TradeSell, "ACME", time.March, 1, 15, 130, 10),
+			},
+			method: CostAverage,
+			want:   map[string]want{"ACME": {quantity: 5, cost: 551.25, lots: 1, realized: 286.25, lastPrice: 130}},
+		},
+		{
+			name: "selling everything closes the position",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 0),
+				trade(TradeSell, "ACME", time.February, 1, 10, 90, 0),
+			},
+			method: CostFIFO,
+			want:   map[string]want{"ACME": {realized: -100, lastPrice: 90}},
+		},
+		{
+			name: "splits multiply the shares and keep the cost",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 0),
+				split,
+			},
+			method: CostFIFO,
+			want:   map[string]want{"ACME": {quantity: 20, cost: 1000, lots: 1, lastPrice: 50}},
+		},
+		{
+			name: "dividends",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 0),
+				dividend,
+			},
+			method: CostFIFO,
+			want:   map[string]want{"ACME": {quantity: 10, cost: 1000, lots: 1, dividends: 25, lastPrice: 100}},
+		},
+		{
+			name: "symbols are kept apart",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 0),
+				trade(TradeBuy, "INIT", time.January, 2, 5, 40, 1),
+			},
+			method: CostFIFO,
+			want: map[string]want{
+				"ACME": {quantity: 10, cost: 1000, lots: 1, lastPrice: 100},
+				"INIT": {quantity: 5, cost: 201, lots: 1, lastPrice: 40},
+			},
+		},
+		{
+			name: "trades after until are left out",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 0),
+				trade(TradeBuy, "ACME", time.February, 1, 10, 120, 0),
+			},
+			method: CostFIFO,
+			until:  utcDay(2025, time.January, 15),
+			want:   map[string]want{"ACME": {quantity: 10, cost: 1000, lots: 1, lastPrice: 100}},
+		},
+		{
+			name: "selling more than held",
+			trades: []Trade{
+				trade(TradeBuy, "ACME", time.January, 1, 10, 100, 0),
+				trade(TradeSell, "ACME", time.February, 1, 11, 100, 0),
+			},
+			method:  CostFIFO,
+			wantErr: ErrInvalidInvestment,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positions, err := replayTrades(tt.trades, tt.method, tt.until)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(positions) != len(tt.want) {
+				t.Fatalf("got %d positions, want %d", len(positions), len(tt.want))
+			}
+
+			for symbol, want := range tt.want {
+				p := positions[symbol]
+				if p == nil {
+					t.Fatalf("no position in %s", symbol)
+				}
+				got := struct{ quantity, cost, realized, dividends, lastPrice float64 }{
+					p.quantity(), p.cost(), p.realized, p.dividends, p.lastPrice}
+				if !closeTo(got.quantity, want.quantity) || !closeTo(got.cost, want.cost) || !closeTo(got.realized, want.realized) ||
+					!closeTo(got.dividends, want.dividends) || !closeTo(got.lastPrice, want.lastPrice) || len(p.lots) != want.lots {
+					t.Errorf("%s = %+v with %d lots, want %+v", symbol, got, len(p.lots), want)
+				}
+			}
+		})
+	}
+}
+
+func TestXIRR(t *testing.T) {
+	start := utcDay(2025, time.January, 1)
+	tests := []struct {
+		name   string
+		flows  []cashFlow
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "ten percent in a year",
+			flows:  []cashFlow{{start, -1000}, {start.AddDate(0, 0, 365), 1100}},
+			want:   0.1,
+			wantOK: true,
+		},
+		{
+			name:   "ten percent a year over two years",
+			flows:  []cashFlow{{start, -1000}, {start.AddDate(0, 0, 730), 1210}},
+			want:   0.1,
+			wantOK: true,
+		},
+		{
+			name:   "nothing gained",
+			flows:  []cashFlow{{start, -1000}, {start.AddDate(0, 0, 365), 1000}},
+			want:   0,
+			wantOK: true,
+		},
+		{
+			name:   "half lost",
+			flows:  []cashFlow{{start, -1000}, {start.AddDate(0, 0, 365), 500}},
+			want:   -0.5,
+			wantOK: true,
+		},
+		{
+			name: "several deposits",
+			flows: []cashFlow{
+				{start, -1000},
+				{start.AddDate(0, 0, 365), -1000},
+				{start.AddDate(0, 0, 730), 2310},
+			},
+			want:   0.1,
+			wantOK: true,
+		},
+		{
+			name:  "deposits only",
+			flows: []cashFlow{{start, -1000}, {start.AddDate(0, 0, 365), -100}},
+		},
+		{
+			name:  "nothing",
+			flows: []cashFlow{{start, 0}, {start.AddDate(0, 0, 365), 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := xirr(tt.flows)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("xirr = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeasurePerformance(t *testing.T) {
+	account := func(balance float64) Account {
+		return Account{Balance: balance, Investment: &InvestmentTerms{CostMethod: CostFIFO}}
+	}
+	end := endOfDay(utcDay(2025, time.December, 31), time.UTC)
+
+	tests := []struct {
+		name       string
+		valuations []*valuation
+		from       time.Time
+		want       Performance
+	}{
+		{
+			name: "cash earning interest",
+			valuations: []*valuation{{
+				account: account(1100),
+				flows: []accountFlow{
+					{date: utcDay(2025, time.January, 1), amount: 1000, external: true},
+					{date: utcDay(2025, time.July, 1), amount: 100},
+				},
+				rate: 1,
+			}},
+			want: Performance{EndValue: 1100, NetContributions: 1000, Gain: 100, TimeWeightedReturn: null.FloatFrom(0.1), MoneyWeightedReturn: null.FloatFrom(0.1)},
+		},
+		{
+			name: "shares valued at their last price",
+			valuations: []*valuation{{
+				account: account(0),
+				trades:  []Trade{trade(TradeBuy, "ACME", time.January, 2, 10, 100, 0)},
+				prices:  priceHistory{"ACME": {{date: utcDay(2025, time.December, 31), price: 120}}},
+				flows: []accountFlow{
+					{date: utcDay(2025, time.January, 1), amount: 1000, external: true},
+					{date: utcDay(2025, time.January, 2), amount: -1000},
+				},
+				rate: 1,
+			}},
+			want: Performance{EndValue: 1200, NetContributions: 1000, Gain: 200, TimeWeightedReturn: null.FloatFrom(0.2), MoneyWeightedReturn: null.FloatFrom(0.2)},
+		},
+		{
+			name: "a deposit after a loss",
+			valuations: []*valuation{{
+				account: account(0),
+				trades: []Trade{
+					trade(TradeBuy, "ACME", time.January, 1, 10, 100, 0),
+					trade(TradeBuy, "ACME", time.July, 1, 20, 50, 0),
+				},
+				prices: priceHistory{"ACME": {
+					{date: utcDay(2025, time.June, 30), price: 50},
+					{date: utcDay(2025, time.December, 31), price: 100},
+				}},
+				flows: []accountFlow{
+					{date: utcDay(2025, time.January, 1), amount: 1000, external: true},
+					{date: utcDay(2025, time.January, 1), amount: -1000},
+					{date: utcDay(2025, time.July, 1), amount: 1000, external: true},
+					{date: utcDay(2025, time.July, 1), amount: -1000},
+				},
+				rate: 1,
+			}},
+			// Halved then doubled, but most of the money was in for the doubling
+			want: Performance{EndValue: 3000, NetContributions: 2000, Gain: 1000, TimeWeightedReturn: null.FloatFrom(0)},
+		},
+		{
+			name: "converted to the base currency",
+			valuations: []*valuation{{
+				account: account(1100),
+				flows: []accountFlow{
+					{date: utcDay(2025, time.January, 1), amount: 1000, external: true},
+					{date: utcDay(2025, time.July, 1), amount: 100},
+				},
+				rate: 10,
+			}},
+			want: Performance{EndValue: 11000, NetContributions: 10000, Gain: 1000, TimeWeightedReturn: null.FloatFrom(0.1), MoneyWeightedReturn: null.FloatFrom(0.1)},
+		},
+		{
+			name: "from a later start",
+			valuations: []*valuation{{
+				account: account(1100),
+				flows: []accountFlow{
+					{date: utcDay(2025, time.January, 1), amount: 1000, external: true},
+					{date: utcDay(2025, time.July, 1), amount: 100},
+				},
+				rate: 1,
+			}},
+			from: endOfDay(utcDay(2025, time.June, 30), time.UTC),
+			want: Performance{StartValue: 1000, EndValue: 1100, Gain: 100, TimeWeightedReturn: null.FloatFrom(0.1)},
+		},
+		{
+			name: "nothing to measure",
+			want: Performance{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := measurePerformance(tt.valuations, tt.from, end, time.UTC)
+			if got.StartValue != tt.want.StartValue || got.EndValue != tt.want.EndValue ||
+				got.NetContributions != tt.want.NetContributions || got.Gain != tt.want.Gain {
+				t.Errorf("values = %v %v %v %v, want %v %v %v %v",
+					got.StartValue, got.EndValue, got.NetContributions, got.Gain,
+					tt.want.StartValue, tt.want.EndValue, tt.want.NetContributions, tt.want.Gain)
+			}
+			if got.TimeWeightedReturn != tt.want.TimeWeightedReturn {
+				t.Errorf("time-weighted return = %v, want %v", got.TimeWeightedReturn, tt.want.TimeWeightedReturn)
+			}
+			if tt.want.MoneyWeightedReturn.Valid && !closeTo(got.MoneyWeightedReturn.Float64, tt.want.MoneyWeightedReturn.Float64) {
+				t.Errorf("money-weighted return = %v, want %v", got.MoneyWeightedReturn, tt.want.MoneyWeightedReturn)
+			}
+		})
+	}
+}
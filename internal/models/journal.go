@@ -0,0 +1,194 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// The journal is the double-entry form of the transactions. Every
+// transaction is an entry whose postings sum to zero: what it moves on the
+// user's accounts is balanced by postings on nominal accounts, the
+// categories for income and spending. Void and deleted transactions have no
+// postings.
+//
+// The balance of an account is the sum of its postings. accounts.balance
+// caches it and is only changed by postEntries and unpostEntries, which
+// every change to a transaction goes through.
+
+// Posting is a line of a journal entry
+type Posting struct {
+	ID            string      `json:"id"`
+	TransactionID string      `json:"transaction_id"`
+	AccountID     null.String `json:"account_id"`  // Account of the user, null on nominal accounts
+	CategoryID    null.String `json:"category_id"` // Category of a nominal posting
+	Nominal       string      `json:"nominal"`     // Nominal account, named after the transaction type or Fees, empty on accounts
+	Amount        float64     `json:"amount"`
+}
+
+// NominalFees is the nominal account the fees of transfers are posted to
+const NominalFees = "Fees"
+
+// entryPostings selects the postings of the transactions aliased as t
+// matching a condition, as (transaction_id, account_id, category_id,
+// nominal, amount):
+//
+//   - transfers and savings take their amount and fees from the account,
+//     the amount goes to the related account, or to a nominal account when
+//     there is none, and the fees to the Fees nominal account
+//   - other transactions add their amount to the account, balanced by the
+//     category of the transaction or of each of its split lines
+//
+// Migrations copy the SQL they need instead, so that changing the postings
+// never changes a migration already applied.
+func entryPostings(condition string) string {
+	where := " WHERE t.status <> 'void' AND t.account_id IS NOT NULL AND (" + condition + ")"
+	transfer := " AND t.transaction_type IN ('Transfer', 'Savings')"
+	other := " AND t.transaction_type NOT IN ('Transfer', 'Savings')"
+	split := "EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id)"
+
+	return `SELECT t.id, t.account_id, NULL::uuid, '',
+			CASE WHEN t.transaction_type IN ('Transfer', 'Savings') THEN -(t.amount + COALESCE(t.fees, 0)) ELSE t.amount END
+		FROM transactions t` + where + `
+		UNION ALL
+		SELECT t.id, t.related_account_id, NULL::uuid, '', t.amount
+		FROM transactions t` + where + transfer + ` AND t.related_account_id IS NOT NULL
+		UNION ALL
+		SELECT t.id, NULL::uuid, t.category_id, t.transaction_type, t.amount
+		FROM transactions t` + where + transfer + ` AND t.related_account_id IS NULL
+		UNION ALL
+		SELECT t.id, NULL::uuid, NULL::uuid, '` + NominalFees + `', t.fees
+		FROM transactions t` + where + transfer + ` AND COALESCE(t.fees, 0) <> 0
+		UNION ALL
+		SELECT t.id, NULL::uuid, s.category_id, t.transaction_type, -s.amount
+		FROM transactions t JOIN transaction_splits s ON s.transaction_id = t.id` + where + other + `
+		UNION ALL
+		SELECT t.id, NULL::uuid, t.category_id, t.transaction_type, -t.amount
+		FROM transactions t` + where + other + ` AND NOT ` + split
+}
+
+// postEntries replaces the postings of transactions with the ones of their
+// current state and moves the balances of their accounts accordingly. The
+// transactions in the trash are only unposted.
+func postEntries(ctx context.Context, tx pgx.Tx, ids ...string) error {
+	if err := unpostEntries(ctx, tx, ids...); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO postings (transaction_id, account_id, category_id, nominal, amount)
+		`+entryPostings("t.id::text = ANY($1) AND t.deleted_at IS NULL"), ids)
+	if err != nil {
+		return fmt.Errorf("failed to post transactions: %v", err)
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE accounts a SET balance = a.balance + p.amount
+		FROM (SELECT account_id, SUM(amount) AS amount FROM postings
+		      WHERE transaction_id::text = ANY($1) AND account_id IS NOT NULL
+		      GROUP BY account_id) p
+		WHERE a.id = p.account_id`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to update account balances: %v", err)
+	}
+	return nil
+}
+
+// unpostEntries removes the postings of transactions and takes them out of
+// the balances of their accounts
+func unpostEntries(ctx context.Context, tx pgx.Tx, ids ...string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE accounts a SET balance = a.balance - p.amount
+		FROM (SELECT account_id, SUM(amount) AS amount FROM postings
+		      WHERE transaction_id::text = ANY($1) AND account_id IS NOT NULL
+		      GROUP BY account_id) p
+		WHERE a.id = p.account_id`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to update account balances: %v", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM postings WHERE transaction_id::text = ANY($1)", ids); err != nil {
+		return fmt.Errorf("failed to remove postings: %v", err)
+	}
+	return nil
+}
+
+// transactionIDs selects the ids of the transactions matching a condition,
+// for postEntries and unpostEntries
+func transactionIDs(ctx context.Context, tx pgx.Tx, condition string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(ctx, "SELECT id::text FROM transactions WHERE "+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve transactions: %v", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPostings returns the journal entry of a transaction visible to the user
+func GetPostings(transactionID string, uid string) ([]Posting, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM transactions t WHERE t.id::text = $1 AND "+visibleTransaction("$2")+")",
+		transactionID, uid).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve transaction: %v", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: transaction %s", ErrNotFound, transactionID)
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, transaction_id, account_id::text, category_id::text, nominal, amount
+		FROM postings
+		WHERE transaction_id::text = $1
+		ORDER BY account_id IS NULL, amount`, transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve postings: %v", err)
+	}
+	defer rows.Close()
+
+	postings := []Posting{}
+	for rows.Next() {
+		var p Posting
+		if err := rows.Scan(&p.ID, &p.TransactionID, &p.AccountID, &p.CategoryID, &p.Nominal, &p.Amount); err != nil {
+			return nil, fmt.Errorf("failed to scan posting: %v", err)
+		}
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+// unbalancedEntries lists the transactions whose postings don't sum to zero
+func unbalancedEntries(ctx context.Context, q pgxRowsQuerier) (map[string]float64, error) {
+	rows, err := q.Query(ctx, `
+		SELECT transaction_id::text, SUM(amount) FROM postings
+		GROUP BY transaction_id
+		HAVING ABS(SUM(amount)) >= $1`, balanceTolerance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check journal entries: %v", err)
+	}
+	defer rows.Close()
+
+	unbalanced := map[string]float64{}
+	for rows.Next() {
+		var id string
+		var sum float64
+		if err := rows.Scan(&id, &sum); err != nil {
+			return nil, fmt.Errorf("failed to scan journal entry: %v", err)
+		}
+		unbalanced[id] = sum
+	}
+	return unbalanced, rows.Err()
+}
@@ -0,0 +1,610 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const AccountTypeLoan = "Loan"
+
+const (
+	LoanMonthly  = "monthly"
+	LoanBiweekly = "biweekly"
+	LoanWeekly   = "weekly"
+)
+
+const (
+	RateFixed    = "fixed"
+	RateVariable = "variable"
+)
+
+// maxLoanPayments bounds schedules, 50 years of weekly payments
+const maxLoanPayments = 52 * 50
+
+// ErrInvalidLoan wraps errors caused by loan requests sent by the client
+var ErrInvalidLoan = errors.New("invalid loan")
+
+// LoanTerms are the settings of a loan account. The balance of a loan is
+// negative, what remains to be repaid. Interest is paid with every payment
+// at the rate in effect on its date.
+type LoanTerms struct {
+	Principal          float64             `json:"principal"`
+	StartDate          timeutils.Timestamp `json:"start_date"` // Payments fall one period after it, then every period
+	Term               int                 `json:"term"`       // Number of payments
+	Frequency          string              `json:"frequency"`  // "monthly", "biweekly" or "weekly"
+	RateType           string              `json:"rate_type"`  // "fixed" or "variable"
+	Rates              []LoanRate          `json:"rates"`
+	InterestCategoryID null.String         `json:"interest_category_id"` // Category of the interest expenses
+
+	dueDates timeutils.BusinessDayRule // Moves payments off weekends and holidays, see GetDueDateRule
+}
+
+// LoanRate is an annual interest rate, in percent, applying from its
+// effective date
+type LoanRate struct {
+	EffectiveDate timeutils.Timestamp `json:"effective_date"`
+	AnnualRate    float64             `json:"annual_rate"`
+}
+
+// AmortizationRow is one payment of a schedule
+type AmortizationRow struct {
+	Number     int                 `json:"number"`
+	Date       timeutils.Timestamp `json:"date"`
+	AnnualRate float64             `json:"annual_rate"`
+	Payment    float64             `json:"payment"`
+	Interest   float64             `json:"interest"`
+	Principal  float64             `json:"principal"` // Extra included
+	Extra      float64             `json:"extra"`
+	Balance    float64             `json:"balance"` // Remaining after the payment
+}
+
+// LoanSchedule is the amortization of a loan, from its start or projected
+// from its current balance. With extra payments it compares with the
+// schedule without them.
+type LoanSchedule struct {
+	AccountID        string              `json:"account_id"`
+	RemainingBalance float64             `json:"remaining_balance"`
+	Payment          float64             `json:"payment"` // Scheduled payment, without extra
+	Extra            float64             `json:"extra"`
+	PaymentsLeft     int                 `json:"payments_left"`
+	PayoffDate       timeutils.Timestamp `json:"payoff_date"`
+	TotalInterest    float64             `json:"total_interest"`
+	InterestSaved    float64             `json:"interest_saved"`
+	PaymentsSaved    int                 `json:"payments_saved"`
+	Rows             []AmortizationRow   `json:"rows"`
+}
+
+// LoanPayment is a payment recorded on a loan, split into an interest
+// expense and a principal transfer from the paying account
+type LoanPayment struct {
+	ID                     string              `json:"id"`
+	AccountID              string              `json:"account_id"`
+	FromAccountID          string              `json:"from_account_id"`
+	Date                   timeutils.Timestamp `json:"date"`
+	Amount                 float64             `json:"amount"`
+	Interest               float64             `json:"interest"`
+	Principal              float64             `json:"principal"`
+	RemainingBalance       float64             `json:"remaining_balance"`
+	InterestTransactionID  null.String         `json:"interest_transaction_id"`
+	PrincipalTransactionID null.String         `json:"principal_transaction_id"`
+}
+
+// loanColumn reads the loan terms of the account aliased as a, null for
+// other accounts
+const loanColumn = `(SELECT json_build_object(
+		'principal', l.principal, 'start_date', l.start_date, 'term', l.term, 'frequency', l.frequency,
+		'rate_type', l.rate_type, 'interest_category_id', l.interest_category_id,
+		'rates', (SELECT json_agg(json_build_object('effective_date', r.effective_date, 'annual_rate', r.annual_rate)
+		          ORDER BY r.effective_date)
+		          FROM loan_rates r WHERE r.account_id = l.account_id))
+	FROM loans l WHERE l.account_id = a.id)`
+
+// IsLoan tells whether the account is a loan
+func (a Account) IsLoan() bool {
+	return strings.EqualFold(strings.TrimSpace(a.Type), AccountTypeLoan)
+}
+
+// prepareLoanTerms validates the terms of a loan account and drops them
+// from other accounts. A new loan starts with its principal owed.
+func prepareLoanTerms(account *Account) error {
+	if !account.IsLoan() {
+		account.Loan = nil
+		return nil
+	}
+
+	account.Type = AccountTypeLoan
+	loan := account.Loan
+	if loan == nil {
+		return fmt.Errorf("%w: loans need a principal, a start date, a term and a rate", ErrInvalidLoan)
+	}
+	if loan.Principal <= 0 {
+		return fmt.Errorf("%w: the principal must be positive", ErrInvalidLoan)
+	}
+	if loan.StartDate.IsZero() {
+		return fmt.Errorf("%w: start_date is required", ErrInvalidLoan)
+	}
+	if loan.Term < 1 || loan.Term > maxLoanPayments {
+		return fmt.Errorf("%w: term must be between 1 and %d payments", ErrInvalidLoan, maxLoanPayments)
+	}
+	if loan.Frequency == "" {
+		loan.Frequency = LoanMonthly
+	}
+	if periodsPerYear(loan.Frequency) == 0 {
+		return fmt.Errorf("%w: frequency must be monthly, biweekly or weekly", ErrInvalidLoan)
+	}
+	if loan.RateType == "" {
+		loan.RateType = RateFixed
+	}
+	if loan.RateType != RateFixed && loan.RateType != RateVariable {
+		return fmt.Errorf("%w: rate_type must be fixed or variable", ErrInvalidLoan)
+	}
+	if len(loan.Rates) == 0 {
+		return fmt.Errorf("%w: at least one rate is required", ErrInvalidLoan)
+	}
+	if loan.RateType == RateFixed && len(loan.Rates) > 1 {
+		return fmt.Errorf("%w: fixed rate loans have a single rate", ErrInvalidLoan)
+	}
+	for i := range loan.Rates {
+		if loan.Rates[i].AnnualRate < 0 || loan.Rates[i].AnnualRate > 100 {
+			return fmt.Errorf("%w: annual_rate is a percentage between 0 and 100", ErrInvalidLoan)
+		}
+		if loan.Rates[i].EffectiveDate.IsZero() {
+			loan.Rates[i].EffectiveDate = loan.StartDate
+		}
+	}
+	sort.Slice(loan.Rates, func(i, j int) bool {
+		return loan.Rates[i].EffectiveDate.Before(loan.Rates[j].EffectiveDate.Time)
+	})
+	if loan.InterestCategoryID.String == "" {
+		loan.InterestCategoryID = null.String{}
+	}
+
+	if account.ID == "" && account.Balance == 0 {
+		account.Balance = -loan.Principal
+	}
+	return nil
+}
+
+// saveLoanTerms replaces the terms of a loan account
+func saveLoanTerms(ctx context.Context, tx pgx.Tx, accountID string, loan *LoanTerms) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM loans WHERE account_id = $1", accountID); err != nil {
+		return fmt.Errorf("failed to replace loan: %v", err)
+	}
+	if loan == nil {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO loans (account_id, principal, start_date, term, frequency, rate_type, interest_category_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		accountID, loan.Principal, loan.StartDate, loan.Term, loan.Frequency, loan.RateType, loan.InterestCategoryID)
+	if err != nil {
+		return fmt.Errorf("failed to insert loan: %v", err)
+	}
+
+	for _, rate := range loan.Rates {
+		_, err := tx.Exec(ctx, "INSERT INTO loan_rates (account_id, effective_date, annual_rate) VALUES ($1, $2, $3)",
+			accountID, rate.EffectiveDate, rate.AnnualRate)
+		if err != nil {
+			return fmt.Errorf("failed to insert loan rate: %v", err)
+		}
+	}
+	return nil
+}
+
+func periodsPerYear(frequency string) float64 {
+	switch frequency {
+	case LoanMonthly:
+		return 12
+	case LoanBiweekly:
+		return 26
+	case LoanWeekly:
+		return 52
+	}
+	return 0
+}
+
+// paymentDate returns the date of the n-th payment, monthly payments keep
+// the day of the start date or the last day of shorter months. The date is
+// then moved off weekends and holidays by the user's due date adjustment.
+func (l LoanTerms) paymentDate(n int) time.Time {
+	start := l.StartDate.Time
+	switch l.Frequency {
+	case LoanBiweekly:
+		return l.dueDates.Adjust(start.AddDate(0, 0, 14*n))
+	case LoanWeekly:
+		return l.dueDates.Adjust(start.AddDate(0, 0, 7*n))
+	}
+	day := dayOf(start.Year(), start.Month()+time.Month(n), start.Day(), start.Location())
+	return l.dueDates.Adjust(time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location()))
+}
+
+// rateAt returns the annual rate in effect on a date, the first rate
+// before any effective date
+func (l LoanTerms) rateAt(date time.Time) float64 {
+	rate := l.Rates[0].AnnualRate
+	for _, r := range l.Rates {
+		if r.EffectiveDate.After(date) {
+			break
+		}
+		rate = r.AnnualRate
+	}
+	return rate
+}
+
+// periodicRate converts an annual percentage to the rate of one period
+func (l LoanTerms) periodicRate(annual float64) float64 {
+	return annual / 100 / periodsPerYear(l.Frequency)
+}
+
+// annuity is the constant payment repaying a balance in n periods
+func annuity(balance float64, rate float64, n int) float64 {
+	if n < 1 {
+		return balance
+	}
+	if rate == 0 {
+		return roundCents(balance / float64(n))
+	}
+	return roundCents(balance * rate / (1 - math.Pow(1+rate, -float64(n))))
+}
+
+// amortize schedules the payments repaying a balance from the given payment
+// number on. The payment is recomputed over the remaining term when the
+// rate changes, extra payments shorten the loan.
+func (l LoanTerms) amortize(balance float64, first int, extra float64) []AmortizationRow {
+	rows := []AmortizationRow{}
+	payment, annual := 0.0, -1.0
+
+	for n := first; balance > 0 && n < first+maxLoanPayments; n++ {
+		date := l.paymentDate(n)
+		rate := l.rateAt(date)
+		if rate != annual || payment == 0 {
+			annual = rate
+			payment = annuity(balance, l.periodicRate(rate), l.Term-n+1)
+		}
+
+		row := AmortizationRow{
+			Number:     n,
+			Date:       timeutils.NewTimestamp(date),
+			AnnualRate: rate,
+			Interest:   roundCents(balance * l.periodicRate(rate)),
+			Extra:      extra,
+		}
+		row.Payment = payment
+		row.Principal = roundCents(payment - row.Interest + extra)
+		// The last payment repays what is left, as does any payment past the term
+		if row.Principal >= balance || n >= l.Term {
+			row.Principal = roundCents(balance)
+			row.Extra = math.Max(0, roundCents(row.Principal+row.Interest-payment))
+			row.Extra = math.Min(row.Extra, extra)
+			row.Payment = roundCents(row.Principal + row.Interest - row.Extra)
+		}
+		if row.Principal <= 0 {
+			// The payment doesn't cover the interest, the loan would never be repaid
+			break
+		}
+
+		balance = roundCents(balance - row.Principal)
+		row.Balance = balance
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// getLoan returns a loan account visible to the user
+func getLoan(accountID string, uid string) (Account, error) {
+	account, err := GetAccountByID(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Account{}, err
+	}
+	if !account.IsLoan() || account.Loan == nil {
+		return Account{}, fmt.Errorf("%w: %s is not a loan", ErrInvalidLoan, account.Name)
+	}
+	return account, nil
+}
+
+// GetLoanSchedule returns the amortization schedule of a loan. By default
+// it is projected from the current balance and the next payment date, with
+// fromStart it is the original schedule. A recurring extra payment is
+// added to every payment.
+func GetLoanSchedule(accountID string, fromStart bool, extra float64, uid string) (LoanSchedule, error) {
+	account, err := getLoan(accountID, uid)
+	if err != nil {
+		return LoanSchedule{}, err
+	}
+	if extra < 0 {
+		return LoanSchedule{}, fmt.Errorf("%w: extra can't be negative", ErrInvalidLoan)
+	}
+
+	settings, err := GetUserSettings(account.UserID)
+	if err != nil {
+		return LoanSchedule{}, err
+	}
+	loc, err := settings.Location()
+	if err != nil {
+		return LoanSchedule{}, err
+	}
+
+	loan := *account.Loan
+	loan.StartDate = loan.StartDate.In(loc)
+	loan.dueDates, err = GetDueDateRule(settings)
+	if err != nil {
+		return LoanSchedule{}, err
+	}
+
+	balance, first := loan.Principal, 1
+	if !fromStart {
+		balance = roundCents(math.Max(-account.Balance, 0))
+		today := time.Now().In(loc)
+		today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+		for first <= loan.Term && loan.paymentDate(first).Before(today) {
+			first++
+		}
+	}
+
+	schedule := LoanSchedule{
+		AccountID:        account.ID,
+		RemainingBalance: balance,
+		Extra:            extra,
+		Rows:             loan.amortize(balance, first, extra),
+	}
+	summarizeSchedule(&schedule)
+
+	if extra > 0 {
+		without := LoanSchedule{Rows: loan.amortize(balance, first, 0)}
+		summarizeSchedule(&without)
+		schedule.InterestSaved = roundCents(without.TotalInterest - schedule.TotalInterest)
+		schedule.PaymentsSaved = without.PaymentsLeft - schedule.PaymentsLeft
+	}
+
+	return schedule, nil
+}
+
+func summarizeSchedule(schedule *LoanSchedule) {
+	schedule.PaymentsLeft = len(schedule.Rows)
+	schedule.TotalInterest = 0
+	for _, row := range schedule.Rows {
+		schedule.TotalInterest += row.Interest
+	}
+	schedule.TotalInterest = roundCents(schedule.TotalInterest)
+	if len(schedule.Rows) > 0 {
+		schedule.Payment = schedule.Rows[0].Payment
+		schedule.PayoffDate = schedule.Rows[len(schedule.Rows)-1].Date
+	}
+}
+
+// AddLoanRate adds a rate change to a variable rate loan
+func AddLoanRate(accountID string, rate LoanRate, uid string) (Account, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := getLoan(accountID, uid)
+	if err != nil {
+		return Account{}, err
+	}
+	if !canWrite(account.Role) {
+		return Account{}, fmt.Errorf("%w: viewers can't change account %s", ErrForbidden, account.Name)
+	}
+	if account.Loan.RateType != RateVariable {
+		return Account{}, fmt.Errorf("%w: the rate of a fixed rate loan can't change", ErrInvalidLoan)
+	}
+	if rate.EffectiveDate.IsZero() {
+		return Account{}, fmt.Errorf("%w: effective_date is required", ErrInvalidLoan)
+	}
+	if rate.AnnualRate < 0 || rate.AnnualRate > 100 {
+		return Account{}, fmt.Errorf("%w: annual_rate is a percentage between 0 and 100", ErrInvalidLoan)
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO loan_rates (account_id, effective_date, annual_rate) VALUES ($1, $2, $3)
+		ON CONFLICT (account_id, effective_date) DO UPDATE SET annual_rate = EXCLUDED.annual_rate`,
+		account.ID, rate.EffectiveDate, rate.AnnualRate)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to insert loan rate: %v", err)
+	}
+
+	return GetAccountByID(null.StringFrom(account.ID), uid)
+}
+
+// AddLoanPayment records a payment made from another account. The interest
+// accrued over one period at the rate of the payment date is recorded as an
+// expense of the paying account, the rest as a transfer to the loan that
+// reduces what is owed.
+func AddLoanPayment(accountID string, payment LoanPayment, categoryID null.String, actor Actor) (LoanPayment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	loan, err := getLoan(accountID, uid)
+	if err != nil {
+		return LoanPayment{}, err
+	}
+	from, err := getWritableAccount(null.StringFrom(payment.FromAccountID), uid)
+	if err != nil {
+		return LoanPayment{}, fmt.Errorf("invalid account: %w", err)
+	}
+	if !canWrite(loan.Role) {
+		return LoanPayment{}, fmt.Errorf("%w: viewers can't change account %s", ErrForbidden, loan.Name)
+	}
+	if err := loan.checkOpen(); err != nil {
+		return LoanPayment{}, err
+	}
+	if from.ID == loan.ID || from.IsLoan() {
+		return LoanPayment{}, fmt.Errorf("%w: pay the loan from a bank or cash account", ErrInvalidLoan)
+	}
+	if from.Currency != loan.Currency {
+		return LoanPayment{}, fmt.Errorf("%w: %s is in %s and the loan in %s", ErrInvalidLoan, from.Name, from.Currency, loan.Currency)
+	}
+
+	payment.Amount = roundCents(payment.Amount)
+	if payment.Amount <= 0 {
+		return LoanPayment{}, fmt.Errorf("%w: the amount must be positive", ErrInvalidLoan)
+	}
+	if payment.Date.IsZero() {
+		payment.Date = timeutils.NewTimestamp(time.Now())
+	}
+
+	if categoryID.String == "" {
+		categoryID = loan.Loan.InterestCategoryID
+	}
+	if categoryID.String == "" {
+		return LoanPayment{}, fmt.Errorf("%w: interest_category_id is required, on the payment or the loan", ErrInvalidLoan)
+	}
+	mainCategory, err := GetMainCategory(categoryID.String)
+	if err != nil {
+		return LoanPayment{}, fmt.Errorf("%w: %v", ErrInvalidLoan, err)
+	}
+	subcategory, err := GetSubCategory(categoryID.String)
+	if err != nil {
+		return LoanPayment{}, fmt.Errorf("%w: %v", ErrInvalidLoan, err)
+	}
+	transferID, err := transferCategoryID(ctx)
+	if err != nil {
+		return LoanPayment{}, err
+	}
+
+	exchangeRate, err := utils.GetExchangeRate(loan.Currency)
+	if err != nil {
+		log.Printf("Warning: Exchange rate not found for currency '%s'. Transaction will be saved without conversion.", loan.Currency)
+		exchangeRate = 0
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return LoanPayment{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var balance float64
+	if err := tx.QueryRow(ctx, "SELECT balance FROM accounts WHERE id = $1 FOR UPDATE", loan.ID).Scan(&balance); err != nil {
+		return LoanPayment{}, fmt.Errorf("failed to lock loan: %v", err)
+	}
+	owed := roundCents(-balance)
+	if owed <= 0 {
+		return LoanPayment{}, fmt.Errorf("%w: the loan is repaid", ErrInvalidLoan)
+	}
+
+	terms := *loan.Loan
+	payment.Interest = math.Min(roundCents(owed*terms.periodicRate(terms.rateAt(payment.Date.Time))), payment.Amount)
+	payment.Principal = roundCents(payment.Amount - payment.Interest)
+	if payment.Principal > owed {
+		return LoanPayment{}, fmt.Errorf("%w: only %.2f of principal is left, pay at most %.2f", ErrInvalidLoan, owed, owed+payment.Interest)
+	}
+
+	insert := `
+		INSERT INTO transactions (description, amount, currency, amount_in_base_currency, exchange_rate, date,
+			main_category, subcategory, category_id, account_id, related_account_id, transaction_type, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id`
+
+	if payment.Interest > 0 {
+		err = tx.QueryRow(ctx, insert,
+			loan.Name+" interest", -payment.Interest, loan.Currency, -payment.Interest*exchangeRate, exchangeRate, payment.Date,
+			mainCategory, subcategory, categoryID, from.ID, nil, TransactionTypeExpense, uid,
+		).Scan(&payment.InterestTransactionID)
+		if err != nil {
+			return LoanPayment{}, fmt.Errorf("failed to insert interest transaction: %v", err)
+		}
+	}
+
+	if payment.Principal > 0 {
+		err = tx.QueryRow(ctx, insert,
+			loan.Name+" repayment", payment.Principal, loan.Currency, payment.Principal*exchangeRate, exchangeRate, payment.Date,
+			MainCategoryTransfer, MainCategoryTransfer, transferID, from.ID, loan.ID, TransactionTypeTransfer, uid,
+		).Scan(&payment.PrincipalTransactionID)
+		if err != nil {
+			return LoanPayment{}, fmt.Errorf("failed to insert principal transaction: %v", err)
+		}
+	}
+
+	if err := postEntries(ctx, tx, payment.InterestTransactionID.String, payment.PrincipalTransactionID.String); err != nil {
+		return LoanPayment{}, err
+	}
+	err = auditTransactions(ctx, tx, actor, AuditCreate, nil, payment.InterestTransactionID.String, payment.PrincipalTransactionID.String)
+	if err != nil {
+		return LoanPayment{}, err
+	}
+
+	payment.AccountID = loan.ID
+	payment.FromAccountID = from.ID
+	payment.RemainingBalance = roundCents(owed - payment.Principal)
+	err = tx.QueryRow(ctx, `
+		INSERT INTO loan_payments (account_id, from_account_id, date, amount, interest, principal,
+			interest_transaction_id, principal_transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		payment.AccountID, payment.FromAccountID, payment.Date, payment.Amount, payment.Interest, payment.Principal,
+		payment.InterestTransactionID, payment.PrincipalTransactionID,
+	).Scan(&payment.ID)
+	if err != nil {
+		return LoanPayment{}, fmt.Errorf("failed to insert loan payment: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return LoanPayment{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return payment, nil
+}
+
+// GetLoanPayments lists the payments recorded on a loan, latest first
+func GetLoanPayments(accountID string, uid string) ([]LoanPayment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	loan, err := getLoan(accountID, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := GetUserLocation(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT id, account_id, from_account_id, date, amount, interest, principal,
+			interest_transaction_id::text, principal_transaction_id::text
+		FROM loan_payments
+		WHERE account_id = $1
+		ORDER BY date DESC, id`, loan.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve loan payments: %v", err)
+	}
+	defer rows.Close()
+
+	payments := []LoanPayment{}
+	for rows.Next() {
+		var payment LoanPayment
+		if err := rows.Scan(&payment.ID, &payment.AccountID, &payment.FromAccountID, &payment.Date, &payment.Amount,
+			&payment.Interest, &payment.Principal, &payment.InterestTransactionID, &payment.PrincipalTransactionID); err != nil {
+			return nil, fmt.Errorf("failed to scan loan payment: %v", err)
+		}
+		payment.Date = payment.Date.In(loc)
+		payments = append(payments, payment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Remaining balances are replayed from the current balance backwards
+	remaining := roundCents(math.Max(-loan.Balance, 0))
+	for i := range payments {
+		payments[i].RemainingBalance = remaining
+		remaining = roundCents(remaining + payments[i].Principal)
+	}
+
+	return payments, nil
+}
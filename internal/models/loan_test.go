@@ -0,0 +1,182 @@
+package models
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"guilliman/internal/utils/timeutils"
+)
+
+func utcDay(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func loanRate(year int, month time.Month, day int, annual float64) LoanRate {
+	return LoanRate{EffectiveDate: timeutils.NewTimestamp(utcDay(year, month, day)), AnnualRate: annual}
+}
+
+func TestAmortize(t *testing.T) {
+	monthly := func(term int, rates []LoanRate) LoanTerms {
+		return LoanTerms{
+			Principal: 1000,
+			StartDate: timeutils.NewTimestamp(utcDay(2025, time.January, 31)),
+			Term:      term,
+			Frequency: LoanMonthly,
+			RateType:  RateVariable,
+			Rates:     rates,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		loan      LoanTerms
+		balance   float64
+		first     int
+		extra     float64
+		wantCount int
+		// Rows checked by their index in the schedule, -1 for the last one
+		wantRows map[int]AmortizationRow
+	}{
+		{
+			name:      "without interest",
+			loan:      monthly(12, []LoanRate{loanRate(2025, time.January, 1, 0)}),
+			balance:   1200,
+			first:     1,
+			wantCount: 12,
+			wantRows: map[int]AmortizationRow{
+				0:  {Number: 1, Payment: 100, Principal: 100, Balance: 1100},
+				-1: {Number: 12, Payment: 100, Principal: 100, Balance: 0},
+			},
+		},
+		{
+			name:      "fixed rate",
+			loan:      monthly(12, []LoanRate{loanRate(2025, time.January, 1, 12)}),
+			balance:   1000,
+			first:     1,
+			wantCount: 12,
+			wantRows: map[int]AmortizationRow{
+				0: {Number: 1, AnnualRate: 12, Payment: 88.85, Interest: 10, Principal: 78.85, Balance: 921.15},
+				1: {Number: 2, AnnualRate: 12, Payment: 88.85, Interest: 9.21, Principal: 79.64, Balance: 841.51},
+			},
+		},
+		{
+			name:      "rate change recomputes the payment",
+			loan:      monthly(12, []LoanRate{loanRate(2025, time.January, 1, 0), loanRate(2025, time.August, 15, 12)}),
+			balance:   1200,
+			first:     1,
+			wantCount: 12,
+			wantRows: map[int]AmortizationRow{
+				5: {Number: 6, Payment: 100, Principal: 100, Balance: 600},
+				6: {Number: 7, AnnualRate: 12, Payment: 103.53, Interest: 6, Principal: 97.53, Balance: 502.47},
+			},
+		},
+		{
+			name:      "extra payments shorten the loan",
+			loan:      monthly(12, []LoanRate{loanRate(2025, time.January, 1, 0)}),
+			balance:   1200,
+			first:     1,
+			extra:     100,
+			wantCount: 6,
+			wantRows: map[int]AmortizationRow{
+				0:  {Number: 1, Payment: 100, Principal: 200, Extra: 100, Balance: 1000},
+				-1: {Number: 6, Payment: 100, Principal: 200, Extra: 100, Balance: 0},
+			},
+		},
+		{
+			name:      "last payment takes only the extra it needs",
+			loan:      monthly(4, []LoanRate{loanRate(2025, time.January, 1, 0)}),
+			balance:   1000,
+			first:     1,
+			extra:     100,
+			wantCount: 3,
+			wantRows: map[int]AmortizationRow{
+				-1: {Number: 3, Payment: 250, Principal: 300, Extra: 50, Balance: 0},
+			},
+		},
+		{
+			name:      "projected from a later payment",
+			loan:      monthly(12, []LoanRate{loanRate(2025, time.January, 1, 0)}),
+			balance:   600,
+			first:     7,
+			wantCount: 6,
+			wantRows: map[int]AmortizationRow{
+				0:  {Number: 7, Payment: 100, Principal: 100, Balance: 500},
+				-1: {Number: 12, Payment: 100, Principal: 100, Balance: 0},
+			},
+		},
+		{
+			name:      "past the term everything is due",
+			loan:      monthly(12, []LoanRate{loanRate(2025, time.January, 1, 0)}),
+			balance:   500,
+			first:     13,
+			wantCount: 1,
+			wantRows: map[int]AmortizationRow{
+				0: {Number: 13, Payment: 500, Principal: 500, Balance: 0},
+			},
+		},
+		{
+			name:      "nothing owed",
+			loan:      monthly(12, []LoanRate{loanRate(2025, time.January, 1, 5)}),
+			balance:   0,
+			first:     1,
+			wantCount: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := tt.loan.amortize(tt.balance, tt.first, tt.extra)
+			if len(rows) != tt.wantCount {
+				t.Fatalf("got %d rows, want %d", len(rows), tt.wantCount)
+			}
+
+			for i, want := range tt.wantRows {
+				if i < 0 {
+					i += len(rows)
+				}
+				got := rows[i]
+				got.Date = timeutils.Timestamp{}
+				if got != want {
+					t.Errorf("row %d = %+v, want %+v", i, got, want)
+				}
+			}
+
+			repaid := 0.0
+			for _, row := range rows {
+				repaid += row.Principal
+			}
+			if math.Abs(repaid-tt.balance) > 0.005 {
+				t.Errorf("principal repaid = %.2f, want %.2f", repaid, tt.balance)
+			}
+		})
+	}
+}
+
+func TestLoanPaymentDate(t *testing.T) {
+	next := timeutils.BusinessDayRule{Adjustment: timeutils.AdjustNext}
+	previous := timeutils.BusinessDayRule{Adjustment: timeutils.AdjustPrevious}
+
+	tests := []struct {
+		frequency string
+		n         int
+		dueDates  timeutils.BusinessDayRule
+		want      time.Time
+	}{
+		{LoanMonthly, 1, timeutils.BusinessDayRule{}, utcDay(2025, time.February, 28)},
+		{LoanMonthly, 2, timeutils.BusinessDayRule{}, utcDay(2025, time.March, 31)},
+		{LoanMonthly, 13, timeutils.BusinessDayRule{}, utcDay(2026, time.February, 28)},
+		{LoanMonthly, 13, next, utcDay(2026, time.March, 2)},
+		{LoanMonthly, 13, previous, utcDay(2026, time.February, 27)},
+		{LoanMonthly, 1, next, utcDay(2025, time.February, 28)},
+		{LoanBiweekly, 1, timeutils.BusinessDayRule{}, utcDay(2025, time.February, 14)},
+		{LoanWeekly, 2, timeutils.BusinessDayRule{}, utcDay(2025, time.February, 14)},
+	}
+
+	for _, tt := range tests {
+		loan := LoanTerms{StartDate: timeutils.NewTimestamp(utcDay(2025, time.January, 31)), Frequency: tt.frequency, dueDates: tt.dueDates}
+		if got := loan.paymentDate(tt.n); !got.Equal(tt.want) {
+			t.Errorf("%s payment %d (%q) = %s, want %s", tt.frequency, tt.n, tt.dueDates.Adjustment, got.Format("2006-01-02"), tt.want.Format("2006-01-02"))
+		}
+	}
+}
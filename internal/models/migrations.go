@@ -0,0 +1,375 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+type migration struct {
+	Name string
+	SQL  string
+}
+
+// migrations run once, in order, after CreateTables. Append new entries at
+// the end and never edit an applied one.
+var migrations = []migration{
+	{
+		Name: "0001_user_settings_holidays",
+		SQL: `ALTER TABLE user_settings
+			ADD COLUMN IF NOT EXISTS holiday_country TEXT,
+			ADD COLUMN IF NOT EXISTS pay_day_adjustment TEXT NOT NULL DEFAULT 'none';`,
+	},
+	{
+		Name: "0002_time_zones",
+		SQL: `ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS time_zone TEXT NOT NULL DEFAULT 'UTC';
+			DO $$
+			BEGIN
+				IF EXISTS (
+					SELECT 1 FROM information_schema.columns
+					WHERE table_name = 'transactions' AND column_name = 'date' AND data_type = 'integer'
+				) THEN
+					ALTER TABLE transactions ALTER COLUMN date TYPE TIMESTAMPTZ USING to_timestamp(date);
+				END IF;
+			END $$;`,
+	},
+	{
+		// Search is accent insensitive and unstemmed since descriptions mix
+		// Spanish, Swedish and English. The vector is kept by a trigger so
+		// it can be indexed.
+		Name: "0003_transaction_search",
+		SQL: `CREATE EXTENSION IF NOT EXISTS unaccent;
+			DO $$
+			BEGIN
+				IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'guilliman_search') THEN
+					CREATE TEXT SEARCH CONFIGURATION guilliman_search (COPY = simple);
+					ALTER TEXT SEARCH CONFIGURATION guilliman_search
+						ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+				END IF;
+			END $$;
+			ALTER TABLE transactions ADD COLUMN IF NOT EXISTS search_vector tsvector;
+			CREATE OR REPLACE FUNCTION transactions_search_vector() RETURNS trigger AS $$
+			BEGIN
+				NEW.search_vector :=
+					setweight(to_tsvector('guilliman_search', COALESCE(NEW.description, '')), 'A') ||
+					setweight(to_tsvector('guilliman_search', COALESCE(NEW.subcategory, '') || ' ' || COALESCE(NEW.main_category, '')), 'C');
+				RETURN NEW;
+			END
+			$$ LANGUAGE plpgsql;
+			DROP TRIGGER IF EXISTS transactions_search_vector ON transactions;
+			CREATE TRIGGER transactions_search_vector BEFORE INSERT OR UPDATE ON transactions
+				FOR EACH ROW EXECUTE FUNCTION transactions_search_vector();
+			UPDATE transactions SET search_vector = NULL;
+			CREATE INDEX IF NOT EXISTS transactions_search_vector_idx ON transactions USING GIN (search_vector);`,
+	},
+	{
+		Name: "0004_transaction_notes",
+		SQL: `ALTER TABLE transactions ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+			CREATE OR REPLACE FUNCTION transactions_search_vector() RETURNS trigger AS $$
+			BEGIN
+				NEW.search_vector :=
+					setweight(to_tsvector('guilliman_search', COALESCE(NEW.description, '')), 'A') ||
+					setweight(to_tsvector('guilliman_search', COALESCE(NEW.notes, '')), 'B') ||
+					setweight(to_tsvector('guilliman_search', COALESCE(NEW.subcategory, '') || ' ' || COALESCE(NEW.main_category, '')), 'C');
+				RETURN NEW;
+			END
+			$$ LANGUAGE plpgsql;
+			CREATE INDEX IF NOT EXISTS transaction_tags_tag_id_idx ON transaction_tags (tag_id);`,
+	},
+	{
+		Name: "0005_transaction_splits",
+		SQL:  `CREATE INDEX IF NOT EXISTS transaction_splits_transaction_id_idx ON transaction_splits (transaction_id);`,
+	},
+	{
+		Name: "0006_attachments",
+		SQL:  `CREATE INDEX IF NOT EXISTS attachments_transaction_id_idx ON attachments (transaction_id);`,
+	},
+	{
+		Name: "0007_payees",
+		SQL: `ALTER TABLE transactions ADD COLUMN IF NOT EXISTS payee_id UUID REFERENCES payees(id) ON DELETE SET NULL;
+			CREATE INDEX IF NOT EXISTS transactions_payee_id_idx ON transactions (payee_id);
+			CREATE INDEX IF NOT EXISTS payee_aliases_payee_id_idx ON payee_aliases (payee_id);`,
+	},
+	{
+		Name: "0008_refunds",
+		SQL: `ALTER TABLE transactions ADD COLUMN IF NOT EXISTS refund_of_id UUID REFERENCES transactions(id);
+			CREATE INDEX IF NOT EXISTS transactions_refund_of_id_idx ON transactions (refund_of_id);
+			ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS refund_period TEXT NOT NULL DEFAULT 'refund';`,
+	},
+	{
+		Name: "0009_shared_expenses",
+		SQL: `CREATE INDEX IF NOT EXISTS shared_expense_shares_user_id_idx ON shared_expense_shares (user_id);
+			CREATE INDEX IF NOT EXISTS settlements_from_user_id_idx ON settlements (from_user_id);
+			CREATE INDEX IF NOT EXISTS settlements_to_user_id_idx ON settlements (to_user_id);`,
+	},
+	{
+		// Accounts leaving a deleted household go back to their creator,
+		// household categories are removed with it
+		Name: "0010_households",
+		SQL: `ALTER TABLE accounts ADD COLUMN IF NOT EXISTS household_id UUID REFERENCES households(id) ON DELETE SET NULL;
+			ALTER TABLE categories ADD COLUMN IF NOT EXISTS household_id UUID REFERENCES households(id) ON DELETE CASCADE;
+			CREATE INDEX IF NOT EXISTS accounts_household_id_idx ON accounts (household_id);
+			CREATE INDEX IF NOT EXISTS categories_household_id_idx ON categories (household_id);
+			CREATE INDEX IF NOT EXISTS household_members_user_id_idx ON household_members (user_id);
+			CREATE INDEX IF NOT EXISTS transactions_account_id_idx ON transactions (account_id);`,
+	},
+	{
+		Name: "0011_credit_cards",
+		SQL: `ALTER TABLE accounts
+			ADD COLUMN IF NOT EXISTS credit_limit REAL,
+			ADD COLUMN IF NOT EXISTS statement_closing_day INTEGER,
+			ADD COLUMN IF NOT EXISTS payment_due_day INTEGER,
+			ADD COLUMN IF NOT EXISTS minimum_payment_rate REAL,
+			ADD COLUMN IF NOT EXISTS minimum_payment_amount REAL;
+			CREATE INDEX IF NOT EXISTS transactions_related_account_id_idx ON transactions (related_account_id);`,
+	},
+	{
+		Name: "0012_loans",
+		SQL: `CREATE INDEX IF NOT EXISTS loan_payments_account_id_idx ON loan_payments (account_id, date);
+			CREATE INDEX IF NOT EXISTS loan_payments_from_account_id_idx ON loan_payments (from_account_id);`,
+	},
+	{
+		Name: "0013_investments",
+		SQL: `ALTER TABLE accounts ADD COLUMN IF NOT EXISTS cost_method TEXT;
+			CREATE INDEX IF NOT EXISTS investment_trades_account_id_idx ON investment_trades (account_id, date);
+			CREATE INDEX IF NOT EXISTS investment_trades_transaction_id_idx ON investment_trades (transaction_id);`,
+	},
+	{
+		Name: "0014_reconciliations",
+		SQL: `CREATE UNIQUE INDEX IF NOT EXISTS reconciliations_open_idx ON reconciliations (account_id) WHERE status = 'open';
+			CREATE INDEX IF NOT EXISTS reconciliation_items_transaction_id_idx ON reconciliation_items (transaction_id);`,
+	},
+	{
+		Name: "0015_transaction_status",
+		SQL: `ALTER TABLE transactions ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'cleared';
+			UPDATE transactions SET status = 'reconciled'
+			WHERE id IN (SELECT ri.transaction_id FROM reconciliation_items ri
+				JOIN reconciliations r ON r.id = ri.reconciliation_id WHERE r.status = 'finished');
+			CREATE INDEX IF NOT EXISTS transactions_pending_idx ON transactions (date) WHERE status = 'pending';
+			ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS pending_expiry_days INTEGER NOT NULL DEFAULT 14;`,
+	},
+	{
+		// What the transactions don't explain of a balance set directly
+		// becomes the opening balance of the account, dated before its
+		// first transaction
+		Name: "0016_opening_balances",
+		SQL: `INSERT INTO transactions (description, amount, currency, amount_in_base_currency, exchange_rate, date,
+				main_category, subcategory, account_id, transaction_type, user_id)
+			SELECT 'Opening balance', o.amount, a.currency, 0, 0, o.date,
+				'Adjustment', 'Opening balance', a.id, 'Adjustment', a.user_id
+			FROM accounts a, LATERAL (
+				SELECT a.balance - COALESCE((SELECT SUM(
+						CASE WHEN t.status = 'void' THEN 0
+						WHEN t.transaction_type IN ('Transfer', 'Savings')
+						THEN CASE WHEN t.account_id = a.id THEN -(t.amount + COALESCE(t.fees, 0)) ELSE 0 END
+						   + CASE WHEN t.related_account_id = a.id THEN t.amount + COALESCE(t.fees, 0) ELSE 0 END
+						ELSE CASE WHEN t.account_id = a.id THEN t.amount ELSE 0 END END)
+					FROM transactions t WHERE t.account_id = a.id OR t.related_account_id = a.id), 0) AS amount,
+					COALESCE((SELECT MIN(t.date) FROM transactions t WHERE t.account_id = a.id OR t.related_account_id = a.id),
+						a.created_at, CURRENT_TIMESTAMP) AS date) o
+			WHERE ABS(o.amount) >= 0.01;`,
+	},
+	{
+		// Balances are kept by the journal from now on, existing
+		// transactions are posted as they are. Transfers credit their fees
+		// to the related account like the balances did, see 0025.
+		Name: "0017_journal",
+		SQL: `CREATE INDEX IF NOT EXISTS postings_transaction_id_idx ON postings (transaction_id, account_id);
+			CREATE INDEX IF NOT EXISTS postings_account_id_idx ON postings (account_id) WHERE account_id IS NOT NULL;
+			INSERT INTO postings (transaction_id, account_id, category_id, nominal, amount)
+			SELECT t.id, t.account_id, NULL::uuid, '',
+				CASE WHEN t.transaction_type IN ('Transfer', 'Savings') THEN -(t.amount + COALESCE(t.fees, 0)) ELSE t.amount END
+			FROM transactions t WHERE t.status <> 'void' AND t.account_id IS NOT NULL
+			UNION ALL
+			SELECT t.id, t.related_account_id, NULL::uuid, '', t.amount + COALESCE(t.fees, 0)
+			FROM transactions t WHERE t.status <> 'void' AND t.account_id IS NOT NULL
+				AND t.transaction_type IN ('Transfer', 'Savings') AND t.related_account_id IS NOT NULL
+			UNION ALL
+			SELECT t.id, NULL::uuid, t.category_id, t.transaction_type, t.amount + COALESCE(t.fees, 0)
+			FROM transactions t WHERE t.status <> 'void' AND t.account_id IS NOT NULL
+				AND t.transaction_type IN ('Transfer', 'Savings') AND t.related_account_id IS NULL
+			UNION ALL
+			SELECT t.id, NULL::uuid, s.category_id, t.transaction_type, -s.amount
+			FROM transactions t JOIN transaction_splits s ON s.transaction_id = t.id
+			WHERE t.status <> 'void' AND t.account_id IS NOT NULL AND t.transaction_type NOT IN ('Transfer', 'Savings')
+			UNION ALL
+			SELECT t.id, NULL::uuid, t.category_id, t.transaction_type, -t.amount
+			FROM transactions t WHERE t.status <> 'void' AND t.account_id IS NOT NULL
+				AND t.transaction_type NOT IN ('Transfer', 'Savings')
+				AND NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id);`,
+	},
+	{
+		Name: "0018_archived_accounts",
+		SQL:  `ALTER TABLE accounts ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;`,
+	},
+	{
+		Name: "0019_account_groups",
+		SQL: `ALTER TABLE accounts ADD COLUMN IF NOT EXISTS include_in_net_worth BOOLEAN NOT NULL DEFAULT TRUE;
+			ALTER TABLE accounts ADD COLUMN IF NOT EXISTS include_in_budget BOOLEAN NOT NULL DEFAULT TRUE;
+			CREATE INDEX IF NOT EXISTS account_groups_user_id_idx ON account_groups (user_id);`,
+	},
+	{
+		Name: "0020_transaction_trash",
+		SQL: `ALTER TABLE transactions ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+			CREATE INDEX IF NOT EXISTS transactions_deleted_at_idx ON transactions (deleted_at) WHERE deleted_at IS NOT NULL;
+			ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS trash_retention_days INTEGER NOT NULL DEFAULT 30;
+			CREATE INDEX IF NOT EXISTS undo_tokens_expires_at_idx ON undo_tokens (expires_at);`,
+	},
+	{
+		Name: "0021_audit_log",
+		SQL: `CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity_type, entity_id, id);
+			CREATE INDEX IF NOT EXISTS audit_log_user_idx ON audit_log (user_id, id);
+			CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'the audit log is append-only';
+			END
+			$$ LANGUAGE plpgsql;
+			DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+			CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+				FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();`,
+	},
+	{
+		// Versions move on with every change, except to the columns given
+		// to the trigger, which the server keeps up to date. Updates setting
+		// the version themselves keep theirs.
+		Name: "0022_row_versions",
+		SQL: `ALTER TABLE transactions ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+			ALTER TABLE accounts ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+			ALTER TABLE categories ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+			CREATE OR REPLACE FUNCTION bump_version() RETURNS trigger AS $$
+			BEGIN
+				IF NEW.version = OLD.version AND
+					to_jsonb(NEW) - COALESCE(TG_ARGV, '{}') <> to_jsonb(OLD) - COALESCE(TG_ARGV, '{}') THEN
+					NEW.version := OLD.version + 1;
+				END IF;
+				RETURN NEW;
+			END
+			$$ LANGUAGE plpgsql;
+			DROP TRIGGER IF EXISTS transactions_version ON transactions;
+			CREATE TRIGGER transactions_version BEFORE UPDATE ON transactions
+				FOR EACH ROW EXECUTE FUNCTION bump_version('search_vector');
+			DROP TRIGGER IF EXISTS accounts_version ON accounts;
+			CREATE TRIGGER accounts_version BEFORE UPDATE ON accounts
+				FOR EACH ROW EXECUTE FUNCTION bump_version('balance');
+			DROP TRIGGER IF EXISTS categories_version ON categories;
+			CREATE TRIGGER categories_version BEFORE UPDATE ON categories
+				FOR EACH ROW EXECUTE FUNCTION bump_version();`,
+	},
+	{
+		Name: "0023_idempotency_keys",
+		SQL:  `CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);`,
+	},
+	{
+		// Payee and category names are read through their ids, so the
+		// vectors of their transactions are refreshed when they are renamed
+		Name: "0024_search_payees",
+		SQL: `CREATE OR REPLACE FUNCTION transactions_search_vector() RETURNS trigger AS $$
+			DECLARE
+				payee_name TEXT;
+				category_name TEXT;
+				main_category_name TEXT;
+			BEGIN
+				SELECT name INTO payee_name FROM payees WHERE id = NEW.payee_id;
+				SELECT name, main_category INTO category_name, main_category_name FROM categories WHERE id = NEW.category_id;
+				NEW.search_vector :=
+					setweight(to_tsvector('guilliman_search', COALESCE(NEW.description, '') || ' ' || COALESCE(payee_name, '')), 'A') ||
+					setweight(to_tsvector('guilliman_search', COALESCE(NEW.notes, '')), 'B') ||
+					setweight(to_tsvector('guilliman_search',
+						COALESCE(category_name, NEW.subcategory, '') || ' ' || COALESCE(main_category_name, NEW.main_category, '')), 'C');
+				RETURN NEW;
+			END
+			$$ LANGUAGE plpgsql;
+			CREATE OR REPLACE FUNCTION refresh_transactions_search_vector() RETURNS trigger AS $$
+			BEGIN
+				IF TG_TABLE_NAME = 'payees' THEN
+					UPDATE transactions SET search_vector = NULL WHERE payee_id = NEW.id;
+				ELSE
+					UPDATE transactions SET search_vector = NULL WHERE category_id = NEW.id;
+				END IF;
+				RETURN NULL;
+			END
+			$$ LANGUAGE plpgsql;
+			DROP TRIGGER IF EXISTS payees_search_vector ON payees;
+			CREATE TRIGGER payees_search_vector AFTER UPDATE OF name ON payees
+				FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+				EXECUTE FUNCTION refresh_transactions_search_vector();
+			DROP TRIGGER IF EXISTS categories_search_vector ON categories;
+			CREATE TRIGGER categories_search_vector AFTER UPDATE OF name, main_category ON categories
+				FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name OR OLD.main_category IS DISTINCT FROM NEW.main_category)
+				EXECUTE FUNCTION refresh_transactions_search_vector();
+			UPDATE transactions SET search_vector = NULL;`,
+	},
+	{
+		// Transfers used to credit their fees to the related account, they
+		// are posted again with the fees on their own nominal account. The
+		// balances of the accounts involved are then those of the journal.
+		Name: "0025_transfer_fees",
+		SQL: `CREATE TEMPORARY TABLE fee_transfers ON COMMIT DROP AS
+				SELECT t.id, t.account_id, t.related_account_id FROM transactions t
+				WHERE t.transaction_type IN ('Transfer', 'Savings') AND COALESCE(t.fees, 0) <> 0;
+			DELETE FROM postings WHERE transaction_id IN (SELECT id FROM fee_transfers);
+			INSERT INTO postings (transaction_id, account_id, category_id, nominal, amount)
+			SELECT t.id, t.account_id, NULL::uuid, '', -(t.amount + COALESCE(t.fees, 0))
+			FROM transactions t WHERE t.id IN (SELECT id FROM fee_transfers)
+				AND t.status <> 'void' AND t.account_id IS NOT NULL AND t.deleted_at IS NULL
+			UNION ALL
+			SELECT t.id, t.related_account_id, NULL::uuid, '', t.amount
+			FROM transactions t WHERE t.id IN (SELECT id FROM fee_transfers)
+				AND t.status <> 'void' AND t.account_id IS NOT NULL AND t.deleted_at IS NULL AND t.related_account_id IS NOT NULL
+			UNION ALL
+			SELECT t.id, NULL::uuid, t.category_id, t.transaction_type, t.amount
+			FROM transactions t WHERE t.id IN (SELECT id FROM fee_transfers)
+				AND t.status <> 'void' AND t.account_id IS NOT NULL AND t.deleted_at IS NULL AND t.related_account_id IS NULL
+			UNION ALL
+			SELECT t.id, NULL::uuid, NULL::uuid, 'Fees', t.fees
+			FROM transactions t WHERE t.id IN (SELECT id FROM fee_transfers)
+				AND t.status <> 'void' AND t.account_id IS NOT NULL AND t.deleted_at IS NULL;
+			UPDATE accounts a SET balance = COALESCE((SELECT SUM(p.amount) FROM postings p WHERE p.account_id = a.id), 0)
+			WHERE a.id IN (SELECT account_id FROM fee_transfers UNION SELECT related_account_id FROM fee_transfers);`,
+	},
+	{
+		Name: "0026_due_date_adjustment",
+		SQL:  `ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS due_date_adjustment TEXT NOT NULL DEFAULT 'none';`,
+	},
+}
+
+// RunMigrations applies the migrations missing from the migrations table
+func RunMigrations() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	for _, m := range migrations {
+		var applied bool
+		err := db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM migrations WHERE name = $1)", m.Name).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", m.Name, err)
+		}
+		if applied {
+			continue
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to start migration %s: %w", m.Name, err)
+		}
+
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("failed to apply migration %s: %w", m.Name, err)
+		}
+
+		if _, err := tx.Exec(ctx, "INSERT INTO migrations (name) VALUES ($1)", m.Name); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("failed to record migration %s: %w", m.Name, err)
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", m.Name, err)
+		}
+
+		log.Printf("Applied migration %s", m.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,521 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	PayeeMatchExact    = "exact"    // Normalized description equals the normalized pattern
+	PayeeMatchPrefix   = "prefix"   // Normalized description starts with the normalized pattern
+	PayeeMatchContains = "contains" // Normalized description contains the normalized pattern
+	PayeeMatchRegex    = "regex"    // Case insensitive regular expression on the raw description
+)
+
+// ErrInvalidPayee wraps errors caused by payee data sent by the client
+var ErrInvalidPayee = errors.New("invalid payee")
+
+// Payee is a merchant or person money is paid to or received from.
+// Transactions are matched to a payee through its name and aliases.
+type Payee struct {
+	ID                string       `json:"id"`
+	Name              string       `json:"name"`
+	DefaultCategoryID null.String  `json:"default_category_id"` // Used for new transactions without a category
+	Aliases           []PayeeAlias `json:"aliases"`
+	UserID            string       `json:"user_id"`
+}
+
+// PayeeAlias is a pattern recognizing a payee in transaction descriptions
+type PayeeAlias struct {
+	ID        string `json:"id"`
+	PayeeID   string `json:"payee_id"`
+	Pattern   string `json:"pattern"`
+	MatchType string `json:"match_type"` // "exact" (default), "prefix", "contains" or "regex"
+}
+
+// PayeeSummary is the activity of a payee during a period
+type PayeeSummary struct {
+	PayeeID             string              `json:"payee_id"`
+	Name                string              `json:"name"`
+	TotalSpent          float64             `json:"total_spent"`    // Expenses net of refunds, as a positive amount
+	TotalReceived       float64             `json:"total_received"` // Incomes
+	TransactionCount    int                 `json:"transaction_count"`
+	AverageIntervalDays null.Float          `json:"average_interval_days"` // Days between transactions, null with a single one
+	FirstTransaction    timeutils.Timestamp `json:"first_transaction"`
+	LastTransaction     timeutils.Timestamp `json:"last_transaction"`
+	LastTransactionID   string              `json:"last_transaction_id"`
+	LastAmount          float64             `json:"last_amount"`
+}
+
+// NormalizePayeeName reduces a bank description to the words naming the
+// merchant: upper case, punctuation removed and words with digits (store
+// numbers, card references, dates) dropped. "ICA MAXI 1234" and
+// "Ica Maxi" both become "ICA MAXI".
+func NormalizePayeeName(description string) string {
+	words := strings.FieldsFunc(strings.ToUpper(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	kept := words[:0]
+	for _, word := range words {
+		if strings.IndexFunc(word, unicode.IsDigit) < 0 {
+			kept = append(kept, word)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+func (a PayeeAlias) validate() (PayeeAlias, error) {
+	a.Pattern = strings.TrimSpace(a.Pattern)
+	if a.MatchType == "" {
+		a.MatchType = PayeeMatchExact
+	}
+
+	switch a.MatchType {
+	case PayeeMatchExact, PayeeMatchPrefix, PayeeMatchContains:
+		if NormalizePayeeName(a.Pattern) == "" {
+			return a, fmt.Errorf("%w: the pattern '%s' has no letters", ErrInvalidPayee, a.Pattern)
+		}
+	case PayeeMatchRegex:
+		if _, err := regexp.Compile("(?i)" + a.Pattern); err != nil {
+			return a, fmt.Errorf("%w: invalid regular expression: %v", ErrInvalidPayee, err)
+		}
+	default:
+		return a, fmt.Errorf("%w: unknown match type '%s', use exact, prefix, contains or regex", ErrInvalidPayee, a.MatchType)
+	}
+	return a, nil
+}
+
+// payeeRule is an alias ready to be matched
+type payeeRule struct {
+	payeeID         string
+	payeeName       string
+	defaultCategory null.String
+	matchType       string
+	pattern         string // Normalized, or the expression for regex rules
+	re              *regexp.Regexp
+}
+
+// payeeMatcher finds the payee of a description. Exact matches win over
+// prefixes, prefixes over substrings and those over regular expressions;
+// between rules of the same kind the longest pattern wins.
+type payeeMatcher struct {
+	rules []payeeRule
+}
+
+var payeeMatchRank = map[string]int{
+	PayeeMatchExact:    3,
+	PayeeMatchPrefix:   2,
+	PayeeMatchContains: 1,
+	PayeeMatchRegex:    0,
+}
+
+func (m payeeMatcher) match(description string) (payeeRule, bool) {
+	normalized := NormalizePayeeName(description)
+
+	var best payeeRule
+	found := false
+	for _, rule := range m.rules {
+		var ok bool
+		switch rule.matchType {
+		case PayeeMatchExact:
+			ok = normalized == rule.pattern
+		case PayeeMatchPrefix:
+			ok = normalized == rule.pattern || strings.HasPrefix(normalized, rule.pattern+" ")
+		case PayeeMatchContains:
+			ok = strings.Contains(" "+normalized+" ", " "+rule.pattern+" ")
+		case PayeeMatchRegex:
+			ok = rule.re.MatchString(description)
+		}
+		if !ok {
+			continue
+		}
+
+		if !found ||
+			payeeMatchRank[rule.matchType] > payeeMatchRank[best.matchType] ||
+			payeeMatchRank[rule.matchType] == payeeMatchRank[best.matchType] && len(rule.pattern) > len(best.pattern) {
+			best, found = rule, true
+		}
+	}
+	return best, found
+}
+
+// loadPayeeMatcher reads the payees of a user, each name is an exact alias
+func loadPayeeMatcher(ctx context.Context, uid string) (payeeMatcher, error) {
+	rows, err := db.Query(ctx, `
+		SELECT p.id, p.name, p.default_category_id::text, 'exact', p.name FROM payees p WHERE p.user_id = $1
+		UNION ALL
+		SELECT p.id, p.name, p.default_category_id::text, a.match_type, a.pattern
+		FROM payee_aliases a JOIN payees p ON p.id = a.payee_id
+		WHERE p.user_id = $1`, uid)
+	if err != nil {
+		return payeeMatcher{}, fmt.Errorf("failed to retrieve payees: %v", err)
+	}
+	defer rows.Close()
+
+	var matcher payeeMatcher
+	for rows.Next() {
+		var rule payeeRule
+		if err := rows.Scan(&rule.payeeID, &rule.payeeName, &rule.defaultCategory, &rule.matchType, &rule.pattern); err != nil {
+			return payeeMatcher{}, err
+		}
+
+		if rule.matchType == PayeeMatchRegex {
+			rule.re, err = regexp.Compile("(?i)" + rule.pattern)
+			if err != nil {
+				continue
+			}
+		} else {
+			rule.pattern = NormalizePayeeName(rule.pattern)
+			if rule.pattern == "" {
+				continue
+			}
+		}
+		matcher.rules = append(matcher.rules, rule)
+	}
+
+	return matcher, rows.Err()
+}
+
+// MatchPayee finds the payee of a transaction description, without its aliases
+func MatchPayee(description string, uid string) (Payee, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	matcher, err := loadPayeeMatcher(ctx, uid)
+	if err != nil {
+		return Payee{}, false, err
+	}
+
+	rule, ok := matcher.match(description)
+	if !ok {
+		return Payee{}, false, nil
+	}
+	return Payee{
+		ID:                rule.payeeID,
+		Name:              rule.payeeName,
+		DefaultCategoryID: rule.defaultCategory,
+		UserID:            uid,
+	}, true, nil
+}
+
+// RematchPayees assigns payees to the transactions of a user from their
+// descriptions, e.g. after adding aliases or importing. Transactions with a
+// payee keep it unless overwrite is set. Returns the number of transactions
+// updated.
+func RematchPayees(actor Actor, overwrite bool) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	matcher, err := loadPayeeMatcher(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id::text, description, COALESCE(payee_id::text, '')
+		FROM transactions
+		WHERE user_id = $1 AND ($2 OR payee_id IS NULL)
+		FOR UPDATE`, uid, overwrite)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve transactions: %v", err)
+	}
+
+	var ids, payeeIDs []string
+	for rows.Next() {
+		var id, description, current string
+		if err := rows.Scan(&id, &description, &current); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if rule, ok := matcher.match(description); ok && rule.payeeID != current {
+			ids = append(ids, id)
+			payeeIDs = append(payeeIDs, rule.payeeID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error occurred during rows iteration: %v", err)
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	before, err := transactionSnapshots(ctx, tx, ids)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(ctx, `
+		UPDATE transactions t SET payee_id = m.payee_id::uuid
+		FROM (SELECT UNNEST($1::text[]) AS id, UNNEST($2::text[]) AS payee_id) m
+		WHERE t.id::text = m.id AND t.user_id = $3`, ids, payeeIDs, uid)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update payees: %v", err)
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditUpdate, before, ids...); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// GetPayees lists the payees of a user with their aliases
+func GetPayees(uid string) ([]Payee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT p.id, p.name, p.default_category_id, p.user_id,
+			COALESCE(json_agg(json_build_object(
+				'id', a.id, 'payee_id', a.payee_id, 'pattern', a.pattern, 'match_type', a.match_type
+			) ORDER BY a.pattern) FILTER (WHERE a.id IS NOT NULL), '[]')
+		FROM payees p
+		LEFT JOIN payee_aliases a ON a.payee_id = p.id
+		WHERE p.user_id = $1
+		GROUP BY p.id
+		ORDER BY p.name`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve payees: %v", err)
+	}
+	defer rows.Close()
+
+	payees := []Payee{}
+	for rows.Next() {
+		var payee Payee
+		if err := rows.Scan(&payee.ID, &payee.Name, &payee.DefaultCategoryID, &payee.UserID, &payee.Aliases); err != nil {
+			return nil, err
+		}
+		payees = append(payees, payee)
+	}
+
+	return payees, rows.Err()
+}
+
+// AddPayee creates a payee with its aliases, names are unique per user
+func AddPayee(payee Payee) (Payee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	payee.Name = strings.TrimSpace(payee.Name)
+	if payee.Name == "" {
+		return Payee{}, fmt.Errorf("%w: the name is required", ErrInvalidPayee)
+	}
+
+	for i := range payee.Aliases {
+		alias, err := payee.Aliases[i].validate()
+		if err != nil {
+			return Payee{}, err
+		}
+		payee.Aliases[i] = alias
+	}
+	if payee.Aliases == nil {
+		payee.Aliases = []PayeeAlias{}
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Payee{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, "INSERT INTO payees (name, default_category_id, user_id) VALUES ($1, $2, $3) RETURNING id",
+		payee.Name, payee.DefaultCategoryID, payee.UserID).Scan(&payee.ID)
+	if err != nil {
+		return Payee{}, fmt.Errorf("failed to add payee: %v", err)
+	}
+
+	for i := range payee.Aliases {
+		payee.Aliases[i].PayeeID = payee.ID
+		err := tx.QueryRow(ctx, "INSERT INTO payee_aliases (payee_id, pattern, match_type) VALUES ($1, $2, $3) RETURNING id",
+			payee.ID, payee.Aliases[i].Pattern, payee.Aliases[i].MatchType).Scan(&payee.Aliases[i].ID)
+		if err != nil {
+			return Payee{}, fmt.Errorf("failed to add payee alias: %v", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Payee{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return payee, nil
+}
+
+// UpdatePayee renames a payee or changes its default category
+func UpdatePayee(payee Payee) (Payee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	payee.Name = strings.TrimSpace(payee.Name)
+
+	_, err := db.Exec(ctx, `
+		UPDATE payees
+		SET name = COALESCE(NULLIF($1, ''), name),
+			default_category_id = $2
+		WHERE id::text = $3 AND user_id = $4`,
+		payee.Name, payee.DefaultCategoryID, payee.ID, payee.UserID)
+	if err != nil {
+		return Payee{}, fmt.Errorf("failed to update payee: %v", err)
+	}
+
+	return GetPayee(payee.ID, payee.UserID)
+}
+
+// GetPayee returns a payee of the user with its aliases
+func GetPayee(id string, uid string) (Payee, error) {
+	payees, err := GetPayees(uid)
+	if err != nil {
+		return Payee{}, err
+	}
+	for _, payee := range payees {
+		if payee.ID == id {
+			return payee, nil
+		}
+	}
+	return Payee{}, fmt.Errorf("%w: payee %s", ErrNotFound, id)
+}
+
+// DeletePayee removes a payee, its transactions are kept without payee
+func DeletePayee(id string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, "DELETE FROM payees WHERE id::text = $1 AND user_id = $2", id, uid)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: payee %s", ErrNotFound, id)
+	}
+
+	return nil
+}
+
+// AddPayeeAlias adds a pattern to a payee of the user
+func AddPayeeAlias(alias PayeeAlias, uid string) (PayeeAlias, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	alias, err := alias.validate()
+	if err != nil {
+		return PayeeAlias{}, err
+	}
+
+	err = db.QueryRow(ctx, `
+		INSERT INTO payee_aliases (payee_id, pattern, match_type)
+		SELECT p.id, $2, $3 FROM payees p WHERE p.id::text = $1 AND p.user_id = $4
+		RETURNING id`,
+		alias.PayeeID, alias.Pattern, alias.MatchType, uid).Scan(&alias.ID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return PayeeAlias{}, fmt.Errorf("%w: payee %s", ErrNotFound, alias.PayeeID)
+		}
+		return PayeeAlias{}, fmt.Errorf("failed to add payee alias: %v", err)
+	}
+
+	return alias, nil
+}
+
+// DeletePayeeAlias removes a pattern from a payee of the user
+func DeletePayeeAlias(payeeID string, aliasID string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(ctx, `
+		DELETE FROM payee_aliases a
+		USING payees p
+		WHERE a.payee_id = p.id AND a.id::text = $1 AND p.id::text = $2 AND p.user_id = $3`,
+		aliasID, payeeID, uid)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: alias %s", ErrNotFound, aliasID)
+	}
+
+	return nil
+}
+
+// GetPayeeReport summarizes the transactions of each payee of the user
+// matching the filter, biggest spending first
+func GetPayeeReport(filter TransactionFilter) ([]PayeeSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var args queryArgs
+	rows, err := db.Query(ctx, `
+		SELECT p.id, p.name,
+			-COALESCE(SUM(t.amount) FILTER (WHERE t.transaction_type IN ('Expense', 'Refund')), 0),
+			COALESCE(SUM(t.amount) FILTER (WHERE t.transaction_type = 'Income'), 0),
+			COUNT(*),
+			CASE WHEN COUNT(*) > 1
+				THEN EXTRACT(EPOCH FROM MAX(t.date) - MIN(t.date))::float8 / 86400 / (COUNT(*) - 1)
+			END,
+			MIN(t.date), MAX(t.date),
+			(ARRAY_AGG(t.id::text ORDER BY t.date DESC, t.id DESC))[1],
+			(ARRAY_AGG(t.amount ORDER BY t.date DESC, t.id DESC))[1]
+		FROM transactions t
+		JOIN payees p ON p.id = t.payee_id`+filter.where(&args)+`
+		  AND p.user_id = `+args.add(filter.UserID)+`
+		GROUP BY p.id, p.name
+		ORDER BY 3 DESC, p.name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve payee report: %v", err)
+	}
+	defer rows.Close()
+
+	loc, err := GetUserLocation(filter.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := []PayeeSummary{}
+	for rows.Next() {
+		var summary PayeeSummary
+		err := rows.Scan(
+			&summary.PayeeID,
+			&summary.Name,
+			&summary.TotalSpent,
+			&summary.TotalReceived,
+			&summary.TransactionCount,
+			&summary.AverageIntervalDays,
+			&summary.FirstTransaction,
+			&summary.LastTransaction,
+			&summary.LastTransactionID,
+			&summary.LastAmount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payee row: %v", err)
+		}
+		summary.FirstTransaction = summary.FirstTransaction.In(loc)
+		summary.LastTransaction = summary.LastTransaction.In(loc)
+		report = append(report, summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating payee rows: %v", err)
+	}
+
+	return report, nil
+}
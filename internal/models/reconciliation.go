@@ -0,0 +1,582 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/utils"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"math"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// TransactionTypeAdjustment corrects the balance of an account without
+// being income or spending
+const TransactionTypeAdjustment = "Adjustment"
+
+const (
+	ReconciliationOpen     = "open"
+	ReconciliationFinished = "finished"
+)
+
+// ErrInvalidReconciliation wraps errors caused by reconciliation requests
+var ErrInvalidReconciliation = errors.New("invalid reconciliation")
+
+// ErrReconciled is returned when changing a reconciled transaction without
+// overriding the lock
+var ErrReconciled = errors.New("transaction is reconciled")
+
+// Reconciliation compares an account with a bank statement. Transactions
+// are cleared as they are found on the statement until the cleared balance
+// matches the statement balance.
+type Reconciliation struct {
+	ID                      string              `json:"id"`
+	AccountID               string              `json:"account_id"`
+	StatementDate           timeutils.Timestamp `json:"statement_date"`
+	StatementBalance        float64             `json:"statement_balance"`
+	Status                  string              `json:"status"`
+	ClearedBalance          float64             `json:"cleared_balance"` // Balance of the transactions reconciled before and cleared now
+	Difference              float64             `json:"difference"`      // Statement balance minus the cleared balance
+	ClearedCount            int                 `json:"cleared_count"`
+	AdjustmentTransactionID null.String         `json:"adjustment_transaction_id"`
+	UserID                  string              `json:"user_id"`
+	CreatedAt               timeutils.Timestamp `json:"created_at"`
+	FinishedAt              timeutils.Timestamp `json:"finished_at"`
+
+	Transactions []ReconciliationLine `json:"transactions,omitempty"`
+}
+
+// ReconciliationLine is a transaction not reconciled yet in the account,
+// with its effect on the account's balance
+type ReconciliationLine struct {
+	Transaction
+	Effect  float64 `json:"effect"`
+	Cleared bool    `json:"cleared"`
+}
+
+const reconciliationColumns = `r.id, r.account_id, r.statement_date, r.statement_balance, r.status,
+	COALESCE(r.cleared_balance, 0), (SELECT COUNT(*) FROM reconciliation_items ri WHERE ri.reconciliation_id = r.id),
+	r.adjustment_transaction_id::text, r.user_id, r.created_at, r.finished_at`
+
+func scanReconciliation(row pgx.Row) (Reconciliation, error) {
+	var r Reconciliation
+	err := row.Scan(&r.ID, &r.AccountID, &r.StatementDate, &r.StatementBalance, &r.Status, &r.ClearedBalance,
+		&r.ClearedCount, &r.AdjustmentTransactionID, &r.UserID, &r.CreatedAt, &r.FinishedAt)
+	return r, err
+}
+
+// reconciledInAccount tells whether the transaction aliased as t was
+// cleared in a finished reconciliation of the account given as placeholder.
+// The status of a transfer is reconciled as soon as one of its accounts is.
+func reconciledInAccount(account string) string {
+	return `EXISTS (SELECT 1 FROM reconciliation_items ri
+		JOIN reconciliations r ON r.id = ri.reconciliation_id
+		WHERE ri.transaction_id = t.id AND r.account_id = ` + account + ` AND r.status = 'finished')`
+}
+
+// clearedBalance is the balance of the account counting only the
+// transactions reconciled before and those cleared in the reconciliation.
+// What the transactions don't explain of the balance is an opening balance
+// and counts as cleared.
+func clearedBalance(ctx context.Context, q pgxQuerier, accountID string, reconciliationID string) (float64, error) {
+	var balance float64
+	err := q.QueryRow(ctx, `
+		SELECT a.balance
+			- COALESCE((SELECT SUM(`+balanceEffect("a.id")+`) FROM transactions t
+			            WHERE t.account_id = a.id OR t.related_account_id = a.id), 0)
+			+ COALESCE((SELECT SUM(`+balanceEffect("a.id")+`) FROM transactions t
+			            WHERE (t.account_id = a.id OR t.related_account_id = a.id)
+			              AND (`+reconciledInAccount("a.id")+`
+			               OR t.id IN (SELECT ri.transaction_id FROM reconciliation_items ri WHERE ri.reconciliation_id = $2))), 0)
+		FROM accounts a
+		WHERE a.id = $1`, accountID, reconciliationID).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute cleared balance: %v", err)
+	}
+	return roundCents(balance), nil
+}
+
+// findReconciliation reads a reconciliation of an account, computing the
+// balances of an open one
+func findReconciliation(ctx context.Context, q pgxQuerier, accountID string, id string) (Reconciliation, error) {
+	r, err := scanReconciliation(q.QueryRow(ctx, "SELECT "+reconciliationColumns+
+		" FROM reconciliations r WHERE r.id::text = $1 AND r.account_id = $2", id, accountID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Reconciliation{}, fmt.Errorf("%w: no reconciliation found with ID %s", ErrNotFound, id)
+		}
+		return Reconciliation{}, fmt.Errorf("failed to retrieve reconciliation: %v", err)
+	}
+
+	if r.Status == ReconciliationOpen {
+		if r.ClearedBalance, err = clearedBalance(ctx, q, accountID, r.ID); err != nil {
+			return Reconciliation{}, err
+		}
+	}
+	r.Difference = roundCents(r.StatementBalance - r.ClearedBalance)
+	return r, nil
+}
+
+// GetReconciliations lists the reconciliations of an account, latest first
+func GetReconciliations(accountID string, uid string) ([]Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := GetAccountByID(null.StringFrom(accountID), uid)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, "SELECT "+reconciliationColumns+
+		" FROM reconciliations r WHERE r.account_id = $1 ORDER BY r.statement_date DESC, r.created_at DESC", account.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve reconciliations: %v", err)
+	}
+	reconciliations := []Reconciliation{}
+	for rows.Next() {
+		r, err := scanReconciliation(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan reconciliation: %v", err)
+		}
+		reconciliations = append(reconciliations, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range reconciliations {
+		if reconciliations[i].Status == ReconciliationOpen {
+			reconciliations[i].ClearedBalance, err = clearedBalance(ctx, db, account.ID, reconciliations[i].ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		reconciliations[i].Difference = roundCents(reconciliations[i].StatementBalance - reconciliations[i].ClearedBalance)
+	}
+	return reconciliations, nil
+}
+
+// GetReconciliation returns a reconciliation. An open one comes with the
+// settled transactions left to reconcile up to the statement date, and
+// those cleared after it.
+func GetReconciliation(accountID string, id string, uid string) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := GetAccountByID(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	r, err := findReconciliation(ctx, db, account.ID, id)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+
+	args := []interface{}{account.ID, r.ID}
+	query := "SELECT " + transactionColumns + ", " + balanceEffect("$1") + `,
+			t.id IN (SELECT ri.transaction_id FROM reconciliation_items ri WHERE ri.reconciliation_id = $2)
+		FROM transactions t
+		WHERE (t.account_id = $1 OR t.related_account_id = $1) AND t.deleted_at IS NULL AND `
+	if r.Status == ReconciliationOpen {
+		query += `t.status IN ('cleared', 'reconciled') AND NOT ` + reconciledInAccount("$1") + `
+		  AND (t.date <= $3 OR t.id IN (SELECT ri.transaction_id FROM reconciliation_items ri WHERE ri.reconciliation_id = $2))`
+		args = append(args, r.StatementDate)
+	} else {
+		query += `t.id IN (SELECT ri.transaction_id FROM reconciliation_items ri WHERE ri.reconciliation_id = $2)`
+	}
+	query += " ORDER BY t.date, t.id"
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to retrieve transactions: %v", err)
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	var lines []ReconciliationLine
+	for rows.Next() {
+		var line ReconciliationLine
+		transaction, err := scanTransaction(rows, &line.Effect, &line.Cleared)
+		if err != nil {
+			return Reconciliation{}, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transactions = append(transactions, transaction)
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return Reconciliation{}, err
+	}
+
+	transactions, err = inUserZone(transactions, uid)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	r.Transactions = []ReconciliationLine{}
+	for i := range lines {
+		lines[i].Transaction = transactions[i]
+		r.Transactions = append(r.Transactions, lines[i])
+	}
+
+	return r, nil
+}
+
+// StartReconciliation opens a reconciliation of an account against the
+// balance of a statement. An account has a single open reconciliation.
+func StartReconciliation(accountID string, statementDate timeutils.Timestamp, statementBalance float64, uid string) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := getWritableAccount(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	if statementDate.IsZero() {
+		return Reconciliation{}, fmt.Errorf("%w: statement_date is required", ErrInvalidReconciliation)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM accounts WHERE id = $1 FOR UPDATE", account.ID); err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to lock account: %v", err)
+	}
+
+	var open bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM reconciliations WHERE account_id = $1 AND status = 'open')", account.ID).Scan(&open)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to check reconciliations: %v", err)
+	}
+	if open {
+		return Reconciliation{}, fmt.Errorf("%w: %s already has an open reconciliation, finish or cancel it first", ErrInvalidReconciliation, account.Name)
+	}
+
+	var id string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO reconciliations (account_id, statement_date, statement_balance, status, user_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`, account.ID, statementDate, roundCents(statementBalance), ReconciliationOpen, uid).Scan(&id)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to insert reconciliation: %v", err)
+	}
+
+	r, err := findReconciliation(ctx, tx, account.ID, id)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return r, nil
+}
+
+// getOpenReconciliation locks an open reconciliation of the account
+func getOpenReconciliation(ctx context.Context, tx pgx.Tx, accountID string, id string) (Reconciliation, error) {
+	var status string
+	err := tx.QueryRow(ctx, "SELECT status FROM reconciliations WHERE id::text = $1 AND account_id = $2 FOR UPDATE",
+		id, accountID).Scan(&status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Reconciliation{}, fmt.Errorf("%w: no reconciliation found with ID %s", ErrNotFound, id)
+		}
+		return Reconciliation{}, fmt.Errorf("failed to lock reconciliation: %v", err)
+	}
+	if status != ReconciliationOpen {
+		return Reconciliation{}, fmt.Errorf("%w: the reconciliation is %s", ErrInvalidReconciliation, status)
+	}
+	return findReconciliation(ctx, tx, accountID, id)
+}
+
+// ClearTransactions marks transactions of the account as found on the
+// statement, or unmarks them, and returns the new difference
+func ClearTransactions(accountID string, id string, transactionIDs []string, cleared bool, actor Actor) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	account, err := getWritableAccount(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	if len(transactionIDs) == 0 {
+		return Reconciliation{}, fmt.Errorf("%w: transaction_ids is required", ErrInvalidReconciliation)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	r, err := getOpenReconciliation(ctx, tx, account.ID, id)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+
+	query := "DELETE FROM reconciliation_items WHERE reconciliation_id = $1 AND transaction_id::text = ANY($2) RETURNING transaction_id::text"
+	if cleared {
+		// Only settled transactions of the account not reconciled yet can be cleared
+		var found int
+		err = tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM transactions t
+			WHERE t.id::text = ANY($1) AND (t.account_id = $2 OR t.related_account_id = $2) AND t.deleted_at IS NULL
+			  AND t.status IN ('cleared', 'reconciled') AND NOT `+reconciledInAccount("$2"), transactionIDs, account.ID).Scan(&found)
+		if err != nil {
+			return Reconciliation{}, fmt.Errorf("failed to check transactions: %v", err)
+		}
+		if found != len(uniqueStrings(transactionIDs)) {
+			return Reconciliation{}, fmt.Errorf("%w: only settled, unreconciled transactions of %s can be cleared", ErrInvalidReconciliation, account.Name)
+		}
+
+		query = `
+			INSERT INTO reconciliation_items (reconciliation_id, transaction_id)
+			SELECT $1, t.id FROM transactions t WHERE t.id::text = ANY($2)
+			ON CONFLICT DO NOTHING
+			RETURNING transaction_id::text`
+	}
+	rows, err := tx.Query(ctx, query, r.ID, transactionIDs)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to update cleared transactions: %v", err)
+	}
+	var changed []string
+	for rows.Next() {
+		var transactionID string
+		if err := rows.Scan(&transactionID); err != nil {
+			rows.Close()
+			return Reconciliation{}, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		changed = append(changed, transactionID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to update cleared transactions: %v", err)
+	}
+	if err := auditCleared(ctx, tx, actor, r.ID, cleared, changed); err != nil {
+		return Reconciliation{}, err
+	}
+
+	r, err = findReconciliation(ctx, tx, account.ID, r.ID)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return r, nil
+}
+
+// auditCleared records transactions being cleared on a reconciliation, or
+// uncleared, in the audit log
+func auditCleared(ctx context.Context, tx pgx.Tx, actor Actor, reconciliationID string, cleared bool, ids []string) error {
+	marked := map[string]any{"cleared_in_reconciliation": reconciliationID}
+	unmarked := map[string]any{"cleared_in_reconciliation": nil}
+	before, after := unmarked, marked
+	if !cleared {
+		before, after = marked, unmarked
+	}
+	for _, id := range ids {
+		if err := recordAudit(ctx, tx, actor, AuditTransaction, id, AuditUpdate, before, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// FinishReconciliation locks the cleared transactions. A remaining
+// difference is an error unless adjust is set, then an adjustment
+// transaction for it is posted on the statement date and reconciled too.
+func FinishReconciliation(accountID string, id string, adjust bool, categoryID null.String, actor Actor) (Reconciliation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	account, err := getWritableAccount(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM accounts WHERE id = $1 FOR UPDATE", account.ID); err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to lock account: %v", err)
+	}
+	r, err := getOpenReconciliation(ctx, tx, account.ID, id)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+
+	if math.Abs(r.Difference) >= 0.005 {
+		if !adjust {
+			return Reconciliation{}, fmt.Errorf("%w: a difference of %.2f remains, clear more transactions or finish with an adjustment",
+				ErrInvalidReconciliation, r.Difference)
+		}
+
+		adjustment := Transaction{
+			Description:     "Reconciliation adjustment",
+			Amount:          r.Difference,
+			Currency:        account.Currency,
+			Date:            r.StatementDate,
+			MainCategory:    TransactionTypeAdjustment,
+			Subcategory:     "Reconciliation",
+			CategoryID:      categoryID,
+			AccountID:       null.StringFrom(account.ID),
+			TransactionType: TransactionTypeAdjustment,
+			UserID:          uid,
+		}
+		if categoryID.String != "" {
+			if adjustment.MainCategory, err = GetMainCategory(categoryID.String); err != nil {
+				return Reconciliation{}, fmt.Errorf("%w: %v", ErrInvalidReconciliation, err)
+			}
+			if adjustment.Subcategory, err = GetSubCategory(categoryID.String); err != nil {
+				return Reconciliation{}, fmt.Errorf("%w: %v", ErrInvalidReconciliation, err)
+			}
+		} else {
+			adjustment.CategoryID = null.String{}
+		}
+
+		transactionID, err := postAdjustment(ctx, tx, adjustment, actor)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		r.AdjustmentTransactionID = null.StringFrom(transactionID)
+
+		if _, err := tx.Exec(ctx, "INSERT INTO reconciliation_items (reconciliation_id, transaction_id) VALUES ($1, $2)",
+			r.ID, transactionID); err != nil {
+			return Reconciliation{}, fmt.Errorf("failed to clear adjustment: %v", err)
+		}
+		r.ClearedBalance = r.StatementBalance
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE reconciliations
+		SET status = $2, cleared_balance = $3, adjustment_transaction_id = $4, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, r.ID, ReconciliationFinished, r.ClearedBalance, r.AdjustmentTransactionID)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to finish reconciliation: %v", err)
+	}
+	before, reconciled, err := lockSnapshots(ctx, tx,
+		" WHERE t.id IN (SELECT transaction_id FROM reconciliation_items WHERE reconciliation_id = $1) AND t.deleted_at IS NULL", r.ID)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE transactions SET status = $2
+		WHERE id IN (SELECT transaction_id FROM reconciliation_items WHERE reconciliation_id = $1)`, r.ID, TransactionStatusReconciled)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to lock reconciled transactions: %v", err)
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditUpdate, before, reconciled...); err != nil {
+		return Reconciliation{}, err
+	}
+
+	r, err = findReconciliation(ctx, tx, account.ID, r.ID)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Reconciliation{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return r, nil
+}
+
+// postAdjustment inserts an adjustment transaction the actor made and posts
+// it to the journal
+func postAdjustment(ctx context.Context, tx pgx.Tx, adjustment Transaction, actor Actor) (string, error) {
+	exchangeRate, err := utils.GetExchangeRate(adjustment.Currency)
+	if err != nil {
+		log.Printf("Warning: Exchange rate not found for currency '%s'. Transaction will be saved without conversion.", adjustment.Currency)
+		exchangeRate = 0
+	}
+
+	var id string
+	err = tx.QueryRow(ctx, `
+		INSERT INTO transactions (description, amount, currency, amount_in_base_currency, exchange_rate, date,
+			main_category, subcategory, category_id, account_id, transaction_type, user_id, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id`,
+		adjustment.Description, adjustment.Amount, adjustment.Currency, adjustment.Amount*exchangeRate, exchangeRate,
+		adjustment.Date, adjustment.MainCategory, adjustment.Subcategory, adjustment.CategoryID, adjustment.AccountID,
+		adjustment.TransactionType, adjustment.UserID, adjustment.Notes,
+	).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert adjustment: %v", err)
+	}
+
+	if err := postEntries(ctx, tx, id); err != nil {
+		return "", err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditCreate, nil, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// CancelReconciliation discards an open reconciliation
+func CancelReconciliation(accountID string, id string, uid string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := getWritableAccount(null.StringFrom(accountID), uid)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	r, err := getOpenReconciliation(ctx, tx, account.ID, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM reconciliations WHERE id = $1", r.ID); err != nil {
+		return fmt.Errorf("failed to delete reconciliation: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return nil
+}
+
+// checkNotReconciled refuses to change a reconciled transaction unless the
+// lock is overridden
+func checkNotReconciled(ctx context.Context, q pgxQuerier, transactionID string, override bool) error {
+	if override {
+		return nil
+	}
+	var status string
+	err := q.QueryRow(ctx, "SELECT status FROM transactions WHERE id::text = $1", transactionID).Scan(&status)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("failed to check reconciliation: %v", err)
+	}
+	if status == TransactionStatusReconciled {
+		return fmt.Errorf("%w: override the lock to change it", ErrReconciled)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const TransactionTypeRefund = "Refund"
+
+// ErrInvalidRefund wraps errors caused by a refund not matching its expense
+var ErrInvalidRefund = errors.New("invalid refund")
+
+// refundOriginalDate is the date used for period filters in
+// RefundPeriodOriginal mode, the date of the refunded expense
+const refundOriginalDate = "COALESCE((SELECT o.date FROM transactions o WHERE o.id = t.refund_of_id), t.date)"
+
+// prepareRefund validates a refund against the expense it refunds and
+// inherits the expense's category, account, currency and payee. Refunds are
+// stored as positive amounts. When the expense is split and the refund
+// brings no splits of its own, the expense's splits are applied
+// proportionally.
+func prepareRefund(refund *Transaction) error {
+	if !refund.RefundOfID.Valid || refund.RefundOfID.String == "" {
+		return fmt.Errorf("%w: refund_of is required", ErrInvalidRefund)
+	}
+
+	original, err := GetTransactionByID(refund.RefundOfID.String, refund.UserID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRefund, err)
+	}
+	if original.TransactionType != TransactionTypeExpense {
+		return fmt.Errorf("%w: only expenses can be refunded", ErrInvalidRefund)
+	}
+
+	refund.Amount = math.Abs(refund.Amount)
+	if refund.Amount == 0 {
+		return fmt.Errorf("%w: the refund amount is required", ErrInvalidRefund)
+	}
+
+	refund.CategoryID = original.CategoryID
+	if !refund.AccountID.Valid || refund.AccountID.String == "" {
+		refund.AccountID = original.AccountID
+	}
+	if refund.Currency == "" {
+		refund.Currency = original.Currency
+	}
+	if !refund.PayeeID.Valid {
+		refund.PayeeID = original.PayeeID
+	}
+
+	if len(refund.Splits) == 0 && len(original.Splits) > 0 && original.Amount != 0 {
+		refund.Splits = make([]TransactionSplit, len(original.Splits))
+		var assigned float64
+		for i, split := range original.Splits {
+			amount := math.Round(refund.Amount*split.Amount/original.Amount*100) / 100
+			if i == len(original.Splits)-1 {
+				// The last line takes the rounding difference
+				amount = math.Round((refund.Amount-assigned)*100) / 100
+			}
+			assigned += amount
+			refund.Splits[i] = TransactionSplit{
+				Amount:     amount,
+				CategoryID: split.CategoryID,
+				Note:       split.Note,
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRefundLimit locks the refunded expense and ensures its refunds,
+// excluding the refund being updated, don't exceed its amount
+func checkRefundLimit(ctx context.Context, tx pgx.Tx, refund Transaction, excludeID string) error {
+	var originalAmount float64
+	err := tx.QueryRow(ctx, "SELECT t.amount FROM transactions t WHERE t.id = $1 AND "+visibleTransaction("$2")+" FOR UPDATE OF t",
+		refund.RefundOfID, refund.UserID).Scan(&originalAmount)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("%w: the refunded expense does not exist", ErrInvalidRefund)
+		}
+		return fmt.Errorf("failed to lock refunded expense: %v", err)
+	}
+
+	refunded, err := refundedAmount(ctx, tx, refund.RefundOfID.String, excludeID)
+	if err != nil {
+		return err
+	}
+
+	if refunded+refund.Amount > math.Abs(originalAmount)+splitTolerance {
+		return fmt.Errorf("%w: %.2f is already refunded, at most %.2f can still be refunded",
+			ErrInvalidRefund, refunded, math.Max(math.Abs(originalAmount)-refunded, 0))
+	}
+	return nil
+}
+
+// refundedAmount sums the refunds of an expense, excluding one of them
+func refundedAmount(ctx context.Context, tx pgx.Tx, expenseID string, excludeID string) (float64, error) {
+	var refunded float64
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM transactions
+		WHERE refund_of_id = $1 AND transaction_type = $2 AND id::text <> $3 AND status <> 'void'
+		  AND deleted_at IS NULL`,
+		expenseID, TransactionTypeRefund, excludeID).Scan(&refunded)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum refunds: %v", err)
+	}
+	return refunded, nil
+}
+
+// spendingFilter returns the filter used by spending reports for a period,
+// placing refunds according to the user's refund period setting. Reports
+// cover the user's personal accounts, or the accounts of a household when
+// one is given, except the accounts excluded from budgets.
+func spendingFilter(start time.Time, end time.Time, tags []string, household string, uid string) (TransactionFilter, error) {
+	settings, err := GetUserSettings(uid)
+	if err != nil {
+		return TransactionFilter{}, err
+	}
+
+	if household != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := householdRole(ctx, db, household, uid); err != nil {
+			return TransactionFilter{}, err
+		}
+	}
+
+	return TransactionFilter{
+		UserID:                  uid,
+		HouseholdID:             household,
+		Personal:                household == "",
+		Budgeted:                true,
+		From:                    start,
+		To:                      end,
+		Tags:                    tags,
+		RefundsInOriginalPeriod: settings.RefundPeriod == RefundPeriodOriginal,
+	}, nil
+}
+
+// GetRefunds lists the refunds of an expense
+func GetRefunds(expenseID string, uid string) ([]Transaction, error) {
+	return FindTransactions(TransactionFilter{UserID: uid, RefundOfID: expenseID}, 0)
+}
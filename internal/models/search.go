@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+)
+
+// escapedDescription is the description of the transaction aliased as t with
+// the HTML special characters escaped, so that the highlight markers are the
+// only markup of a headline
+const escapedDescription = `replace(replace(replace(replace(t.description,
+	'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;')`
+
+type SearchResult struct {
+	Transaction Transaction `json:"transaction"`
+	Rank        float64     `json:"rank"`
+	Highlight   string      `json:"highlight"` // HTML escaped description with the matches wrapped in <mark>
+}
+
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	TotalCount int            `json:"total_count"`
+}
+
+// searchQuery turns free text into a prefix tsquery: "vet clin" becomes
+// "vet:* & clin:*". Anything but letters and digits separates words.
+func searchQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, strings.ToLower(word)+":*")
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+// SearchTransactions ranks the transactions matching text that also pass the filter
+func SearchTransactions(text string, filter TransactionFilter, limit int, offset int) (SearchPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tsquery := searchQuery(text)
+	if tsquery == "" {
+		return SearchPage{}, fmt.Errorf("%w: search text has no words", ErrInvalidQuery)
+	}
+
+	if limit <= 0 {
+		limit = DefaultTransactionLimit
+	}
+	if limit > MaxTransactionLimit {
+		limit = MaxTransactionLimit
+	}
+
+	var page SearchPage
+
+	var countArgs queryArgs
+	from := " FROM transactions t, to_tsquery('guilliman_search', " + countArgs.add(tsquery) + ") q"
+	countQuery := "SELECT COUNT(*)" + from + filter.where(&countArgs) + " AND t.search_vector @@ q"
+	if err := db.QueryRow(ctx, countQuery, countArgs...).Scan(&page.TotalCount); err != nil {
+		return SearchPage{}, fmt.Errorf("failed to count search results: %v", err)
+	}
+
+	var args queryArgs
+	from = " FROM transactions t, to_tsquery('guilliman_search', " + args.add(tsquery) + ") q"
+	headlineOptions := args.add(fmt.Sprintf("StartSel=%s, StopSel=%s, HighlightAll=true", highlightStart, highlightStop))
+	query := "SELECT " + transactionColumns +
+		", ts_rank(t.search_vector, q) AS rank" +
+		", ts_headline('guilliman_search', " + escapedDescription + ", q, " + headlineOptions + ")" +
+		from + filter.where(&args) + " AND t.search_vector @@ q" +
+		" ORDER BY rank DESC, t.date DESC, t.id" +
+		" LIMIT " + args.add(limit) + " OFFSET " + args.add(offset)
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return SearchPage{}, fmt.Errorf("failed to search transactions: %v", err)
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	page.Results = []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		var rank float32
+		transaction, err := scanTransaction(rows, &rank, &result.Highlight)
+		if err != nil {
+			return SearchPage{}, fmt.Errorf("failed to scan search result: %v", err)
+		}
+		result.Rank = float64(rank)
+		page.Results = append(page.Results, result)
+		transactions = append(transactions, transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return SearchPage{}, fmt.Errorf("error occurred during rows iteration: %v", err)
+	}
+
+	transactions, err = inUserZone(transactions, filter.UserID)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	for i := range transactions {
+		page.Results[i].Transaction = transactions[i]
+	}
+
+	return page, nil
+}
@@ -0,0 +1,262 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const dateLayout = "2006-01-02"
+
+const (
+	RefundPeriodRefund   = "refund"   // Refunds reduce spending in the period they happen
+	RefundPeriodOriginal = "original" // Refunds reduce spending in the period of the refunded expense
+)
+
+const (
+	DefaultPendingExpiryDays = 14
+	maxPendingExpiryDays     = 365
+
+	DefaultTrashRetentionDays = 30
+	maxTrashRetentionDays     = 365
+)
+
+// UserSettings holds per-user preferences stored server side
+type UserSettings struct {
+	UserID        string      `json:"user_id"`
+	PayCycle      string      `json:"pay_cycle"`       // "monthly", "last_business_day", "biweekly" or "weekly"
+	PayDay        int         `json:"pay_day"`         // Day of the month for monthly cycles
+	PayAnchorDate null.String `json:"pay_anchor_date"` // Any known payday (YYYY-MM-DD) for biweekly cycles
+	PayWeekday    int         `json:"pay_weekday"`     // 0 (Sunday) to 6 (Saturday) for weekly cycles
+
+	HolidayCountry   null.String `json:"holiday_country"`    // Built-in holiday calendar (e.g. "SE"), uploaded calendars are always used
+	PayDayAdjustment string      `json:"pay_day_adjustment"` // "none", "previous" or "next" business day
+
+	DueDateAdjustment string `json:"due_date_adjustment"` // Same for card due dates and loan payment dates
+
+	TimeZone string `json:"time_zone"` // IANA name (e.g. "Europe/Stockholm") used for periods and reports
+
+	RefundPeriod string `json:"refund_period"` // "refund" or "original", see RefundPeriodRefund
+
+	PendingExpiryDays int `json:"pending_expiry_days"` // Pending transactions older than this are voided, 0 keeps them
+
+	TrashRetentionDays int `json:"trash_retention_days"` // Deleted transactions are purged from the trash after this
+}
+
+func DefaultUserSettings(uid string) UserSettings {
+	return UserSettings{
+		UserID:     uid,
+		PayCycle:   timeutils.PayCycleMonthly,
+		PayDay:     timeutils.DefaultPayDay,
+		PayWeekday: int(time.Friday),
+
+		PayDayAdjustment: timeutils.AdjustNone,
+
+		DueDateAdjustment: timeutils.AdjustNone,
+
+		TimeZone: "UTC",
+
+		RefundPeriod: RefundPeriodRefund,
+
+		PendingExpiryDays: DefaultPendingExpiryDays,
+
+		TrashRetentionDays: DefaultTrashRetentionDays,
+	}
+}
+
+// Validate checks the pay cycle and the time zone
+func (s UserSettings) Validate() error {
+	if _, err := s.Cycle(); err != nil {
+		return err
+	}
+	if _, err := s.Location(); err != nil {
+		return err
+	}
+	if err := timeutils.ValidateAdjustment(s.DueDateAdjustment); err != nil {
+		return fmt.Errorf("invalid due_date_adjustment: %v", err)
+	}
+	if s.RefundPeriod != RefundPeriodRefund && s.RefundPeriod != RefundPeriodOriginal {
+		return fmt.Errorf("unknown refund period '%s', use refund or original", s.RefundPeriod)
+	}
+	if s.PendingExpiryDays < 0 || s.PendingExpiryDays > maxPendingExpiryDays {
+		return fmt.Errorf("pending_expiry_days must be between 0 and %d", maxPendingExpiryDays)
+	}
+	if s.TrashRetentionDays < 1 || s.TrashRetentionDays > maxTrashRetentionDays {
+		return fmt.Errorf("trash_retention_days must be between 1 and %d", maxTrashRetentionDays)
+	}
+	return nil
+}
+
+// Location loads the user's time zone
+func (s UserSettings) Location() (*time.Location, error) {
+	if s.TimeZone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s'", s.TimeZone)
+	}
+	return loc, nil
+}
+
+// Cycle converts the stored settings into a timeutils.PayCycle. The holiday
+// calendar is not loaded, see GetPayPeriod.
+func (s UserSettings) Cycle() (timeutils.PayCycle, error) {
+	cycle := timeutils.PayCycle{
+		Type:       s.PayCycle,
+		Day:        s.PayDay,
+		Weekday:    time.Weekday(s.PayWeekday),
+		Adjustment: s.PayDayAdjustment,
+	}
+
+	if s.HolidayCountry.Valid && s.HolidayCountry.String != "" {
+		if _, err := timeutils.CountryCalendar(s.HolidayCountry.String); err != nil {
+			return timeutils.PayCycle{}, err
+		}
+	}
+
+	if s.PayAnchorDate.Valid && s.PayAnchorDate.String != "" {
+		anchor, err := time.Parse(dateLayout, s.PayAnchorDate.String)
+		if err != nil {
+			return timeutils.PayCycle{}, fmt.Errorf("invalid pay anchor date, use YYYY-MM-DD: %v", err)
+		}
+		cycle.AnchorDate = anchor
+	}
+
+	if err := cycle.Validate(); err != nil {
+		return timeutils.PayCycle{}, err
+	}
+
+	return cycle, nil
+}
+
+// GetUserSettings returns the stored settings or the defaults when the user has none
+func GetUserSettings(uid string) (UserSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	settings := DefaultUserSettings(uid)
+
+	var anchor *time.Time
+	err := db.QueryRow(ctx, `
+		SELECT pay_cycle, pay_day, pay_anchor_date, pay_weekday, holiday_country, pay_day_adjustment, time_zone, refund_period, pending_expiry_days,
+			trash_retention_days, due_date_adjustment
+		FROM user_settings
+		WHERE user_id = $1`, uid).Scan(
+		&settings.PayCycle,
+		&settings.PayDay,
+		&anchor,
+		&settings.PayWeekday,
+		&settings.HolidayCountry,
+		&settings.PayDayAdjustment,
+		&settings.TimeZone,
+		&settings.RefundPeriod,
+		&settings.PendingExpiryDays,
+		&settings.TrashRetentionDays,
+		&settings.DueDateAdjustment,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return DefaultUserSettings(uid), nil
+		}
+		return UserSettings{}, fmt.Errorf("failed to retrieve user settings: %v", err)
+	}
+
+	if anchor != nil {
+		settings.PayAnchorDate = null.StringFrom(anchor.Format(dateLayout))
+	}
+
+	return settings, nil
+}
+
+// UpdateUserSettings validates and stores the settings of a user
+func UpdateUserSettings(settings UserSettings) (UserSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := settings.Validate(); err != nil {
+		return UserSettings{}, err
+	}
+
+	var anchor *string
+	if settings.PayAnchorDate.Valid && settings.PayAnchorDate.String != "" {
+		anchor = &settings.PayAnchorDate.String
+	}
+
+	_, err := db.Exec(ctx, `
+		INSERT INTO user_settings (user_id, pay_cycle, pay_day, pay_anchor_date, pay_weekday, holiday_country, pay_day_adjustment, time_zone, refund_period, pending_expiry_days,
+			trash_retention_days, due_date_adjustment)
+		VALUES ($1, $2, $3, $4, $5, UPPER(NULLIF($6, '')), $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (user_id) DO UPDATE SET
+			pay_cycle = EXCLUDED.pay_cycle,
+			pay_day = EXCLUDED.pay_day,
+			pay_anchor_date = EXCLUDED.pay_anchor_date,
+			pay_weekday = EXCLUDED.pay_weekday,
+			holiday_country = EXCLUDED.holiday_country,
+			pay_day_adjustment = EXCLUDED.pay_day_adjustment,
+			time_zone = EXCLUDED.time_zone,
+			refund_period = EXCLUDED.refund_period,
+			pending_expiry_days = EXCLUDED.pending_expiry_days,
+			trash_retention_days = EXCLUDED.trash_retention_days,
+			due_date_adjustment = EXCLUDED.due_date_adjustment,
+			updated_at = CURRENT_TIMESTAMP`,
+		settings.UserID,
+		settings.PayCycle,
+		settings.PayDay,
+		anchor,
+		settings.PayWeekday,
+		settings.HolidayCountry,
+		settings.PayDayAdjustment,
+		settings.TimeZone,
+		settings.RefundPeriod,
+		settings.PendingExpiryDays,
+		settings.TrashRetentionDays,
+		settings.DueDateAdjustment,
+	)
+	if err != nil {
+		return UserSettings{}, fmt.Errorf("failed to update user settings: %v", err)
+	}
+
+	return settings, nil
+}
+
+// GetUserLocation returns the time zone of a user
+func GetUserLocation(uid string) (*time.Location, error) {
+	settings, err := GetUserSettings(uid)
+	if err != nil {
+		return nil, err
+	}
+	return settings.Location()
+}
+
+// GetPayPeriod returns the boundaries of the user's pay period containing ref,
+// shifted by index periods. Boundaries are midnights in the user's time zone.
+func GetPayPeriod(uid string, ref time.Time, index int) (time.Time, time.Time, error) {
+	settings, err := GetUserSettings(uid)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	loc, err := settings.Location()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	ref = ref.In(loc)
+
+	cycle, err := settings.Cycle()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	cycle.Calendar, err = GetHolidayCalendar(settings)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	start, end := timeutils.PeriodRange(cycle, ref, index)
+	return start, end, nil
+}
@@ -0,0 +1,604 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ShareEqual      = "equal"
+	SharePercentage = "percentage"
+	ShareExact      = "exact"
+)
+
+const TransactionTypeSettlement = "Settlement"
+
+// ErrInvalidShare wraps errors caused by shares sent by the client
+var ErrInvalidShare = errors.New("invalid share")
+
+// SharedExpense is an expense paid by one user and split between several.
+// Shares are positive amounts, the payer's own share included.
+type SharedExpense struct {
+	ID            string              `json:"id"`
+	TransactionID string              `json:"transaction_id"`
+	Description   string              `json:"description"`
+	Amount        float64             `json:"amount"` // Total paid, positive
+	Currency      string              `json:"currency"`
+	Date          timeutils.Timestamp `json:"date"`
+	PayerID       string              `json:"payer_id"`
+	Method        string              `json:"method"` // "equal", "percentage" or "exact"
+	Shares        []Share             `json:"shares"`
+}
+
+// Share is the part of a shared expense owed by a participant. Requests
+// identify participants by user_id or email, percentage is used by the
+// percentage method and amount by the exact method.
+type Share struct {
+	UserID      string     `json:"user_id"`
+	Email       string     `json:"email,omitempty"`
+	DisplayName string     `json:"display_name"`
+	Amount      float64    `json:"amount"`
+	Percentage  null.Float `json:"percentage"`
+}
+
+// Balance is what a counterpart owes the user, negative when the user owes them
+type Balance struct {
+	UserID      string  `json:"user_id"`
+	Email       string  `json:"email"`
+	DisplayName string  `json:"display_name"`
+	Amount      float64 `json:"amount"`
+}
+
+// Settlement is a reimbursement between two users
+type Settlement struct {
+	ID            string              `json:"id"`
+	FromUserID    string              `json:"from_user_id"`
+	ToUserID      string              `json:"to_user_id"`
+	Amount        float64             `json:"amount"`
+	TransactionID string              `json:"transaction_id"` // Recorded in the account of the user settling up
+	CreatedAt     timeutils.Timestamp `json:"created_at"`
+}
+
+// payerShareFactor is the part of a transaction borne by its owner, below 1
+// when the owner shared it with other users. Refunds are borne as the
+// expense they refund.
+const payerShareFactor = `COALESCE((
+		SELECT sh.amount / NULLIF(ABS(o.amount), 0)
+		FROM transactions o
+		JOIN shared_expenses se ON se.transaction_id = o.id
+		JOIN shared_expense_shares sh ON sh.shared_expense_id = se.id
+		WHERE o.id = COALESCE(t.refund_of_id, t.id) AND sh.user_id = t.user_id), 1)`
+
+// householdPeers is the condition matching the users, aliased as u, who
+// share a household with $3, the user themselves included
+const householdPeers = `(u.id = $3 OR EXISTS (
+		SELECT 1 FROM household_members m JOIN household_members p ON p.household_id = m.household_id
+		WHERE m.user_id = u.id AND p.user_id = $3))`
+
+// counterparts is the condition matching the users, aliased as u, $3 shares
+// expenses or settlements with, who may have left their households since
+const counterparts = `EXISTS (
+		SELECT 1 FROM shared_expense_shares sh JOIN shared_expenses se ON se.id = sh.shared_expense_id
+		WHERE (se.payer_id = $3 AND sh.user_id = u.id) OR (se.payer_id = u.id AND sh.user_id = $3)
+		UNION ALL
+		SELECT 1 FROM settlements st
+		WHERE (st.from_user_id = $3 AND st.to_user_id = u.id) OR (st.from_user_id = u.id AND st.to_user_id = $3))`
+
+// resolveUser finds, by id or email, a user matching a condition on the
+// users related to uid. Users that don't exist and those that don't match
+// get the same error, so that callers can't find out who is registered.
+func resolveUser(ctx context.Context, uid string, related string, userID string, email string) (string, error) {
+	var id string
+	err := db.QueryRow(ctx, "SELECT u.id FROM users u WHERE (u.id = $1 OR ($1 = '' AND LOWER(u.email) = LOWER($2))) AND "+related,
+		userID, strings.TrimSpace(email), uid).Scan(&id)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", fmt.Errorf("%w: no user you can share expenses with has id '%s' or email '%s'", ErrInvalidShare, userID, email)
+		}
+		return "", fmt.Errorf("failed to retrieve user: %v", err)
+	}
+	return id, nil
+}
+
+// computeShares resolves the participants, who must share a household with
+// the payer, and turns the split method into amounts in cents adding up to the
+// total. The payer is added with the rest of the expense when not listed.
+func computeShares(ctx context.Context, total float64, payerID string, method string, participants []Share) ([]Share, error) {
+	shares := []Share{}
+	seen := map[string]bool{}
+	for _, participant := range participants {
+		id, err := resolveUser(ctx, payerID, householdPeers, participant.UserID, participant.Email)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("%w: user %s is listed twice", ErrInvalidShare, id)
+		}
+		seen[id] = true
+		participant.UserID = id
+		participant.Email = ""
+		shares = append(shares, participant)
+	}
+
+	payerListed := seen[payerID]
+	if !payerListed {
+		shares = append([]Share{{UserID: payerID}}, shares...)
+	}
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: share the expense with at least one other user", ErrInvalidShare)
+	}
+
+	totalCents := int64(math.Round(total * 100))
+	cents := make([]int64, len(shares))
+
+	switch method {
+	case ShareEqual:
+		each, rest := totalCents/int64(len(shares)), totalCents%int64(len(shares))
+		for i := range shares {
+			cents[i] = each
+			if int64(i) < rest {
+				cents[i]++
+			}
+		}
+	case SharePercentage:
+		var sum float64
+		for i, share := range shares {
+			if i == 0 && !payerListed {
+				continue
+			}
+			if !share.Percentage.Valid || share.Percentage.Float64 < 0 {
+				return nil, fmt.Errorf("%w: every participant needs a percentage", ErrInvalidShare)
+			}
+			sum += share.Percentage.Float64
+		}
+		if !payerListed {
+			shares[0].Percentage = null.FloatFrom(100 - sum)
+			sum = 100
+		}
+		if math.Abs(sum-100) > 0.001 || shares[0].Percentage.Float64 < 0 {
+			return nil, fmt.Errorf("%w: percentages must add up to 100", ErrInvalidShare)
+		}
+		var assigned int64
+		for i, share := range shares {
+			cents[i] = int64(math.Round(float64(totalCents) * share.Percentage.Float64 / 100))
+			assigned += cents[i]
+		}
+		// The payer absorbs the rounding difference
+		cents[0] += totalCents - assigned
+	case ShareExact:
+		var assigned int64
+		for i, share := range shares {
+			if i == 0 && !payerListed {
+				continue
+			}
+			if share.Amount < 0 {
+				return nil, fmt.Errorf("%w: shares can't be negative", ErrInvalidShare)
+			}
+			cents[i] = int64(math.Round(share.Amount * 100))
+			assigned += cents[i]
+		}
+		if !payerListed {
+			cents[0] = totalCents - assigned
+			assigned = totalCents
+		}
+		if assigned != totalCents || cents[0] < 0 {
+			return nil, fmt.Errorf("%w: shares add up to %.2f but the expense is %.2f", ErrInvalidShare, float64(assigned)/100, total)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown method '%s', use equal, percentage or exact", ErrInvalidShare, method)
+	}
+
+	for i := range shares {
+		shares[i].Amount = float64(cents[i]) / 100
+		if method != SharePercentage {
+			shares[i].Percentage = null.Float{}
+		}
+	}
+	return shares, nil
+}
+
+// ShareExpense splits an expense of the payer between users, replacing any
+// previous split of the same expense
+func ShareExpense(transactionID string, payer Actor, method string, participants []Share) (SharedExpense, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	payerID := payer.UserID
+
+	transaction, err := GetTransactionByID(transactionID, payerID)
+	if err != nil {
+		return SharedExpense{}, fmt.Errorf("%w: transaction %s", ErrNotFound, transactionID)
+	}
+	if transaction.UserID != payerID {
+		return SharedExpense{}, fmt.Errorf("%w: only the member who recorded the expense can share it", ErrInvalidShare)
+	}
+	if transaction.TransactionType != TransactionTypeExpense {
+		return SharedExpense{}, fmt.Errorf("%w: only expenses can be shared", ErrInvalidShare)
+	}
+
+	shares, err := computeShares(ctx, math.Abs(transaction.Amount), payerID, method, participants)
+	if err != nil {
+		return SharedExpense{}, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return SharedExpense{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := sharedExpenseSnapshot(ctx, tx, transaction.ID)
+	if err != nil {
+		return SharedExpense{}, err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM shared_expenses WHERE transaction_id = $1", transactionID); err != nil {
+		return SharedExpense{}, fmt.Errorf("failed to replace shared expense: %v", err)
+	}
+
+	var id string
+	err = tx.QueryRow(ctx, "INSERT INTO shared_expenses (transaction_id, payer_id, method) VALUES ($1, $2, $3) RETURNING id",
+		transactionID, payerID, method).Scan(&id)
+	if err != nil {
+		return SharedExpense{}, fmt.Errorf("failed to insert shared expense: %v", err)
+	}
+
+	for _, share := range shares {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO shared_expense_shares (shared_expense_id, user_id, amount, percentage)
+			VALUES ($1, $2, $3, $4)`,
+			id, share.UserID, share.Amount, share.Percentage)
+		if err != nil {
+			return SharedExpense{}, fmt.Errorf("failed to insert share: %v", err)
+		}
+	}
+	if err := auditSharing(ctx, tx, payer, transaction.ID, before); err != nil {
+		return SharedExpense{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return SharedExpense{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return GetSharedExpense(transactionID, payerID)
+}
+
+// UnshareExpense makes an expense of the payer fully theirs again
+func UnshareExpense(transactionID string, payer Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := sharedExpenseSnapshot(ctx, tx, transactionID)
+	if err != nil {
+		return err
+	}
+	if before == nil || before.PayerID != payer.UserID {
+		return fmt.Errorf("%w: shared expense %s", ErrNotFound, transactionID)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM shared_expenses WHERE id = $1", before.ID); err != nil {
+		return fmt.Errorf("failed to delete shared expense: %v", err)
+	}
+	if err := auditSharing(ctx, tx, payer, before.TransactionID, before); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return nil
+}
+
+// sharedExpenseSnapshot reads the split of an expense in a database
+// transaction, locking the expense, for the audit log. It is nil when the
+// expense isn't shared.
+func sharedExpenseSnapshot(ctx context.Context, tx pgx.Tx, transactionID string) (*SharedExpense, error) {
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM transactions WHERE id::text = $1 FOR UPDATE", transactionID); err != nil {
+		return nil, fmt.Errorf("failed to lock transaction: %v", err)
+	}
+	expense, err := scanSharedExpense(tx.QueryRow(ctx, sharedExpenseQuery+" WHERE t.id::text = $1", transactionID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve shared expense: %v", err)
+	}
+	return &expense, nil
+}
+
+// auditSharing records a change to the split of an expense as an update of
+// its transaction, comparing the split before the change with the current one
+func auditSharing(ctx context.Context, tx pgx.Tx, actor Actor, transactionID string, before *SharedExpense) error {
+	after, err := sharedExpenseSnapshot(ctx, tx, transactionID)
+	if err != nil {
+		return err
+	}
+	return recordAudit(ctx, tx, actor, AuditTransaction, transactionID, AuditUpdate,
+		map[string]any{"shared_expense": before}, map[string]any{"shared_expense": after})
+}
+
+const sharedExpenseQuery = `
+	SELECT se.id, t.id, t.description, ABS(t.amount), t.currency, t.date, se.payer_id, se.method,
+		(SELECT json_agg(json_build_object(
+			'user_id', sh.user_id, 'display_name', COALESCE(u.display_name, ''),
+			'amount', sh.amount, 'percentage', sh.percentage
+		) ORDER BY sh.user_id <> se.payer_id, u.display_name)
+		FROM shared_expense_shares sh LEFT JOIN users u ON u.id = sh.user_id
+		WHERE sh.shared_expense_id = se.id)
+	FROM shared_expenses se
+	JOIN transactions t ON t.id = se.transaction_id`
+
+func scanSharedExpense(row pgx.Row) (SharedExpense, error) {
+	var expense SharedExpense
+	err := row.Scan(
+		&expense.ID,
+		&expense.TransactionID,
+		&expense.Description,
+		&expense.Amount,
+		&expense.Currency,
+		&expense.Date,
+		&expense.PayerID,
+		&expense.Method,
+		&expense.Shares,
+	)
+	return expense, err
+}
+
+// GetSharedExpense returns the split of an expense the user takes part in
+func GetSharedExpense(transactionID string, uid string) (SharedExpense, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	expense, err := scanSharedExpense(db.QueryRow(ctx, sharedExpenseQuery+`
+		WHERE t.id::text = $1
+		  AND EXISTS (SELECT 1 FROM shared_expense_shares p WHERE p.shared_expense_id = se.id AND p.user_id = $2)`,
+		transactionID, uid))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return SharedExpense{}, fmt.Errorf("%w: shared expense %s", ErrNotFound, transactionID)
+		}
+		return SharedExpense{}, fmt.Errorf("failed to retrieve shared expense: %v", err)
+	}
+
+	loc, err := GetUserLocation(uid)
+	if err != nil {
+		return SharedExpense{}, err
+	}
+	expense.Date = expense.Date.In(loc)
+
+	return expense, nil
+}
+
+// GetSharedExpenses lists the shared expenses the user takes part in, newest first
+func GetSharedExpenses(uid string) ([]SharedExpense, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, sharedExpenseQuery+`
+		WHERE EXISTS (SELECT 1 FROM shared_expense_shares p WHERE p.shared_expense_id = se.id AND p.user_id = $1)
+		ORDER BY t.date DESC, t.id DESC`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve shared expenses: %v", err)
+	}
+	defer rows.Close()
+
+	loc, err := GetUserLocation(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	expenses := []SharedExpense{}
+	for rows.Next() {
+		expense, err := scanSharedExpense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan shared expense: %v", err)
+		}
+		expense.Date = expense.Date.In(loc)
+		expenses = append(expenses, expense)
+	}
+
+	return expenses, rows.Err()
+}
+
+// balancesQuery computes what each counterpart owes $1: their shares of
+// expenses paid by $1, minus the shares of $1 in expenses they paid, minus
+// what they reimbursed, plus what $1 reimbursed them
+const balancesQuery = `
+	WITH movements AS (
+		SELECT sh.user_id AS counterpart, sh.amount
+		FROM shared_expense_shares sh JOIN shared_expenses se ON se.id = sh.shared_expense_id
+		WHERE se.payer_id = $1 AND sh.user_id <> $1
+		UNION ALL
+		SELECT se.payer_id, -sh.amount
+		FROM shared_expense_shares sh JOIN shared_expenses se ON se.id = sh.shared_expense_id
+		WHERE sh.user_id = $1 AND se.payer_id <> $1
+		UNION ALL
+		SELECT from_user_id, -amount FROM settlements WHERE to_user_id = $1
+		UNION ALL
+		SELECT to_user_id, amount FROM settlements WHERE from_user_id = $1
+	)
+	SELECT m.counterpart, COALESCE(u.email, ''), COALESCE(u.display_name, ''), ROUND(SUM(m.amount)::numeric, 2)::float8
+	FROM movements m LEFT JOIN users u ON u.id = m.counterpart`
+
+// GetBalances returns the non zero balances of the user with every counterpart
+func GetBalances(uid string) ([]Balance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, balancesQuery+`
+		GROUP BY m.counterpart, u.email, u.display_name
+		HAVING ROUND(SUM(m.amount)::numeric, 2) <> 0
+		ORDER BY 4 DESC`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve balances: %v", err)
+	}
+	defer rows.Close()
+
+	balances := []Balance{}
+	for rows.Next() {
+		var balance Balance
+		if err := rows.Scan(&balance.UserID, &balance.Email, &balance.DisplayName, &balance.Amount); err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
+
+// SettleUp records a reimbursement with a counterpart in one of the user's
+// accounts: money leaves the account when the user owes the counterpart and
+// comes in when the counterpart owes the user. A zero amount settles the
+// whole balance. Counterparts who left the user's households can still be
+// settled with.
+func SettleUp(actor Actor, counterpartID string, counterpartEmail string, accountID string, amount float64) (Settlement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	counterpart, err := resolveUser(ctx, uid, "("+householdPeers+" OR "+counterparts+")", counterpartID, counterpartEmail)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	account, err := getWritableAccount(null.StringFrom(accountID), uid)
+	if err != nil {
+		return Settlement{}, fmt.Errorf("%w: invalid account: %v", ErrInvalidShare, err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Settlement{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize settlements of the same pair so the balance can't be settled twice
+	pair := []string{uid, counterpart}
+	if pair[0] > pair[1] {
+		pair[0], pair[1] = pair[1], pair[0]
+	}
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "settle:"+pair[0]+":"+pair[1]); err != nil {
+		return Settlement{}, fmt.Errorf("failed to lock balance: %v", err)
+	}
+
+	var balance float64
+	err = tx.QueryRow(ctx, balancesQuery+`
+		WHERE m.counterpart = $2
+		GROUP BY m.counterpart, u.email, u.display_name`, uid, counterpart).Scan(new(string), new(string), new(string), &balance)
+	if err != nil && err != pgx.ErrNoRows {
+		return Settlement{}, fmt.Errorf("failed to retrieve balance: %v", err)
+	}
+	if balance == 0 {
+		return Settlement{}, fmt.Errorf("%w: nothing to settle with this user", ErrInvalidShare)
+	}
+
+	amount = math.Abs(amount)
+	if amount == 0 {
+		amount = math.Abs(balance)
+	}
+	if amount > math.Abs(balance)+splitTolerance {
+		return Settlement{}, fmt.Errorf("%w: the balance is only %.2f", ErrInvalidShare, math.Abs(balance))
+	}
+
+	settlement := Settlement{FromUserID: uid, ToUserID: counterpart, Amount: amount}
+	signed, description := -amount, "Settle up"
+	if balance > 0 {
+		// The counterpart pays the user
+		settlement.FromUserID, settlement.ToUserID = counterpart, uid
+		signed = amount
+	}
+
+	var name string
+	if err := tx.QueryRow(ctx, "SELECT COALESCE(NULLIF(display_name, ''), email) FROM users WHERE id = $1", counterpart).Scan(&name); err == nil && name != "" {
+		description += " with " + name
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO transactions (description, amount, currency, amount_in_base_currency, exchange_rate, date,
+			main_category, subcategory, account_id, transaction_type, user_id)
+		VALUES ($1, $2, $3, 0, 0, $4, $5, 'Settlement', $6, $7, $8)
+		RETURNING id`,
+		description, signed, account.Currency, time.Now(), MainCategoryTransfer, account.ID, TransactionTypeSettlement, uid,
+	).Scan(&settlement.TransactionID)
+	if err != nil {
+		return Settlement{}, fmt.Errorf("failed to insert settlement transaction: %v", err)
+	}
+
+	if err := postEntries(ctx, tx, settlement.TransactionID); err != nil {
+		return Settlement{}, err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditCreate, nil, settlement.TransactionID); err != nil {
+		return Settlement{}, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO settlements (from_user_id, to_user_id, amount, transaction_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		settlement.FromUserID, settlement.ToUserID, settlement.Amount, settlement.TransactionID,
+	).Scan(&settlement.ID, &settlement.CreatedAt)
+	if err != nil {
+		return Settlement{}, fmt.Errorf("failed to insert settlement: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Settlement{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return settlement, nil
+}
+
+// sharedSpendingLines are the shares of the user in expenses paid by others
+// during the period, by category of the expense line. Amounts are negative
+// like expenses. They are left out when filtering by tags since tags belong
+// to the payer, and from household reports since shares are personal.
+func sharedSpendingLines(ctx context.Context, filter TransactionFilter) ([]CategorySpending, error) {
+	if len(filter.Tags) > 0 || filter.HouseholdID != "" {
+		return nil, nil
+	}
+
+	var args queryArgs
+	conditions := "sh.user_id = " + args.add(filter.UserID) + " AND t.user_id <> sh.user_id"
+	if !filter.From.IsZero() {
+		conditions += " AND t.date >= " + args.add(filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions += " AND t.date <= " + args.add(filter.To)
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT `+lineCategoryID+`::text, `+lineSubcategory+`, `+lineMainCategory+`,
+			SUM(`+lineAmount+` * sh.amount / NULLIF(ABS(t.amount), 0)), COUNT(DISTINCT t.id)
+		FROM shared_expense_shares sh
+		JOIN shared_expenses se ON se.id = sh.shared_expense_id
+		JOIN transactions t ON t.id = se.transaction_id
+		LEFT JOIN transaction_splits s ON s.transaction_id = t.id
+		WHERE `+conditions+`
+		GROUP BY 1, 2, 3`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve shared expenses: %v", err)
+	}
+	defer rows.Close()
+
+	var lines []CategorySpending
+	for rows.Next() {
+		var line CategorySpending
+		if err := rows.Scan(&line.CategoryID, &line.Category, &line.MainCategory, &line.Amount, &line.TransactionCount); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
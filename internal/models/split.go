@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// TransactionSplit is a part of a transaction attributed to its own
+// category, e.g. the household items of a supermarket receipt. The account
+// balance is only affected by the parent transaction.
+type TransactionSplit struct {
+	ID           string      `json:"id"`
+	Amount       float64     `json:"amount"`
+	MainCategory string      `json:"main_category"`
+	Subcategory  string      `json:"subcategory"`
+	CategoryID   null.String `json:"category_id"`
+	Note         string      `json:"note"`
+}
+
+// ErrInvalidSplits wraps errors caused by split lines sent by the client
+var ErrInvalidSplits = errors.New("invalid splits")
+
+// splitTolerance absorbs rounding when comparing the split total to the amount
+const splitTolerance = 0.005
+
+// Category lines are the splits of a transaction, or the transaction itself
+// when it is not split. Reports join them with
+//
+//	FROM transactions t LEFT JOIN transaction_splits s ON s.transaction_id = t.id
+//
+// and aggregate the line columns below instead of the transaction ones.
+const (
+	lineAmount       = "CASE WHEN s.id IS NULL THEN t.amount ELSE s.amount END"
+	lineMainCategory = "CASE WHEN s.id IS NULL THEN t.main_category ELSE s.main_category END"
+	lineSubcategory  = "CASE WHEN s.id IS NULL THEN t.subcategory ELSE s.subcategory END"
+	lineCategoryID   = "CASE WHEN s.id IS NULL THEN t.category_id ELSE s.category_id END"
+)
+
+// splitsColumn reads the splits of t as a JSON array scanned into Transaction.Splits
+const splitsColumn = `COALESCE((
+		SELECT json_agg(json_build_object(
+			'id', s.id, 'amount', s.amount, 'main_category', s.main_category,
+			'subcategory', s.subcategory, 'category_id', s.category_id, 'note', s.note
+		) ORDER BY s.position)
+		FROM transaction_splits s WHERE s.transaction_id = t.id), '[]') AS splits`
+
+// checkSplitTotal ensures the split lines add up to the transaction amount
+func checkSplitTotal(amount float64, splits []TransactionSplit) error {
+	var total float64
+	for _, split := range splits {
+		total += split.Amount
+	}
+	if math.Abs(total-amount) > splitTolerance {
+		return fmt.Errorf("%w: split amounts add up to %.2f but the transaction amount is %.2f", ErrInvalidSplits, total, amount)
+	}
+	return nil
+}
+
+// resolveSplits validates the split lines of a transaction and fills in
+// their main category and subcategory
+func resolveSplits(amount float64, splits []TransactionSplit) ([]TransactionSplit, error) {
+	if len(splits) == 0 {
+		return splits, nil
+	}
+
+	for i, split := range splits {
+		if !split.CategoryID.Valid || split.CategoryID.String == "" {
+			return nil, fmt.Errorf("%w: split %d has no category", ErrInvalidSplits, i+1)
+		}
+		if split.Amount == 0 {
+			return nil, fmt.Errorf("%w: split %d has no amount", ErrInvalidSplits, i+1)
+		}
+
+		mainCategory, err := GetMainCategory(split.CategoryID.String)
+		if err != nil {
+			return nil, fmt.Errorf("%w: split %d: %v", ErrInvalidSplits, i+1, err)
+		}
+		subcategory, err := GetSubCategory(split.CategoryID.String)
+		if err != nil {
+			return nil, fmt.Errorf("%w: split %d: %v", ErrInvalidSplits, i+1, err)
+		}
+		splits[i].MainCategory = mainCategory
+		splits[i].Subcategory = subcategory
+	}
+
+	if err := checkSplitTotal(amount, splits); err != nil {
+		return nil, err
+	}
+
+	return splits, nil
+}
+
+// setTransactionSplits replaces the split lines of a transaction, an empty
+// list turns it back into a single category transaction
+func setTransactionSplits(ctx context.Context, tx pgx.Tx, transactionID string, splits []TransactionSplit) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM transaction_splits WHERE transaction_id = $1", transactionID); err != nil {
+		return fmt.Errorf("failed to clear transaction splits: %v", err)
+	}
+
+	for i := range splits {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO transaction_splits (transaction_id, position, amount, main_category, subcategory, category_id, note)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id`,
+			transactionID,
+			i,
+			splits[i].Amount,
+			splits[i].MainCategory,
+			splits[i].Subcategory,
+			splits[i].CategoryID,
+			splits[i].Note,
+		).Scan(&splits[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to insert transaction split: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// inMainCategory narrows split transactions down to their lines in the main
+// category, so the amounts of a category listing add up to the category
+// total of the budget summary
+func inMainCategory(transactions []Transaction, mainCategory string) []Transaction {
+	for i, transaction := range transactions {
+		if len(transaction.Splits) == 0 {
+			continue
+		}
+
+		lines := []TransactionSplit{}
+		var amount float64
+		for _, split := range transaction.Splits {
+			if split.MainCategory == mainCategory {
+				lines = append(lines, split)
+				amount += split.Amount
+			}
+		}
+
+		transactions[i].Splits = lines
+		transactions[i].Amount = amount
+		transactions[i].AmountInBaseCurrency = amount * transaction.ExchangeRate
+		transactions[i].MainCategory = mainCategory
+	}
+	return transactions
+}
@@ -0,0 +1,336 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Tag is a user defined label that can be put on any transaction
+type Tag struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Color            string `json:"color"`
+	TransactionCount int    `json:"transaction_count"`
+	UserID           string `json:"user_id"`
+}
+
+// normalizeTagNames trims the names and drops empty and duplicated ones
+func normalizeTagNames(names []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	return normalized
+}
+
+// GetTags retrieves the tags of a user with the number of tagged transactions
+func GetTags(uid string) ([]Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT tg.id, tg.name, tg.color, COUNT(tt.transaction_id), tg.user_id
+		FROM tags tg
+		LEFT JOIN transaction_tags tt ON tt.tag_id = tg.id
+		WHERE tg.user_id = $1
+		GROUP BY tg.id
+		ORDER BY tg.name`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Color, &tag.TransactionCount, &tag.UserID); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// AddTag creates a tag, names are unique per user
+func AddTag(tag Tag) (Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" {
+		return Tag{}, fmt.Errorf("tag name is required")
+	}
+
+	query := "INSERT INTO tags (name, color, user_id) VALUES ($1, $2, $3) RETURNING id"
+	err := db.QueryRow(ctx, query, tag.Name, tag.Color, tag.UserID).Scan(&tag.ID)
+	if err != nil {
+		return Tag{}, fmt.Errorf("failed to add tag: %v", err)
+	}
+
+	return tag, nil
+}
+
+// UpdateTag renames or recolors a tag, an empty name or color is kept.
+// Renaming changes the transactions carrying the tag, see retagTransactions.
+func UpdateTag(tag Tag, actor Actor) (Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tag.Name = strings.TrimSpace(tag.Name)
+	tag.UserID = actor.UserID
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Tag{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var name string
+	err = tx.QueryRow(ctx, "SELECT name FROM tags WHERE id::text = $1 AND user_id = $2 FOR UPDATE", tag.ID, tag.UserID).Scan(&name)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Tag{}, fmt.Errorf("%w: no tag found with ID %s", ErrNotFound, tag.ID)
+		}
+		return Tag{}, fmt.Errorf("failed to retrieve tag: %v", err)
+	}
+
+	update := func() error {
+		err := tx.QueryRow(ctx, `
+			UPDATE tags
+			SET name = COALESCE(NULLIF($1, ''), name),
+				color = COALESCE(NULLIF($2, ''), color)
+			WHERE id::text = $3
+			RETURNING name, color`,
+			tag.Name, tag.Color, tag.ID,
+		).Scan(&tag.Name, &tag.Color)
+		if err != nil {
+			return fmt.Errorf("failed to update tag: %v", err)
+		}
+		return nil
+	}
+	if tag.Name != "" && tag.Name != name {
+		err = retagTransactions(ctx, tx, tag.ID, actor, update)
+	} else {
+		err = update()
+	}
+	if err != nil {
+		return Tag{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Tag{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return tag, nil
+}
+
+// DeleteTag removes a tag from every transaction and deletes it
+func DeleteTag(id string, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM tags WHERE id::text = $1 AND user_id = $2)", id, actor.UserID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve tag: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: no tag found with ID %s", ErrNotFound, id)
+	}
+
+	err = retagTransactions(ctx, tx, id, actor, func() error {
+		if _, err := tx.Exec(ctx, "DELETE FROM tags WHERE id::text = $1", id); err != nil {
+			return fmt.Errorf("failed to delete tag: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return nil
+}
+
+// retagTransactions runs a change to a tag that shows on the transactions
+// carrying it. As with BulkTagTransactions, those transactions get new
+// versions and audit entries in the same database transaction.
+func retagTransactions(ctx context.Context, tx pgx.Tx, tagID string, actor Actor, change func() error) error {
+	const tagged = "t.id IN (SELECT transaction_id FROM transaction_tags WHERE tag_id::text = $1)"
+
+	before, ids, err := lockSnapshots(ctx, tx, " WHERE t.deleted_at IS NULL AND "+tagged, tagID)
+	if err != nil {
+		return err
+	}
+
+	// Trashed transactions lose the tag too
+	if _, err := tx.Exec(ctx, "UPDATE transactions t SET version = t.version + 1 WHERE "+tagged, tagID); err != nil {
+		return fmt.Errorf("failed to update transaction versions: %v", err)
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	return auditTransactions(ctx, tx, actor, AuditUpdate, before, ids...)
+}
+
+// ensureTags returns the ids of the named tags, creating the missing ones
+func ensureTags(ctx context.Context, tx pgx.Tx, uid string, names []string) ([]string, error) {
+	names = normalizeTagNames(names)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO tags (name, user_id)
+		SELECT UNNEST($1::text[]), $2
+		ON CONFLICT (user_id, name) DO NOTHING`, names, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tags: %v", err)
+	}
+
+	rows, err := tx.Query(ctx, "SELECT id::text FROM tags WHERE user_id = $1 AND name = ANY($2::text[])", uid, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve tags: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// setTransactionTags replaces the tags of a transaction
+func setTransactionTags(ctx context.Context, tx pgx.Tx, transactionID string, uid string, names []string) error {
+	tagIDs, err := ensureTags(ctx, tx, uid, names)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM transaction_tags WHERE transaction_id = $1", transactionID); err != nil {
+		return fmt.Errorf("failed to clear transaction tags: %v", err)
+	}
+
+	if len(tagIDs) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO transaction_tags (transaction_id, tag_id)
+		SELECT $1::uuid, UNNEST($2::text[])::uuid
+		ON CONFLICT DO NOTHING`, transactionID, tagIDs)
+	if err != nil {
+		return fmt.Errorf("failed to tag transaction: %v", err)
+	}
+
+	return nil
+}
+
+// BulkTagTransactions adds and removes tags on every transaction matching
+// the filter (use filter.IDs to target specific transactions) and returns
+// the number of matching transactions. Household transactions are only
+// tagged where the user can edit. A filter matching everything is refused.
+func BulkTagTransactions(filter TransactionFilter, add []string, remove []string, actor Actor) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if filter.unfiltered() {
+		return 0, fmt.Errorf("%w: list transaction_ids or filter the transactions to tag", ErrInvalidQuery)
+	}
+	filter.Writable = true
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var matchArgs queryArgs
+	before, ids, err := lockSnapshots(ctx, tx, filter.where(&matchArgs), matchArgs...)
+	if err != nil {
+		return 0, err
+	}
+
+	tagIDs, err := ensureTags(ctx, tx, filter.UserID, add)
+	if err != nil {
+		return 0, err
+	}
+
+	// Tags are part of the transactions, those matching the filter before
+	// it is changed by the tagging get new versions
+	remove = normalizeTagNames(remove)
+	if len(tagIDs) > 0 || len(remove) > 0 {
+		var args queryArgs
+		if _, err := tx.Exec(ctx, "UPDATE transactions t SET version = t.version + 1"+filter.where(&args), args...); err != nil {
+			return 0, fmt.Errorf("failed to update transaction versions: %v", err)
+		}
+	}
+
+	if len(tagIDs) > 0 {
+		var args queryArgs
+		tagsParam := args.add(tagIDs)
+		_, err = tx.Exec(ctx, `
+			INSERT INTO transaction_tags (transaction_id, tag_id)
+			SELECT t.id, UNNEST(`+tagsParam+`::text[])::uuid
+			FROM transactions t`+filter.where(&args)+`
+			ON CONFLICT DO NOTHING`, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to tag transactions: %v", err)
+		}
+	}
+
+	if len(remove) > 0 {
+		var args queryArgs
+		namesParam := args.add(remove)
+		_, err = tx.Exec(ctx, `
+			DELETE FROM transaction_tags tt
+			USING transactions t, tags tg`+filter.where(&args)+`
+			  AND tt.transaction_id = t.id
+			  AND tt.tag_id = tg.id
+			  AND tg.name = ANY(`+namesParam+`::text[])`, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to untag transactions: %v", err)
+		}
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditUpdate, before, ids...); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+
+	return len(ids), nil
+}
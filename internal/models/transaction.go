@@ -2,16 +2,17 @@ package models
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"guilliman/internal/utils"
 	"guilliman/internal/utils/timeutils"
 	"log"
+	"math"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 const (
@@ -29,313 +30,146 @@ const (
 )
 
 type Transaction struct {
-	ID                   string      `json:"id"`
-	Description          string      `json:"description"`
-	Amount               float64     `json:"amount"`
-	Currency             string      `json:"currency"`
-	AmountInBaseCurrency float64     `json:"amount_in_base_currency"`
-	ExchangeRate         float64     `json:"exchange_rate"`
-	Date                 int64       `json:"date"`
-	MainCategory         string      `json:"main_category"`
-	Subcategory          string      `json:"subcategory"`
-	CategoryID           null.String `json:"category_id"`
-	AccountID            null.String `json:"account_id"`
-	RelatedAccountID     null.String `json:"related_account_id"`
-	TransactionType      string      `json:"transaction_type"`
-	Fees                 float64     `json:"fees"`
-	UserID               string      `json:"user_id"`
+	ID                   string              `json:"id"`
+	Description          string              `json:"description"`
+	Amount               float64             `json:"amount"`
+	Currency             string              `json:"currency"`
+	AmountInBaseCurrency float64             `json:"amount_in_base_currency"`
+	ExchangeRate         float64             `json:"exchange_rate"`
+	Date                 timeutils.Timestamp `json:"date"`
+	MainCategory         string              `json:"main_category"`
+	Subcategory          string              `json:"subcategory"`
+	CategoryID           null.String         `json:"category_id"`
+	AccountID            null.String         `json:"account_id"`
+	RelatedAccountID     null.String         `json:"related_account_id"`
+	TransactionType      string              `json:"transaction_type"`
+	Fees                 float64             `json:"fees"`
+	UserID               string              `json:"user_id"`
+	Notes                string              `json:"notes"`
+	Tags                 []string            `json:"tags"`       // Tag names, unknown ones are created. Omit to keep the current tags on update.
+	Splits               []TransactionSplit  `json:"splits"`     // Lines summing to Amount. Omit to keep the current splits on update, send [] to remove them.
+	PayeeID              null.String         `json:"payee_id"`   // Matched from the description when omitted
+	Payee                string              `json:"payee"`      // Name of the payee, read only
+	RefundOfID           null.String         `json:"refund_of"`  // Expense refunded by a Refund transaction
+	Status               string              `json:"status"`     // "pending" or "cleared" on creation, see TransactionStatusPending
+	DeletedAt            timeutils.Timestamp `json:"deleted_at"` // When it was moved to the trash, read only
+	Version              int                 `json:"version"`    // Changes with every update, sent as the ETag. Read only.
 }
 
-func GetTransactionsByMainCategory(mainCategory string, startDay string, endDay string, uid string) ([]Transaction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var start, end int64
-
-	startDate, endDate := timeutils.GetSalaryMonthRange(startDay, endDay)
-	start = startDate.Unix()
-	end = endDate.Unix()
-
-	query := `SELECT 
-	  id,	
-	  description,	
-	  amount,	
-	  currency,	
-	  amount_in_base_currency,	
-	  exchange_rate,	
-	  main_category,	
-	  subcategory,	
-	  date,	
-	  category_id,
-	  account_id,
-	  related_account_id,
-	  transaction_type
-	FROM transactions`
-
-	var conditions []string
-	var args []interface{}
-
-	conditions = append(conditions, "user_id = $1")
-	args = append(args, uid)
-
-	if mainCategory != "" {
-		conditions = append(conditions, "main_category = $2")
-		args = append(args, mainCategory)
-	}
-
-	conditions = append(conditions, "date BETWEEN $3 AND $4")
-	args = append(args, start, end)
-
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+// GetTransactionsByMainCategory lists the transactions of a main category.
+// Split transactions only carry their lines in the category, see inMainCategory.
+func GetTransactionsByMainCategory(mainCategory string, start time.Time, end time.Time, tags []string, household string, uid string) ([]Transaction, error) {
+	filter, err := spendingFilter(start, end, tags, household, uid)
+	if err != nil {
+		return nil, err
 	}
+	filter.MainCategory = mainCategory
 
-	rows, err := db.Query(ctx, query, args...)
-
+	transactions, err := FindTransactions(filter, 0)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var transactions []Transaction
-	for rows.Next() {
-		var transaction Transaction
-		if err := rows.Scan(
-			&transaction.ID,
-			&transaction.Description,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.AmountInBaseCurrency,
-			&transaction.ExchangeRate,
-			&transaction.MainCategory,
-			&transaction.Subcategory,
-			&transaction.Date,
-			&transaction.CategoryID,
-			&transaction.AccountID,
-			&transaction.RelatedAccountID,
-			&transaction.TransactionType,
-		); err != nil {
-			return nil, err
-		}
-		transactions = append(transactions, transaction)
-	}
-	return transactions, nil
+	return inMainCategory(transactions, mainCategory), nil
 }
 
 func GetTransactionByID(transactionID string, userID string) (Transaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var transaction Transaction
-
-	query := `SELECT 
-		id, description, amount, currency, amount_in_base_currency, exchange_rate, 
-		date, main_category, subcategory, category_id, account_id, 
-		related_account_id, transaction_type
-	FROM transactions 
-	WHERE id = $1 AND user_id = $2`
-
-	err := db.QueryRow(ctx, query, transactionID, userID).Scan(
-		&transaction.ID,
-		&transaction.Description,
-		&transaction.Amount,
-		&transaction.Currency,
-		&transaction.AmountInBaseCurrency,
-		&transaction.ExchangeRate,
-		&transaction.Date,
-		&transaction.MainCategory,
-		&transaction.Subcategory,
-		&transaction.CategoryID,
-		&transaction.AccountID,
-		&transaction.RelatedAccountID,
-		&transaction.TransactionType,
-	)
+	query := "SELECT " + transactionColumns + " FROM transactions t WHERE t.id = $1 AND " + visibleTransaction("$2")
+
+	transaction, err := scanTransaction(db.QueryRow(ctx, query, transactionID, userID))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return Transaction{}, fmt.Errorf("transaction not found")
+		if err == pgx.ErrNoRows {
+			return Transaction{}, fmt.Errorf("%w: transaction %s", ErrNotFound, transactionID)
 		}
 		return Transaction{}, fmt.Errorf("failed to retrieve transaction: %v", err)
 	}
 
-	return transaction, nil
-}
-
-func GetTransactions(transactionType string, accountId string, limitParam string, uid string) ([]Transaction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	query := `SELECT 
-	  id,	
-	  description,	
-	  amount,	
-	  currency,	
-	  amount_in_base_currency,	
-	  exchange_rate,	
-	  main_category,	
-	  subcategory,	
-	  date,	
-	  category_id,
-	  account_id,
-	  related_account_id,
-	  transaction_type
-	FROM transactions`
-
-	var conditions []string
-	var args []interface{}
-
-	// Always filter by user_id
-	conditions = append(conditions, "user_id = $1")
-	args = append(args, uid)
-	argIndex := 2 // Track query argument numbers
-
-	if transactionType != "" {
-		conditions = append(conditions, fmt.Sprintf("transaction_type = $%d", argIndex))
-		args = append(args, transactionType)
-		argIndex++
-	}
-
-	if accountId != "" {
-		conditions = append(conditions, fmt.Sprintf("account_id = $%d", argIndex))
-		args = append(args, accountId)
-		argIndex++
-	}
-
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
+	localized, err := inUserZone([]Transaction{transaction}, userID)
+	if err != nil {
+		return Transaction{}, err
 	}
 
-	query += " ORDER BY date DESC" // Ensure newest transactions are retrieved first
+	return localized[0], nil
+}
 
+func GetTransactions(transactionType string, accountId string, limitParam string, uid string) ([]Transaction, error) {
+	limit := 0
 	if limitParam != "" {
-		_, err := strconv.Atoi(limitParam)
-		if err == nil { // Ensure limit is a valid integer
-			query += fmt.Sprintf(" LIMIT $%d", argIndex)
-			args = append(args, limitParam)
+		if value, err := strconv.Atoi(limitParam); err == nil { // Ensure limit is a valid integer
+			limit = value
 		}
 	}
 
-	rows, err := db.Query(ctx, query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var transactions []Transaction
-	for rows.Next() {
-		var transaction Transaction
-		if err := rows.Scan(
-			&transaction.ID,
-			&transaction.Description,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.AmountInBaseCurrency,
-			&transaction.ExchangeRate,
-			&transaction.MainCategory,
-			&transaction.Subcategory,
-			&transaction.Date,
-			&transaction.CategoryID,
-			&transaction.AccountID,
-			&transaction.RelatedAccountID,
-			&transaction.TransactionType,
-		); err != nil {
-			return nil, err
-		}
-		transactions = append(transactions, transaction)
-	}
-	return transactions, nil
+	return FindTransactions(TransactionFilter{
+		UserID:          uid,
+		TransactionType: transactionType,
+		AccountID:       accountId,
+	}, limit)
 }
 
-func GetTransactionsForPeriod(start int64, end int64, transactionType string, accountId string, uid string) ([]Transaction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	query := `SELECT 
-	  id,	
-	  description,	
-	  amount,	
-	  currency,	
-	  amount_in_base_currency,	
-	  exchange_rate,	
-	  main_category,	
-	  subcategory,	
-	  date,	
-	  category_id,
-	  account_id,
-	  related_account_id,
-	  transaction_type
-	FROM transactions`
-
-	var conditions []string
-	var args []interface{}
-
-	conditions = append(conditions, "user_id = $1")
-	args = append(args, uid)
-
-	if transactionType != "" {
-		conditions = append(conditions, "transaction_type = $2")
-		args = append(args, transactionType)
-	}
-
-	if accountId != "" {
-		conditions = append(conditions, "account_id = $3")
-		args = append(args, accountId)
-	}
-
-	if len(conditions) > 0 {
-		query += " WHERE " + strings.Join(conditions, " AND ")
-	}
-
-	rows, err := db.Query(ctx, query, args...)
-
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var transactions []Transaction
-	for rows.Next() {
-		var transaction Transaction
-		if err := rows.Scan(
-			&transaction.ID,
-			&transaction.Description,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.AmountInBaseCurrency,
-			&transaction.ExchangeRate,
-			&transaction.MainCategory,
-			&transaction.Subcategory,
-			&transaction.Date,
-			&transaction.CategoryID,
-			&transaction.AccountID,
-			&transaction.RelatedAccountID,
-			&transaction.TransactionType,
-		); err != nil {
-			return nil, err
-		}
-		transactions = append(transactions, transaction)
-	}
-	return transactions, nil
+func GetTransactionsForPeriod(start time.Time, end time.Time, transactionType string, accountId string, uid string) ([]Transaction, error) {
+	return FindTransactions(TransactionFilter{
+		UserID:          uid,
+		From:            start,
+		To:              end,
+		TransactionType: transactionType,
+		AccountID:       accountId,
+	}, 0)
 }
 
 /**
 * Add a new transaction to the database
 * Can add TransactionType = "expense", "income"
  */
-func AddTransaction(transaction Transaction) (Transaction, error) {
+func AddTransaction(transaction Transaction, actor Actor) (Transaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	sourceAccount, err := GetAccountByID(transaction.AccountID, transaction.UserID)
+	if transaction.TransactionType == TransactionTypeRefund {
+		if err := prepareRefund(&transaction); err != nil {
+			return Transaction{}, err
+		}
+	} else {
+		transaction.RefundOfID = null.String{}
+	}
+
+	status, err := initialStatus(transaction.Status)
 	if err != nil {
-		return Transaction{}, fmt.Errorf("invalid account: %v", err)
+		return Transaction{}, err
 	}
+	transaction.Status = status
 
-	if transaction.TransactionType == TransactionTypeExpense {
+	sourceAccount, err := getWritableAccount(transaction.AccountID, transaction.UserID)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("invalid account: %w", err)
+	}
+
+	// Credit cards are limited by their available credit instead of their balance
+	if sourceAccount.IsCreditCard() {
+		if err := checkCredit(sourceAccount, transaction); err != nil {
+			return Transaction{}, err
+		}
+	} else if transaction.TransactionType == TransactionTypeExpense {
 		if sourceAccount.Balance < transaction.Amount {
 			return Transaction{}, fmt.Errorf("insufficient balance in account: %v", err)
 		}
 	}
 
+	transaction.Splits, err = resolveSplits(transaction.Amount, transaction.Splits)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	if err := assignPayee(&transaction); err != nil {
+		return Transaction{}, err
+	}
+	// A split transaction is listed under the category of its first line
+	if len(transaction.Splits) > 0 && !transaction.CategoryID.Valid {
+		transaction.CategoryID = transaction.Splits[0].CategoryID
+	}
+
 	// Determine the main category based on the subcategory
 	var categoryID string
 	if transaction.CategoryID.Valid {
@@ -355,8 +189,8 @@ func AddTransaction(transaction Transaction) (Transaction, error) {
 	transaction.MainCategory = mainCategory
 	transaction.Subcategory = subcategory
 
-	if transaction.Date == 0 {
-		transaction.Date = time.Now().Unix()
+	if transaction.Date.IsZero() {
+		transaction.Date = timeutils.NewTimestamp(time.Now())
 	}
 
 	var exchangeRate float64
@@ -389,8 +223,15 @@ func AddTransaction(transaction Transaction) (Transaction, error) {
 		}
 	}()
 
+	if transaction.TransactionType == TransactionTypeRefund {
+		if err := checkRefundLimit(ctx, tx, transaction, ""); err != nil {
+			tx.Rollback(ctx)
+			return Transaction{}, err
+		}
+	}
+
 	// Insert the transaction into the database
-	_, err = tx.Exec(ctx,
+	err = tx.QueryRow(ctx,
 		`INSERT INTO transactions (
 		  description,
 		  amount,
@@ -404,8 +245,13 @@ func AddTransaction(transaction Transaction) (Transaction, error) {
 		  account_id,
 		  related_account_id,
 		  transaction_type,
-      user_id
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		  user_id,
+		  notes,
+		  payee_id,
+		  refund_of_id,
+		  status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id, version`,
 		transaction.Description,
 		transaction.Amount,
 		transaction.Currency,
@@ -419,21 +265,35 @@ func AddTransaction(transaction Transaction) (Transaction, error) {
 		transaction.RelatedAccountID,
 		transaction.TransactionType,
 		transaction.UserID,
-	)
+		transaction.Notes,
+		transaction.PayeeID,
+		transaction.RefundOfID,
+		transaction.Status,
+	).Scan(&transaction.ID, &transaction.Version)
 	if err != nil {
 		tx.Rollback(ctx)
 		return Transaction{}, fmt.Errorf("failed to insert transaction: %v", err)
 	}
 
-	// Update the account balance for the source account
-	_, err = tx.Exec(ctx,
-		`UPDATE accounts SET balance = balance + $1 WHERE id = $2`,
-		transaction.Amount, transaction.AccountID,
-	)
+	if err := setTransactionTags(ctx, tx, transaction.ID, transaction.UserID, transaction.Tags); err != nil {
+		tx.Rollback(ctx)
+		return Transaction{}, err
+	}
+	transaction.Tags = normalizeTagNames(transaction.Tags)
 
-	if err != nil {
+	if err := setTransactionSplits(ctx, tx, transaction.ID, transaction.Splits); err != nil {
 		tx.Rollback(ctx)
-		return Transaction{}, fmt.Errorf("failed to update source account balance: %v", err)
+		return Transaction{}, err
+	}
+
+	// Post the transaction to the journal, once its splits are saved
+	if err := postEntries(ctx, tx, transaction.ID); err != nil {
+		tx.Rollback(ctx)
+		return Transaction{}, err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditCreate, nil, transaction.ID); err != nil {
+		tx.Rollback(ctx)
+		return Transaction{}, err
 	}
 
 	// Commit the transaction
@@ -445,14 +305,84 @@ func AddTransaction(transaction Transaction) (Transaction, error) {
 	return transaction, nil
 }
 
-func UpdateTransaction(transactionID string, updatedTransaction Transaction) (Transaction, error) {
+// UpdateTransaction replaces a transaction with the updated one. The current
+// transaction is read and locked in the database transaction making the
+// change, so concurrent updates of the same transaction apply one after the
+// other. A version in the updated transaction must be the stored one.
+func UpdateTransaction(transactionID string, updatedTransaction Transaction, override bool, actor Actor) (Transaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Retrieve the existing transaction
-	existingTransaction, err := GetTransactionByID(transactionID, updatedTransaction.UserID)
+	tx, err := db.Begin(ctx)
 	if err != nil {
-		return Transaction{}, fmt.Errorf("transaction not found: %v", err)
+		return Transaction{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Retrieve the existing transaction, locked until the update commits
+	existingTransaction, err := scanTransaction(tx.QueryRow(ctx,
+		"SELECT "+transactionColumns+" FROM transactions t WHERE t.id::text = $1 AND "+visibleTransaction("$2")+" FOR UPDATE OF t",
+		transactionID, updatedTransaction.UserID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Transaction{}, fmt.Errorf("%w: transaction %s", ErrNotFound, transactionID)
+		}
+		return Transaction{}, fmt.Errorf("failed to retrieve transaction: %v", err)
+	}
+	if err := checkVersion(updatedTransaction.Version, existingTransaction.Version, "the transaction"); err != nil {
+		return Transaction{}, err
+	}
+	updatedTransaction.ID = existingTransaction.ID
+
+	if existingTransaction.Status == TransactionStatusVoid {
+		return Transaction{}, fmt.Errorf("%w: void transactions can't be changed", ErrInvalidStatus)
+	}
+	if existingTransaction.Status == TransactionStatusReconciled && !override {
+		return Transaction{}, fmt.Errorf("%w: override the lock to change it", ErrReconciled)
+	}
+	// The status changes through settling and voiding only
+	updatedTransaction.Status = existingTransaction.Status
+	// A body without a date keeps the current one
+	if updatedTransaction.Date.IsZero() {
+		updatedTransaction.Date = existingTransaction.Date
+	}
+
+	// Both the current and the new account must be writable by the user
+	if _, err := getWritableAccount(existingTransaction.AccountID, updatedTransaction.UserID); err != nil {
+		return Transaction{}, fmt.Errorf("invalid account: %w", err)
+	}
+	if updatedTransaction.AccountID != existingTransaction.AccountID {
+		if _, err := getWritableAccount(updatedTransaction.AccountID, updatedTransaction.UserID); err != nil {
+			return Transaction{}, fmt.Errorf("invalid account: %w", err)
+		}
+	}
+
+	if updatedTransaction.TransactionType == TransactionTypeRefund {
+		if err := prepareRefund(&updatedTransaction); err != nil {
+			return Transaction{}, err
+		}
+	} else {
+		updatedTransaction.RefundOfID = null.String{}
+	}
+
+	// Splits are only replaced when the client sent them, the current ones
+	// must still add up to the new amount
+	if updatedTransaction.Splits != nil {
+		updatedTransaction.Splits, err = resolveSplits(updatedTransaction.Amount, updatedTransaction.Splits)
+		if err != nil {
+			return Transaction{}, err
+		}
+	} else if len(existingTransaction.Splits) > 0 {
+		if err := checkSplitTotal(updatedTransaction.Amount, existingTransaction.Splits); err != nil {
+			return Transaction{}, err
+		}
+	}
+	if len(updatedTransaction.Splits) > 0 && !updatedTransaction.CategoryID.Valid {
+		updatedTransaction.CategoryID = updatedTransaction.Splits[0].CategoryID
+	}
+
+	if err := assignPayee(&updatedTransaction); err != nil {
+		return Transaction{}, err
 	}
 
 	// Ensure the category exists
@@ -490,45 +420,42 @@ func UpdateTransaction(transactionID string, updatedTransaction Transaction) (Tr
 		updatedTransaction.AmountInBaseCurrency = updatedTransaction.Amount * existingTransaction.ExchangeRate
 	}
 
-	// Start a database transaction
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		return Transaction{}, fmt.Errorf("failed to start database transaction: %v", err)
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback(ctx)
-			log.Printf("Recovered from panic: %v", r)
+	if updatedTransaction.TransactionType == TransactionTypeRefund {
+		if err := checkRefundLimit(ctx, tx, updatedTransaction, transactionID); err != nil {
+			return Transaction{}, err
 		}
-	}()
+	}
 
-	// Adjust the account balance: First revert the old transaction
-	_, err = tx.Exec(ctx,
-		`UPDATE accounts SET balance = balance - $1 WHERE id = $2`,
-		existingTransaction.Amount, existingTransaction.AccountID,
-	)
-	if err != nil {
-		tx.Rollback(ctx)
-		return Transaction{}, fmt.Errorf("failed to revert old transaction amount: %v", err)
+	// Shares are amounts, so a shared expense has to be shared again to change
+	var shared bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM shared_expenses WHERE transaction_id = $1)", transactionID).Scan(&shared); err != nil {
+		return Transaction{}, fmt.Errorf("failed to check shared expense: %v", err)
+	}
+	if shared && (updatedTransaction.Amount != existingTransaction.Amount ||
+		updatedTransaction.TransactionType != existingTransaction.TransactionType) {
+		return Transaction{}, fmt.Errorf("%w: the expense is shared, share it again to change its amount", ErrInvalidShare)
 	}
 
-	// Then apply the updated transaction amount
-	_, err = tx.Exec(ctx,
-		`UPDATE accounts SET balance = balance + $1 WHERE id = $2`,
-		updatedTransaction.Amount, updatedTransaction.AccountID,
-	)
+	// An expense can't become smaller than what has been refunded of it
+	refunded, err := refundedAmount(ctx, tx, transactionID, "")
 	if err != nil {
-		tx.Rollback(ctx)
-		return Transaction{}, fmt.Errorf("failed to update account balance: %v", err)
+		return Transaction{}, err
+	}
+	if refunded > 0 && (updatedTransaction.TransactionType != TransactionTypeExpense ||
+		math.Abs(updatedTransaction.Amount)+splitTolerance < refunded) {
+		return Transaction{}, fmt.Errorf("%w: %.2f of this expense is refunded, it must remain an expense of at least that amount", ErrInvalidRefund, refunded)
 	}
 
-	// Update the transaction in the database
-	_, err = tx.Exec(ctx,
+	// Update the transaction in the database, its tags and splits being
+	// replaced make a new version too
+	err = tx.QueryRow(ctx,
 		`UPDATE transactions SET
 		  description = $1, amount = $2, currency = $3, amount_in_base_currency = $4, exchange_rate = $5, 
 		  date = $6, main_category = $7, subcategory = $8, category_id = $9, account_id = $10, 
-		  related_account_id = $11, transaction_type = $12
-		WHERE id = $13`,
+		  related_account_id = $11, transaction_type = $12, notes = $13, payee_id = $14, refund_of_id = $15,
+		  version = version + 1
+		WHERE id = $16
+		RETURNING version`,
 		updatedTransaction.Description,
 		updatedTransaction.Amount,
 		updatedTransaction.Currency,
@@ -541,13 +468,42 @@ func UpdateTransaction(transactionID string, updatedTransaction Transaction) (Tr
 		updatedTransaction.AccountID,
 		updatedTransaction.RelatedAccountID,
 		updatedTransaction.TransactionType,
+		updatedTransaction.Notes,
+		updatedTransaction.PayeeID,
+		updatedTransaction.RefundOfID,
 		transactionID,
-	)
+	).Scan(&updatedTransaction.Version)
 	if err != nil {
-		tx.Rollback(ctx)
 		return Transaction{}, fmt.Errorf("failed to update transaction: %v", err)
 	}
 
+	// Tags are only replaced when the client sent them
+	if updatedTransaction.Tags != nil {
+		if err := setTransactionTags(ctx, tx, transactionID, updatedTransaction.UserID, updatedTransaction.Tags); err != nil {
+			return Transaction{}, err
+		}
+		updatedTransaction.Tags = normalizeTagNames(updatedTransaction.Tags)
+	} else {
+		updatedTransaction.Tags = existingTransaction.Tags
+	}
+
+	if updatedTransaction.Splits != nil {
+		if err := setTransactionSplits(ctx, tx, transactionID, updatedTransaction.Splits); err != nil {
+			return Transaction{}, err
+		}
+	} else {
+		updatedTransaction.Splits = existingTransaction.Splits
+	}
+
+	// Replace the postings of the old transaction with the updated ones
+	if err := postEntries(ctx, tx, transactionID); err != nil {
+		return Transaction{}, err
+	}
+	before := map[string]*Transaction{transactionID: &existingTransaction}
+	if err := auditTransactions(ctx, tx, actor, AuditUpdate, before, transactionID); err != nil {
+		return Transaction{}, err
+	}
+
 	// Commit the transaction
 	err = tx.Commit(ctx)
 	if err != nil {
@@ -561,10 +517,27 @@ func UpdateTransaction(transactionID string, updatedTransaction Transaction) (Tr
 * Add a new transfer to the database
 * Can add TransactionType = "transfer" "savings"
  */
-func AddTransfer(transaction Transaction) (Transaction, error) {
+func AddTransfer(transaction Transaction, actor Actor) (Transaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if len(transaction.Splits) > 0 {
+		return Transaction{}, fmt.Errorf("%w: transfers cannot be split", ErrInvalidSplits)
+	}
+
+	status, err := initialStatus(transaction.Status)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction.Status = status
+
+	if _, err := getWritableAccount(transaction.AccountID, transaction.UserID); err != nil {
+		return Transaction{}, fmt.Errorf("invalid source account: %w", err)
+	}
+	if _, err := getWritableAccount(transaction.RelatedAccountID, transaction.UserID); err != nil {
+		return Transaction{}, fmt.Errorf("invalid destination account: %w", err)
+	}
+
 	var categoryID string
 	if transaction.CategoryID.Valid {
 		categoryID = transaction.CategoryID.String
@@ -583,8 +556,8 @@ func AddTransfer(transaction Transaction) (Transaction, error) {
 	transaction.MainCategory = mainCategory
 	transaction.Subcategory = subcategory
 
-	if transaction.Date == 0 {
-		transaction.Date = time.Now().Unix()
+	if transaction.Date.IsZero() {
+		transaction.Date = timeutils.NewTimestamp(time.Now())
 	}
 
 	var exchangeRate float64
@@ -617,7 +590,7 @@ func AddTransfer(transaction Transaction) (Transaction, error) {
 	}()
 
 	// Insert the transaction into the database
-	_, err = tx.Exec(ctx,
+	err = tx.QueryRow(ctx,
 		`INSERT INTO transactions (
 		  description,
 		  amount,
@@ -632,8 +605,11 @@ func AddTransfer(transaction Transaction) (Transaction, error) {
 		  related_account_id,
 		  transaction_type,
 		  fees,
-      user_id
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		  user_id,
+		  notes,
+		  status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		RETURNING id, version`,
 		transaction.Description,
 		transaction.Amount,
 		transaction.Currency,
@@ -648,32 +624,28 @@ func AddTransfer(transaction Transaction) (Transaction, error) {
 		transaction.TransactionType,
 		transaction.Fees,
 		transaction.UserID,
-	)
+		transaction.Notes,
+		transaction.Status,
+	).Scan(&transaction.ID, &transaction.Version)
 	if err != nil {
 		tx.Rollback(ctx)
 		return Transaction{}, fmt.Errorf("failed to insert transaction: %v", err)
 	}
 
-	// Update the account balance for the source account
-	_, err = tx.Exec(ctx,
-    `UPDATE accounts SET balance = balance - ($1::NUMERIC + $2::NUMERIC) WHERE id = $3`,
-		transaction.Amount, transaction.Fees, transaction.AccountID,
-	)
-
-	if err != nil {
+	if err := setTransactionTags(ctx, tx, transaction.ID, transaction.UserID, transaction.Tags); err != nil {
 		tx.Rollback(ctx)
-		return Transaction{}, fmt.Errorf("failed to update source account balance: %v", err)
+		return Transaction{}, err
 	}
+	transaction.Tags = normalizeTagNames(transaction.Tags)
 
-	// Update the account balance for the destination account
-	_, err = tx.Exec(ctx,
-    `UPDATE accounts SET balance = balance + ($1::NUMERIC + $2::NUMERIC) WHERE id = $3`,
-		transaction.Amount, transaction.Fees, transaction.RelatedAccountID,
-	)
-
-	if err != nil {
+	// Post the transfer from the source to the destination account
+	if err := postEntries(ctx, tx, transaction.ID); err != nil {
 		tx.Rollback(ctx)
-		return Transaction{}, fmt.Errorf("failed to update destination account balance: %v", err)
+		return Transaction{}, err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditCreate, nil, transaction.ID); err != nil {
+		tx.Rollback(ctx)
+		return Transaction{}, err
 	}
 
 	// Commit the transaction
@@ -685,154 +657,111 @@ func AddTransfer(transaction Transaction) (Transaction, error) {
 	return transaction, nil
 }
 
-func DeleteTransaction(id string) error {
+// DeleteTransaction moves a transaction the user can change to the trash,
+// reconciled transactions only with override. Its effect on the balances is
+// taken out until it is restored, see RestoreTransaction. Shared expenses
+// are unshared and trades deleted through their own endpoints. A version
+// other than 0 must be the stored one.
+func DeleteTransaction(id string, version int, actor Actor, override bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	uid := actor.UserID
 
 	// Start a database transaction
 	tx, err := db.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to start database transaction: %v", err)
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback(ctx)
-			log.Printf("Recovered from panic: %v", r)
-		}
-	}()
-
-	// Fetch the transaction details to retrieve its amount and account ID
-	var transaction Transaction 
+	defer tx.Rollback(ctx)
 
-  log.Printf("Transaction ID: %s", id)
-
-	err = tx.QueryRow(ctx,
-		`SELECT amount, account_id, related_account_id, transaction_type, fees
-		 FROM transactions 
-		 WHERE id = $1`, id,
-	).Scan(
-		&transaction.Amount,
-		&transaction.AccountID,
-		&transaction.RelatedAccountID,
-		&transaction.TransactionType,
-		&transaction.Fees,
-	)
-
-  if err != nil {
-    return fmt.Errorf("Error retrieving transaction: %v", err)
-  }
-
-	if err == sql.ErrNoRows {
-		tx.Rollback(ctx)
-		return fmt.Errorf("transaction with ID %d not found", id)
-	} else if err != nil {
-		tx.Rollback(ctx)
-		return fmt.Errorf("failed to retrieve transaction: %v", err)
-	}
-
-  log.Printf("Transaction ID 2: %s", id)
-
-	// Reverse the balance change for the source account
-	_, err = tx.Exec(ctx,
-    `UPDATE accounts SET balance = balance + ($1::NUMERIC + $2::NUMERIC) WHERE id = $3`,
-		transaction.Amount, transaction.Fees, transaction.AccountID,
-	)
+	locked, err := lockTransaction(ctx, tx, id, uid)
 	if err != nil {
-		tx.Rollback(ctx)
-		return fmt.Errorf("failed to update source account balance: %v", err)
+		return err
 	}
-
-	// If the transaction is a transfer, update the related account balance as well
-	if transaction.TransactionType == TransactionTypeTransfer ||
-		transaction.TransactionType == TransactionTypeSavings &&
-		transaction.RelatedAccountID.Valid && transaction.RelatedAccountID.String != "" {
-		_, err = tx.Exec(ctx,
-      `UPDATE accounts SET balance = balance - ($1::NUMERIC + $2::NUMERIC) WHERE id = $3`,
-			transaction.Amount, transaction.Fees, transaction.RelatedAccountID,
-		)
-		if err != nil {
-			tx.Rollback(ctx)
-			return fmt.Errorf("failed to update destination account balance: %v", err)
-		}
+	if err := checkVersion(version, locked.Version, "the transaction"); err != nil {
+		return err
+	}
+	switch {
+	case locked.Status == TransactionStatusReconciled && !override:
+		return fmt.Errorf("%w: override the lock to delete it", ErrReconciled)
+	case locked.TransactionType == TransactionTypeTrade:
+		return fmt.Errorf("%w: delete the trade instead", ErrInvalidStatus)
+	case locked.Shared:
+		return fmt.Errorf("%w: the expense is shared, unshare it first", ErrInvalidShare)
+	case locked.Refunds > 0:
+		return fmt.Errorf("%w: the expense has %d refunds, delete them first", ErrInvalidRefund, locked.Refunds)
 	}
 
-	// Delete the transaction
-	_, err = tx.Exec(ctx, `DELETE FROM transactions WHERE id = $1`, id)
+	before, err := transactionSnapshots(ctx, tx, []string{id})
 	if err != nil {
-		tx.Rollback(ctx)
+		return err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE transactions SET deleted_at = CURRENT_TIMESTAMP WHERE id::text = $1", id); err != nil {
 		return fmt.Errorf("failed to delete transaction: %v", err)
 	}
+	// Deleted transactions have no postings, posting it again takes them out
+	// of the balances
+	if err := postEntries(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditDelete, before, id); err != nil {
+		return err
+	}
 
-	// Commit the transaction
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit database transaction: %v", err)
 	}
-
 	return nil
 }
 
 func GetTransactionsByAccount(accountID string, uid string) ([]Transaction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	// Prepare the SQL query
-	query := `
-		SELECT 
-			id, 
-			description, 
-			amount, 
-			currency, 
-			amount_in_base_currency, 
-			exchange_rate, 
-			date, 
-			main_category, 
-			subcategory, 
-			category_id, 
-			account_id, 
-			related_account_id, 
-			transaction_type, 
-			fees 
-		FROM transactions 
-		WHERE account_id = $1 AND user_id = $2
-	`
-
-	// Initialize a slice to hold the transactions
-	var transactions []Transaction
-
-	// Execute the query
-	rows, err := db.Query(ctx, query, accountID, uid)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch transactions: %w", err)
-	}
-	defer rows.Close() // Ensure rows are closed
-
-	// Iterate through the result set and scan into the slice
-	for rows.Next() {
-		var transaction Transaction
-		err := rows.Scan(
-			&transaction.ID,
-			&transaction.Description,
-			&transaction.Amount,
-			&transaction.Currency,
-			&transaction.AmountInBaseCurrency,
-			&transaction.ExchangeRate,
-			&transaction.Date,
-			&transaction.MainCategory,
-			&transaction.Subcategory,
-			&transaction.CategoryID,
-			&transaction.AccountID,
-			&transaction.RelatedAccountID,
-			&transaction.TransactionType,
-			&transaction.Fees,
-		)
+	return FindTransactions(TransactionFilter{UserID: uid, AccountID: accountID}, 0)
+}
+
+// assignPayee matches the payee of a transaction without one and uses the
+// payee's default category when the transaction has none
+func assignPayee(transaction *Transaction) error {
+	var payee Payee
+	if transaction.PayeeID.Valid && transaction.PayeeID.String != "" {
+		var err error
+		payee, err = GetPayee(transaction.PayeeID.String, transaction.UserID)
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("%w: no payee with id '%s'", ErrInvalidPayee, transaction.PayeeID.String)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan transaction: %w", err)
+			return err
 		}
-		transactions = append(transactions, transaction)
+	} else {
+		matched, ok, err := MatchPayee(transaction.Description, transaction.UserID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			transaction.PayeeID = null.String{}
+			transaction.Payee = ""
+			return nil
+		}
+		payee = matched
+	}
+
+	transaction.PayeeID = null.StringFrom(payee.ID)
+	transaction.Payee = payee.Name
+	if !transaction.CategoryID.Valid && len(transaction.Splits) == 0 {
+		transaction.CategoryID = payee.DefaultCategoryID
+	}
+	return nil
+}
+
+// inUserZone converts the transaction dates to the user's time zone so they
+// are emitted with the user's UTC offset
+func inUserZone(transactions []Transaction, uid string) ([]Transaction, error) {
+	loc, err := GetUserLocation(uid)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check for any error encountered during iteration
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error occurred during rows iteration: %w", err)
+	for i := range transactions {
+		transactions[i].Date = transactions[i].Date.In(loc)
 	}
 
 	return transactions, nil
@@ -0,0 +1,397 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	SortByDate      = "date"
+	SortByAmount    = "amount"
+	SortByCreatedAt = "created_at"
+)
+
+const (
+	DefaultTransactionLimit = 50
+	MaxTransactionLimit     = 500
+)
+
+// ErrInvalidQuery wraps errors caused by the client's sort or cursor
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrNotFound is returned when a row does not exist or belongs to another user
+var ErrNotFound = errors.New("not found")
+
+// transactionColumns is the column list read by scanTransaction
+const transactionColumns = `t.id, t.description, t.amount, t.currency, t.amount_in_base_currency,
+	t.exchange_rate, t.date, t.main_category, t.subcategory, t.category_id, t.account_id,
+	t.related_account_id, t.transaction_type, t.fees, t.user_id, t.notes,
+	ARRAY(SELECT tg.name FROM transaction_tags tt JOIN tags tg ON tg.id = tt.tag_id
+		WHERE tt.transaction_id = t.id ORDER BY tg.name) AS tags, ` + splitsColumn + `,
+	t.payee_id, COALESCE((SELECT p.name FROM payees p WHERE p.id = t.payee_id), '') AS payee, t.refund_of_id,
+	t.status, t.version`
+
+// TransactionFilter narrows down the transactions of a user. Zero values
+// are ignored. Amount bounds apply to the absolute amount so the same range
+// works for expenses (stored negative) and incomes. Tags matches
+// transactions having any of the tags. Category filters match split
+// transactions having a line in the category. With RefundsInOriginalPeriod
+// the date range applies to the refunded expense for refunds. Void
+// transactions are only matched when filtering on their status.
+//
+// The user sees the transactions of their personal accounts and of the
+// accounts of their households. HouseholdID narrows them down to one
+// household, Personal to the personal accounts and Writable to the
+// transactions the user can change. Budgeted leaves out the accounts
+// excluded from budgets.
+type TransactionFilter struct {
+	UserID          string
+	HouseholdID     string
+	Personal        bool
+	Writable        bool
+	IDs             []string
+	From            time.Time
+	To              time.Time
+	MinAmount       null.Float
+	MaxAmount       null.Float
+	CategoryIDs     []string
+	MainCategory    string
+	Currency        string
+	AccountID       string
+	PayeeID         string
+	TransactionType string
+	Description     string
+	Tags            []string
+	RefundOfID      string
+	Status          string
+	Budgeted        bool
+
+	RefundsInOriginalPeriod bool
+}
+
+// TransactionQuery is a filtered, sorted page of transactions
+type TransactionQuery struct {
+	Filter TransactionFilter
+	SortBy string // "date" (default), "amount" or "created_at"
+	Desc   bool
+	Limit  int
+	Cursor string // next_cursor of the previous page
+}
+
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   null.String   `json:"next_cursor"`
+	TotalCount   int           `json:"total_count"`
+}
+
+// transactionCursor is the position after the last row of a page. It is
+// only valid for the sort it was created with.
+type transactionCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+// queryArgs collects positional arguments while building a query
+type queryArgs []interface{}
+
+// add appends a value and returns its placeholder
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// unfiltered reports whether the filter matches every transaction the user
+// can see, whatever household or scope they are in
+func (f TransactionFilter) unfiltered() bool {
+	return len(f.IDs) == 0 && f.From.IsZero() && f.To.IsZero() && !f.MinAmount.Valid && !f.MaxAmount.Valid &&
+		len(f.CategoryIDs) == 0 && f.MainCategory == "" && f.Currency == "" && f.AccountID == "" && f.PayeeID == "" &&
+		f.TransactionType == "" && f.Description == "" && len(f.Tags) == 0 && f.RefundOfID == "" && f.Status == "" && !f.Budgeted
+}
+
+// where builds the WHERE clause of the filter, the transactions table is aliased as t
+func (f TransactionFilter) where(args *queryArgs) string {
+	uid := args.add(f.UserID)
+	conditions := []string{visibleTransaction(uid)}
+
+	if f.Writable {
+		conditions = append(conditions, writableTransaction(uid))
+	}
+	if f.HouseholdID != "" {
+		conditions = append(conditions, "t.account_id IN (SELECT id FROM accounts WHERE household_id::text = "+args.add(f.HouseholdID)+")")
+	}
+	if f.Personal {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM accounts ha WHERE ha.id = t.account_id AND ha.household_id IS NOT NULL)")
+	}
+	if f.Budgeted {
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM accounts ba WHERE ba.id = t.account_id AND NOT ba.include_in_budget)")
+	}
+
+	if len(f.IDs) > 0 {
+		conditions = append(conditions, "t.id::text = ANY("+args.add(f.IDs)+"::text[])")
+	}
+
+	date := "t.date"
+	if f.RefundsInOriginalPeriod {
+		date = refundOriginalDate
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, date+" >= "+args.add(f.From))
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, date+" <= "+args.add(f.To))
+	}
+	if f.MinAmount.Valid {
+		conditions = append(conditions, "ABS(t.amount) >= "+args.add(f.MinAmount.Float64))
+	}
+	if f.MaxAmount.Valid {
+		conditions = append(conditions, "ABS(t.amount) <= "+args.add(f.MaxAmount.Float64))
+	}
+	if len(f.CategoryIDs) > 0 {
+		conditions = append(conditions, splitAware("category_id::text = ANY("+args.add(f.CategoryIDs)+"::text[])"))
+	}
+	if f.MainCategory != "" {
+		conditions = append(conditions, splitAware("main_category = "+args.add(f.MainCategory)))
+	}
+	if f.Currency != "" {
+		conditions = append(conditions, "t.currency = "+args.add(strings.ToUpper(f.Currency)))
+	}
+	if f.AccountID != "" {
+		conditions = append(conditions, "t.account_id = "+args.add(f.AccountID))
+	}
+	if f.PayeeID != "" {
+		conditions = append(conditions, "t.payee_id::text = "+args.add(f.PayeeID))
+	}
+	if f.RefundOfID != "" {
+		conditions = append(conditions, "t.refund_of_id::text = "+args.add(f.RefundOfID))
+	}
+	if f.TransactionType != "" {
+		conditions = append(conditions, "t.transaction_type = "+args.add(f.TransactionType))
+	}
+	if f.Status != "" {
+		conditions = append(conditions, "t.status = "+args.add(f.Status))
+	} else {
+		conditions = append(conditions, "t.status <> '"+TransactionStatusVoid+"'")
+	}
+	if f.Description != "" {
+		conditions = append(conditions, "t.description ILIKE "+args.add("%"+escapeLike(f.Description)+"%"))
+	}
+	if len(f.Tags) > 0 {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM transaction_tags ftt JOIN tags ftg ON ftg.id = ftt.tag_id
+			WHERE ftt.transaction_id = t.id AND ftg.name = ANY(`+args.add(f.Tags)+`::text[]))`)
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// splitAware applies a category condition to the lines of split
+// transactions and to the transaction itself otherwise
+func splitAware(condition string) string {
+	return `(CASE WHEN EXISTS (SELECT 1 FROM transaction_splits fs WHERE fs.transaction_id = t.id)
+		THEN EXISTS (SELECT 1 FROM transaction_splits fs WHERE fs.transaction_id = t.id AND fs.` + condition + `)
+		ELSE t.` + condition + ` END)`
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// sortColumn returns the column and the placeholder cast used for keyset comparison
+func sortColumn(sortBy string) (string, string, error) {
+	switch sortBy {
+	case "", SortByDate:
+		return "t.date", "::timestamptz", nil
+	case SortByAmount:
+		return "t.amount", "::real", nil
+	case SortByCreatedAt:
+		return "t.created_at", "::timestamp", nil
+	}
+	return "", "", fmt.Errorf("%w: unknown sort '%s', use date, amount or created_at", ErrInvalidQuery, sortBy)
+}
+
+func encodeCursor(cursor transactionCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (transactionCursor, error) {
+	var cursor transactionCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return cursor, nil
+}
+
+func scanTransaction(row pgx.Row, extra ...interface{}) (Transaction, error) {
+	var transaction Transaction
+	dest := []interface{}{
+		&transaction.ID,
+		&transaction.Description,
+		&transaction.Amount,
+		&transaction.Currency,
+		&transaction.AmountInBaseCurrency,
+		&transaction.ExchangeRate,
+		&transaction.Date,
+		&transaction.MainCategory,
+		&transaction.Subcategory,
+		&transaction.CategoryID,
+		&transaction.AccountID,
+		&transaction.RelatedAccountID,
+		&transaction.TransactionType,
+		&transaction.Fees,
+		&transaction.UserID,
+		&transaction.Notes,
+		&transaction.Tags,
+		&transaction.Splits,
+		&transaction.PayeeID,
+		&transaction.Payee,
+		&transaction.RefundOfID,
+		&transaction.Status,
+		&transaction.Version,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return transaction, err
+}
+
+// QueryTransactions returns a page of transactions using keyset pagination
+// on (sort column, id), plus the total number of rows matching the filter
+func QueryTransactions(q TransactionQuery) (TransactionPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	column, cast, err := sortColumn(q.SortBy)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+	if q.SortBy == "" {
+		q.SortBy = SortByDate
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultTransactionLimit
+	}
+	if q.Limit > MaxTransactionLimit {
+		q.Limit = MaxTransactionLimit
+	}
+
+	var page TransactionPage
+
+	var countArgs queryArgs
+	countQuery := "SELECT COUNT(*) FROM transactions t" + q.Filter.where(&countArgs)
+	if err := db.QueryRow(ctx, countQuery, countArgs...).Scan(&page.TotalCount); err != nil {
+		return TransactionPage{}, fmt.Errorf("failed to count transactions: %v", err)
+	}
+
+	var args queryArgs
+	query := "SELECT " + transactionColumns + ", " + column + "::text FROM transactions t" + q.Filter.where(&args)
+
+	direction, comparison := "ASC", ">"
+	if q.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return TransactionPage{}, err
+		}
+		if cursor.SortBy != q.SortBy || cursor.Desc != q.Desc {
+			return TransactionPage{}, fmt.Errorf("%w: cursor does not match the requested sort", ErrInvalidQuery)
+		}
+		query += fmt.Sprintf(" AND (%s, t.id) %s (%s%s, %s::uuid)",
+			column, comparison, args.add(cursor.Value), cast, args.add(cursor.ID))
+	}
+
+	query += fmt.Sprintf(" ORDER BY %s %s, t.id %s", column, direction, direction)
+	// Fetch one extra row to know whether there is a next page
+	query += " LIMIT " + args.add(q.Limit+1)
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return TransactionPage{}, fmt.Errorf("failed to fetch transactions: %v", err)
+	}
+	defer rows.Close()
+
+	var sortValues []string
+	for rows.Next() {
+		var sortValue string
+		transaction, err := scanTransaction(rows, &sortValue)
+		if err != nil {
+			return TransactionPage{}, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		page.Transactions = append(page.Transactions, transaction)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return TransactionPage{}, fmt.Errorf("error occurred during rows iteration: %v", err)
+	}
+
+	if len(page.Transactions) > q.Limit {
+		page.Transactions = page.Transactions[:q.Limit]
+		last := page.Transactions[q.Limit-1]
+		page.NextCursor = null.StringFrom(encodeCursor(transactionCursor{
+			SortBy: q.SortBy,
+			Desc:   q.Desc,
+			Value:  sortValues[q.Limit-1],
+			ID:     last.ID,
+		}))
+	}
+
+	if page.Transactions == nil {
+		page.Transactions = []Transaction{}
+	}
+
+	page.Transactions, err = inUserZone(page.Transactions, q.Filter.UserID)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+
+	return page, nil
+}
+
+// FindTransactions returns every transaction matching the filter, newest first
+func FindTransactions(filter TransactionFilter, limit int) ([]Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var args queryArgs
+	query := "SELECT " + transactionColumns + " FROM transactions t" + filter.where(&args) +
+		" ORDER BY t.date DESC, t.id DESC"
+	if limit > 0 {
+		query += " LIMIT " + args.add(limit)
+	}
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error occurred during rows iteration: %w", err)
+	}
+
+	return inUserZone(transactions, filter.UserID)
+}
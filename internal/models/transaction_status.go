@@ -0,0 +1,255 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"math"
+	"time"
+
+	"github.com/guregu/null/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	TransactionStatusPending    = "pending"    // Authorized but not settled by the bank, counts in the available balance only
+	TransactionStatusCleared    = "cleared"    // Settled, the default
+	TransactionStatusReconciled = "reconciled" // Cleared in a finished reconciliation, locked
+	TransactionStatusVoid       = "void"       // Cancelled, has no effect on balances and reports
+)
+
+// ErrInvalidStatus is returned when a transaction's status doesn't allow a change
+var ErrInvalidStatus = errors.New("invalid transaction status")
+
+// initialStatus checks the status of a new transaction, which is cleared
+// unless it is pending
+func initialStatus(status string) (string, error) {
+	switch status {
+	case "":
+		return TransactionStatusCleared, nil
+	case TransactionStatusPending, TransactionStatusCleared:
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: new transactions are pending or cleared, not '%s'", ErrInvalidStatus, status)
+}
+
+// voidTransactions marks transactions void, which removes their postings
+// from the balances
+func voidTransactions(ctx context.Context, tx pgx.Tx, ids []string) error {
+	if _, err := tx.Exec(ctx, "UPDATE transactions SET status = $2 WHERE id::text = ANY($1)", ids, TransactionStatusVoid); err != nil {
+		return fmt.Errorf("failed to void transactions: %v", err)
+	}
+	return postEntries(ctx, tx, ids...)
+}
+
+// lockedTransaction is what status changes need to know of a transaction
+type lockedTransaction struct {
+	Status          string
+	Amount          float64
+	Date            timeutils.Timestamp
+	TransactionType string
+	Splits          bool
+	Shared          bool
+	Refunds         int
+	Version         int
+}
+
+// lockTransaction locks a transaction the user can change
+func lockTransaction(ctx context.Context, tx pgx.Tx, id string, uid string) (lockedTransaction, error) {
+	var locked lockedTransaction
+	var writable bool
+	err := tx.QueryRow(ctx, `
+		SELECT t.status, t.amount, t.date, t.transaction_type,
+			EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id),
+			EXISTS (SELECT 1 FROM shared_expenses se WHERE se.transaction_id = t.id),
+			(SELECT COUNT(*) FROM transactions r WHERE r.refund_of_id = t.id AND r.status <> 'void' AND r.deleted_at IS NULL),
+			`+writableTransaction("$2")+`, t.version
+		FROM transactions t
+		WHERE t.id::text = $1 AND `+visibleTransaction("$2")+`
+		FOR UPDATE OF t`, id, uid).Scan(&locked.Status, &locked.Amount, &locked.Date, &locked.TransactionType,
+		&locked.Splits, &locked.Shared, &locked.Refunds, &writable, &locked.Version)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return lockedTransaction{}, fmt.Errorf("%w: transaction %s", ErrNotFound, id)
+		}
+		return lockedTransaction{}, fmt.Errorf("failed to retrieve transaction: %v", err)
+	}
+	if !writable {
+		return lockedTransaction{}, fmt.Errorf("%w: viewers can't change transactions", ErrForbidden)
+	}
+	return locked, nil
+}
+
+// SettleTransaction clears a pending transaction with the amount and date
+// the bank settled it with, the balances follow the difference. Without an
+// amount or a date the pending ones are kept.
+func SettleTransaction(id string, amount null.Float, date timeutils.Timestamp, actor Actor) (Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	locked, err := lockTransaction(ctx, tx, id, uid)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if locked.Status != TransactionStatusPending {
+		return Transaction{}, fmt.Errorf("%w: only pending transactions can be settled, this one is %s", ErrInvalidStatus, locked.Status)
+	}
+
+	if !amount.Valid {
+		amount = null.FloatFrom(locked.Amount)
+	}
+	if date.IsZero() {
+		date = locked.Date
+	}
+
+	if amount.Float64 != locked.Amount {
+		if locked.Splits {
+			return Transaction{}, fmt.Errorf("%w: the transaction is split, update its splits to change its amount", ErrInvalidSplits)
+		}
+		if locked.Shared {
+			return Transaction{}, fmt.Errorf("%w: the expense is shared, share it again to change its amount", ErrInvalidShare)
+		}
+		refunded, err := refundedAmount(ctx, tx, id, "")
+		if err != nil {
+			return Transaction{}, err
+		}
+		if math.Abs(amount.Float64)+splitTolerance < refunded {
+			return Transaction{}, fmt.Errorf("%w: %.2f of this expense is refunded, it can't settle for less", ErrInvalidRefund, refunded)
+		}
+	}
+
+	before, err := transactionSnapshots(ctx, tx, []string{id})
+	if err != nil {
+		return Transaction{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE transactions
+		SET amount = $2, amount_in_base_currency = $2 * exchange_rate, date = $3, status = $4
+		WHERE id::text = $1`, id, amount.Float64, date, TransactionStatusCleared)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("failed to settle transaction: %v", err)
+	}
+	if err := postEntries(ctx, tx, id); err != nil {
+		return Transaction{}, err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditUpdate, before, id); err != nil {
+		return Transaction{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Transaction{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return GetTransactionByID(id, uid)
+}
+
+// VoidTransaction cancels a transaction without deleting it, its effect on
+// the balances is reversed. Reconciled transactions are only voided with
+// override.
+func VoidTransaction(id string, actor Actor, override bool) (Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	locked, err := lockTransaction(ctx, tx, id, uid)
+	if err != nil {
+		return Transaction{}, err
+	}
+	switch {
+	case locked.Status == TransactionStatusVoid:
+		return Transaction{}, fmt.Errorf("%w: the transaction is already void", ErrInvalidStatus)
+	case locked.Status == TransactionStatusReconciled && !override:
+		return Transaction{}, fmt.Errorf("%w: override the lock to void it", ErrReconciled)
+	case locked.TransactionType == TransactionTypeTrade:
+		return Transaction{}, fmt.Errorf("%w: delete the trade instead", ErrInvalidStatus)
+	case locked.Shared:
+		return Transaction{}, fmt.Errorf("%w: the expense is shared, unshare it first", ErrInvalidShare)
+	case locked.Refunds > 0:
+		return Transaction{}, fmt.Errorf("%w: the expense has %d refunds, void them first", ErrInvalidRefund, locked.Refunds)
+	}
+
+	before, err := transactionSnapshots(ctx, tx, []string{id})
+	if err != nil {
+		return Transaction{}, err
+	}
+	if err := voidTransactions(ctx, tx, []string{id}); err != nil {
+		return Transaction{}, err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditUpdate, before, id); err != nil {
+		return Transaction{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Transaction{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return GetTransactionByID(id, uid)
+}
+
+// ExpirePendingTransactions voids the pending transactions older than the
+// pending_expiry_days setting of the user who added them. Shared expenses
+// and expenses with refunds are left to the user.
+func ExpirePendingTransactions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT t.id::text FROM transactions t
+		LEFT JOIN user_settings us ON us.user_id = t.user_id
+		WHERE t.status = $1 AND t.deleted_at IS NULL
+		  AND COALESCE(us.pending_expiry_days, $2) > 0
+		  AND t.date < CURRENT_TIMESTAMP - make_interval(days => COALESCE(us.pending_expiry_days, $2))
+		  AND NOT EXISTS (SELECT 1 FROM shared_expenses se WHERE se.transaction_id = t.id)
+		  AND NOT EXISTS (SELECT 1 FROM transactions r WHERE r.refund_of_id = t.id AND r.status <> 'void' AND r.deleted_at IS NULL)
+		FOR UPDATE OF t`, TransactionStatusPending, DefaultPendingExpiryDays)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve stale pending transactions: %v", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := voidTransactions(ctx, tx, ids); err != nil {
+		return err
+	}
+	expired := map[string]AuditChange{"status": {Before: TransactionStatusPending, After: TransactionStatusVoid}}
+	if err := recordJobAudit(ctx, tx, AuditUpdate, expired, ids); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	log.Printf("Voided %d expired pending transactions", len(ids))
+	return nil
+}
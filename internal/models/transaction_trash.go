@@ -0,0 +1,153 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// trashedTransaction is the condition matching the transactions, aliased as
+// t, in the trash of the user: the deleted transactions they could see
+func trashedTransaction(uid string) string {
+	return "(t.deleted_at IS NOT NULL AND (t.account_id IN (" + visibleAccounts(uid) + ") OR (t.account_id IS NULL AND t.user_id = " + uid + ")))"
+}
+
+// GetTrash lists the deleted transactions of the user, last deleted first
+func GetTrash(uid string) ([]Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(ctx, `
+		SELECT `+transactionColumns+`, t.deleted_at
+		FROM transactions t
+		WHERE `+trashedTransaction("$1")+`
+		ORDER BY t.deleted_at DESC, t.id`, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve trash: %v", err)
+	}
+	defer rows.Close()
+
+	transactions := []Transaction{}
+	for rows.Next() {
+		var deletedAt timeutils.Timestamp
+		transaction, err := scanTransaction(rows, &deletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transaction.DeletedAt = deletedAt
+		transactions = append(transactions, transaction)
+	}
+	return transactions, rows.Err()
+}
+
+// RestoreTransaction takes a transaction out of the trash and applies its
+// effect on the balances again. Its accounts must still be open and, for a
+// refund, its expense restored and not refunded in full since.
+func RestoreTransaction(id string, actor Actor) (Transaction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var transaction Transaction
+	var writable, archived bool
+	err = tx.QueryRow(ctx, `
+		SELECT t.amount, t.refund_of_id::text, `+writableTransaction("$2")+`,
+			EXISTS (SELECT 1 FROM accounts a WHERE a.id IN (t.account_id, t.related_account_id) AND a.archived_at IS NOT NULL)
+		FROM transactions t
+		WHERE t.id::text = $1 AND `+trashedTransaction("$2")+`
+		FOR UPDATE OF t`, id, uid).Scan(&transaction.Amount, &transaction.RefundOfID, &writable, &archived)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return Transaction{}, fmt.Errorf("%w: no transaction %s in the trash", ErrNotFound, id)
+		}
+		return Transaction{}, fmt.Errorf("failed to retrieve transaction: %v", err)
+	}
+	if !writable {
+		return Transaction{}, fmt.Errorf("%w: viewers can't restore transactions", ErrForbidden)
+	}
+	if archived {
+		return Transaction{}, fmt.Errorf("%w: reopen its accounts to restore the transaction", ErrAccountArchived)
+	}
+	if transaction.RefundOfID.Valid {
+		transaction.UserID = uid
+		if err := checkRefundLimit(ctx, tx, transaction, id); err != nil {
+			return Transaction{}, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE transactions SET deleted_at = NULL WHERE id::text = $1", id); err != nil {
+		return Transaction{}, fmt.Errorf("failed to restore transaction: %v", err)
+	}
+	if err := postEntries(ctx, tx, id); err != nil {
+		return Transaction{}, err
+	}
+	if err := auditTransactions(ctx, tx, actor, AuditRestore, nil, id); err != nil {
+		return Transaction{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Transaction{}, fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	return GetTransactionByID(id, uid)
+}
+
+// PurgeTrash deletes for good the transactions deleted longer ago than the
+// trash_retention_days setting of the user who added them, with their
+// attachments, and the expired undo tokens. The purge is kept in the audit
+// log of those users.
+func PurgeTrash() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := transactionIDs(ctx, tx, `deleted_at < CURRENT_TIMESTAMP - make_interval(days => COALESCE(
+			(SELECT us.trash_retention_days FROM user_settings us WHERE us.user_id = transactions.user_id), $1))
+		FOR UPDATE`, DefaultTrashRetentionDays)
+	if err != nil {
+		return err
+	}
+
+	// Refunds still around lose their purged expense
+	_, err = tx.Exec(ctx, "UPDATE transactions SET refund_of_id = NULL WHERE refund_of_id::text = ANY($1) AND NOT (id::text = ANY($1))", ids)
+	if err != nil {
+		return fmt.Errorf("failed to detach refunds: %v", err)
+	}
+	// Attachment rows cascade, their files are removed after the commit
+	attachments, err := attachmentKeys(ctx, tx, "t.id::text = ANY($1)", ids)
+	if err != nil {
+		return err
+	}
+	if err := recordJobAudit(ctx, tx, AuditPurge, map[string]AuditChange{}, ids); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM transactions WHERE id::text = ANY($1)", ids); err != nil {
+		return fmt.Errorf("failed to purge transactions: %v", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM undo_tokens WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+		return fmt.Errorf("failed to remove expired undo tokens: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit database transaction: %v", err)
+	}
+	deleteBlobs(attachments)
+	if len(ids) > 0 {
+		log.Printf("Purged %d transactions from the trash", len(ids))
+	}
+	return nil
+}
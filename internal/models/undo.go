@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"guilliman/internal/utils/timeutils"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	UndoCreate = "create" // Undone by deleting the transaction
+	UndoUpdate = "update" // Undone by updating the transaction back to its previous state
+	UndoDelete = "delete" // Undone by restoring the transaction from the trash
+)
+
+// undoWindow is how long an undo token can be used
+const undoWindow = 10 * time.Minute
+
+// UndoToken lets a client undo a change to a transaction shortly after it.
+// The token is only stored hashed and can be used once.
+type UndoToken struct {
+	Token     string              `json:"token"`
+	ExpiresAt timeutils.Timestamp `json:"expires_at"`
+}
+
+// IssueUndoToken records how to undo a change the user just made to a
+// transaction. Updates need the transaction as it was before.
+func IssueUndoToken(uid string, transactionID string, action string, previous *Transaction) (UndoToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var snapshot []byte
+	if action == UndoUpdate {
+		if previous == nil {
+			return UndoToken{}, fmt.Errorf("undoing an update needs the previous transaction")
+		}
+		// Omitted tags and splits are kept on update, the previous ones are
+		// restored even when there were none
+		restored := *previous
+		if restored.Tags == nil {
+			restored.Tags = []string{}
+		}
+		if restored.Splits == nil {
+			restored.Splits = []TransactionSplit{}
+		}
+		var err error
+		if snapshot, err = json.Marshal(restored); err != nil {
+			return UndoToken{}, fmt.Errorf("failed to encode previous transaction: %v", err)
+		}
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return UndoToken{}, fmt.Errorf("failed to generate undo token: %v", err)
+	}
+	token := UndoToken{
+		Token:     base64.RawURLEncoding.EncodeToString(secret),
+		ExpiresAt: timeutils.NewTimestamp(time.Now().Add(undoWindow).Truncate(time.Second)),
+	}
+
+	_, err := db.Exec(ctx, `
+		INSERT INTO undo_tokens (token_hash, user_id, transaction_id, action, previous, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`, hashToken(token.Token), uid, transactionID, action, snapshot, token.ExpiresAt)
+	if err != nil {
+		return UndoToken{}, fmt.Errorf("failed to store undo token: %v", err)
+	}
+	return token, nil
+}
+
+// undoTarget looks up the transaction and the change an undo token of the
+// user can still undo, with the previous transaction of updates
+func undoTarget(ctx context.Context, token string, uid string) (string, string, []byte, error) {
+	var transactionID, action string
+	var snapshot []byte
+	err := db.QueryRow(ctx, `
+		SELECT transaction_id::text, action, previous
+		FROM undo_tokens
+		WHERE token_hash = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP`, hashToken(token), uid).Scan(&transactionID, &action, &snapshot)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", "", nil, fmt.Errorf("%w: the undo token is unknown or expired", ErrNotFound)
+		}
+		return "", "", nil, fmt.Errorf("failed to retrieve undo token: %v", err)
+	}
+	return transactionID, action, snapshot, nil
+}
+
+// Undo reverts the change an undo token of the actor was issued for, the
+// revert being audited like the change itself. The token is used up once the
+// change is reverted.
+func Undo(token string, actor Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	uid := actor.UserID
+
+	transactionID, action, snapshot, err := undoTarget(ctx, token, uid)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case UndoCreate:
+		err = DeleteTransaction(transactionID, 0, actor, false)
+	case UndoUpdate:
+		var previous Transaction
+		if err := json.Unmarshal(snapshot, &previous); err != nil {
+			return fmt.Errorf("failed to decode previous transaction: %v", err)
+		}
+		previous.ID = transactionID
+		previous.UserID = uid
+		previous.Version = 0
+		_, err = UpdateTransaction(transactionID, previous, false, actor)
+	case UndoDelete:
+		_, err = RestoreTransaction(transactionID, actor)
+	default:
+		err = fmt.Errorf("unknown undo action '%s'", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(ctx, "DELETE FROM undo_tokens WHERE token_hash = $1", hashToken(token)); err != nil {
+		return fmt.Errorf("failed to use up undo token: %v", err)
+	}
+	return nil
+}
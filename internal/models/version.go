@@ -0,0 +1,19 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrVersionMismatch is returned when a change is based on another version
+// of a transaction, account or category than the stored one
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// checkVersion compares the version a change is based on, 0 when the client
+// didn't give one, with the current version of an entity
+func checkVersion(expected int, current int, entity string) error {
+	if expected != 0 && expected != current {
+		return fmt.Errorf("%w: %s is at version %d, the change was based on version %d", ErrVersionMismatch, entity, current, expected)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package prices
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the date formats accepted in CSV files
+var dateLayouts = []string{"2006-01-02", time.RFC3339, "2006/01/02", "02.01.2006"}
+
+// ParseCSV reads prices from a CSV file with a header row. The columns are
+// found by name: "date", "price" or "close", and optionally "symbol" and
+// "currency". Files without a symbol column hold the prices of the given
+// symbol. Commas and semicolons are accepted as separators.
+func ParseCSV(r io.Reader, symbol string) ([]Quote, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	content := strings.TrimPrefix(string(data), "\ufeff")
+
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	if header, _, _ := strings.Cut(content, "\n"); strings.Count(header, ";") > strings.Count(header, ",") {
+		reader.Comma = ';'
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("the CSV file has no header row")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	dateColumn, ok := columns["date"]
+	if !ok {
+		return nil, fmt.Errorf("the CSV file has no date column")
+	}
+	priceColumn, ok := columns["price"]
+	if !ok {
+		if priceColumn, ok = columns["close"]; !ok {
+			return nil, fmt.Errorf("the CSV file has no price or close column")
+		}
+	}
+	symbolColumn, hasSymbol := columns["symbol"]
+	currencyColumn, hasCurrency := columns["currency"]
+	if !hasSymbol && strings.TrimSpace(symbol) == "" {
+		return nil, fmt.Errorf("the CSV file has no symbol column, give the symbol of its prices")
+	}
+
+	var quotes []Quote
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		field := func(i int) string {
+			if i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		quote := Quote{Symbol: NormalizeSymbol(symbol)}
+		if hasSymbol && field(symbolColumn) != "" {
+			quote.Symbol = NormalizeSymbol(field(symbolColumn))
+		}
+		if hasCurrency {
+			quote.Currency = strings.ToUpper(field(currencyColumn))
+		}
+
+		if quote.Date, err = parseDate(field(dateColumn)); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		price := field(priceColumn)
+		if reader.Comma == ';' {
+			price = strings.ReplaceAll(price, ",", ".")
+		}
+		if quote.Price, err = strconv.ParseFloat(price, 64); err != nil || quote.Price < 0 {
+			return nil, fmt.Errorf("line %d: invalid price '%s'", line, field(priceColumn))
+		}
+
+		quotes = append(quotes, quote)
+	}
+
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("the CSV file has no prices")
+	}
+	return quotes, nil
+}
+
+// NormalizeSymbol makes symbols case insensitive
+func NormalizeSymbol(symbol string) string {
+	return strings.ToUpper(strings.TrimSpace(symbol))
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date '%s', use YYYY-MM-DD", value)
+}
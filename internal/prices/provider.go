@@ -0,0 +1,49 @@
+package prices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/config"
+	"time"
+)
+
+// ErrNoProvider is returned when prices can only be uploaded
+var ErrNoProvider = errors.New("no price provider configured, upload prices as CSV")
+
+// Quote is the closing price of a security on a day
+type Quote struct {
+	Symbol   string    `json:"symbol"`
+	Date     time.Time `json:"date"`
+	Price    float64   `json:"price"`
+	Currency string    `json:"currency"` // Empty when the source doesn't say
+}
+
+// Provider loads price history from an outside source
+type Provider interface {
+	Name() string
+	// Quotes returns the daily prices of a symbol between two days, both included
+	Quotes(ctx context.Context, symbol string, from time.Time, to time.Time) ([]Quote, error)
+}
+
+// NewFromConfig creates the provider selected by PRICE_PROVIDER
+func NewFromConfig() (Provider, error) {
+	switch config.GetPriceProvider() {
+	case "", "manual":
+		return ManualProvider{}, nil
+	case "stooq":
+		return NewStooqProvider(config.GetPriceProviderURL()), nil
+	}
+	return nil, fmt.Errorf("unknown price provider '%s', use manual or stooq", config.GetPriceProvider())
+}
+
+// ManualProvider fetches nothing, prices are uploaded by the users
+type ManualProvider struct{}
+
+func (ManualProvider) Name() string {
+	return "manual"
+}
+
+func (ManualProvider) Quotes(ctx context.Context, symbol string, from time.Time, to time.Time) ([]Quote, error) {
+	return nil, ErrNoProvider
+}
@@ -0,0 +1,66 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const stooqURL = "https://stooq.com/q/d/l/"
+
+// StooqProvider downloads daily closing prices as CSV from stooq.com, or a
+// service with the same interface. Symbols use stooq's notation, e.g.
+// "AAPL.US".
+type StooqProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewStooqProvider(baseURL string) *StooqProvider {
+	if baseURL == "" {
+		baseURL = stooqURL
+	}
+	return &StooqProvider{baseURL: baseURL, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (p *StooqProvider) Name() string {
+	return "stooq"
+}
+
+func (p *StooqProvider) Quotes(ctx context.Context, symbol string, from time.Time, to time.Time) ([]Quote, error) {
+	query := url.Values{}
+	query.Set("s", strings.ToLower(symbol))
+	query.Set("i", "d")
+	query.Set("d1", from.Format("20060102"))
+	query.Set("d2", to.Format("20060102"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch prices of %s: %v", symbol, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("failed to fetch prices of %s: %s", symbol, string(body))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prices of %s: %v", symbol, err)
+	}
+	// Unknown symbols and empty ranges are answered with "No data"
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "No data") {
+		return nil, nil
+	}
+
+	return ParseCSV(strings.NewReader(string(body)), symbol)
+}
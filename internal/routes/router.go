@@ -12,30 +12,96 @@ import (
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
 	c := controller.NewController()
+	r.Use(middleware.RequestIDMiddleware())
 
 	v1 := r.Group("/api/v1")
 	{
-		categories := v1.Group("/categories", middleware.AuthMiddleware())
+		categories := v1.Group("/categories", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			categories.GET("", c.GetCategoriesController)
 			categories.POST("", c.CreateCategoryController)
 			categories.PUT("/:id", c.UpdateCategoryController)
 			categories.DELETE("/:id", c.DeleteCategoryController)
 		}
-		accounts := v1.Group("/accounts", middleware.AuthMiddleware())
+		accounts := v1.Group("/accounts", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			accounts.GET("", c.GetAccountsController)
 			accounts.POST("", c.AddAccountController)
-			accounts.PUT("/accounts/:id", c.UpdateAccountController)
-			accounts.DELETE(":id", c.DeleteAccountController)
+			accounts.PUT("/:id", c.UpdateAccountController)
+			accounts.DELETE("/:id", c.DeleteAccountController)
+			accounts.POST("/:id/archive", c.ArchiveAccountController)
+			accounts.POST("/:id/unarchive", c.UnarchiveAccountController)
+			accounts.POST("/:id/purge", c.PurgeAccountController)
+			accounts.GET("/:id/history", c.GetAccountHistoryController)
+			// Groups of accounts on dashboards
+			accounts.GET("/groups", c.GetAccountGroupsController)
+			accounts.POST("/groups", c.AddAccountGroupController)
+			accounts.PUT("/groups/order", c.OrderAccountGroupsController)
+			accounts.PUT("/groups/:group_id", c.RenameAccountGroupController)
+			accounts.DELETE("/groups/:group_id", c.DeleteAccountGroupController)
+			accounts.PUT("/groups/:group_id/accounts", c.SetGroupAccountsController)
+			// Balances against the ledger of transactions
+			accounts.GET("/discrepancies", c.GetBalanceDiscrepanciesController)
+			accounts.POST("/:id/recompute", c.RecomputeBalanceController)
+			// Credit cards
+			accounts.GET("/dues", c.GetUpcomingDuesController)
+			accounts.GET("/:id/statements", c.GetStatementsController)
+			accounts.POST("/:id/statements/:statement_id/pay", c.PayStatementController)
+			// Loans
+			accounts.GET("/:id/loan/schedule", c.GetLoanScheduleController)
+			accounts.GET("/:id/loan/payments", c.GetLoanPaymentsController)
+			accounts.POST("/:id/loan/payments", c.AddLoanPaymentController)
+			accounts.POST("/:id/loan/rates", c.AddLoanRateController)
+			// Investments
+			accounts.GET("/:id/holdings", c.GetHoldingsController)
+			accounts.GET("/:id/performance", c.GetPerformanceController)
+			accounts.GET("/:id/trades", c.GetTradesController)
+			accounts.POST("/:id/trades", c.AddTradeController)
+			accounts.DELETE("/:id/trades/:trade_id", c.DeleteTradeController)
+			// Reconciliation against bank statements
+			accounts.GET("/:id/reconciliations", c.GetReconciliationsController)
+			accounts.POST("/:id/reconciliations", c.StartReconciliationController)
+			accounts.GET("/:id/reconciliations/:reconciliation_id", c.GetReconciliationController)
+			accounts.DELETE("/:id/reconciliations/:reconciliation_id", c.CancelReconciliationController)
+			accounts.POST("/:id/reconciliations/:reconciliation_id/clear", c.ClearTransactionsController)
+			accounts.POST("/:id/reconciliations/:reconciliation_id/finish", c.FinishReconciliationController)
 		}
-		transactions := v1.Group("/transactions", middleware.AuthMiddleware())
+		transactions := v1.Group("/transactions", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			transactions.GET("", c.GetTransactionsController)
 			transactions.GET("/:id", c.GetTransactionByIdController)
 			transactions.POST("", c.AddTransactionController)
 			transactions.PUT("/:id", c.UpdateTransactionController)
 			transactions.DELETE("/:id", c.DeleteTransactionController)
+			// Trash of deleted transactions and undo of recent changes
+			transactions.GET("/trash", c.GetTrashController)
+			transactions.POST("/:id/restore", c.RestoreTransactionController)
+			transactions.POST("/undo", c.UndoController)
+
+			// Audit log of the changes to a transaction
+			transactions.GET("/:id/history", c.GetTransactionHistoryController)
+
+			// Settling pending transactions and voiding
+			transactions.POST("/:id/settle", c.SettleTransactionController)
+			transactions.POST("/:id/void", c.VoidTransactionController)
+
+			// Double-entry journal
+			transactions.GET("/:id/postings", c.GetPostingsController)
+
+			// Refunds of an expense
+			transactions.GET("/:id/refunds", c.GetRefundsController)
+
+			// Expenses shared with other users
+			transactions.GET("/:id/share", c.GetTransactionShareController)
+			transactions.PUT("/:id/share", c.ShareTransactionController)
+			transactions.DELETE("/:id/share", c.UnshareTransactionController)
+
+			// Receipts and documents
+			transactions.GET("/:id/attachments", c.GetAttachmentsController)
+			transactions.POST("/:id/attachments", c.AddAttachmentController)
+
+			// Bulk tagging by ids or by filter
+			transactions.POST("/tags", c.BulkTagTransactionsController)
 
 			// Transaccions by type
 			transactions.GET("/expenses", c.GetExpensesController)                        // Tipo 'Expense'
@@ -50,22 +116,90 @@ func SetupRouter() *gin.Engine {
 			// Transactions by account
 			transactions.GET("/account/:id", c.GetTransactionsByAccountController)
 		}
-		budget := v1.Group("/budget", middleware.AuthMiddleware())
+		attachments := v1.Group("/attachments", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			attachments.GET("/:id", c.DownloadAttachmentController)
+			attachments.GET("/:id/thumbnail", c.GetAttachmentThumbnailController)
+			attachments.DELETE("/:id", c.DeleteAttachmentController)
+		}
+		payees := v1.Group("/payees", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			payees.GET("", c.GetPayeesController)
+			payees.POST("", c.AddPayeeController)
+			payees.GET("/report", c.GetPayeeReportController)
+			payees.POST("/match", c.RematchPayeesController)
+			payees.PUT("/:id", c.UpdatePayeeController)
+			payees.DELETE("/:id", c.DeletePayeeController)
+			payees.POST("/:id/aliases", c.AddPayeeAliasController)
+			payees.DELETE("/:id/aliases/:alias_id", c.DeletePayeeAliasController)
+		}
+		shared := v1.Group("/shared", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			shared.GET("", c.GetSharedExpensesController)
+			shared.GET("/balances", c.GetBalancesController)
+			shared.POST("/settle", c.SettleUpController)
+		}
+		households := v1.Group("/households", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			households.GET("", c.GetHouseholdsController)
+			households.POST("", c.AddHouseholdController)
+			households.POST("/join", c.AcceptInvitationController)
+			households.GET("/:id", c.GetHouseholdController)
+			households.PUT("/:id", c.UpdateHouseholdController)
+			households.DELETE("/:id", c.DeleteHouseholdController)
+			households.GET("/:id/invitations", c.GetInvitationsController)
+			households.POST("/:id/invitations", c.InviteToHouseholdController)
+			households.DELETE("/:id/invitations/:invitation_id", c.RevokeInvitationController)
+			households.PUT("/:id/members/:user_id", c.UpdateHouseholdMemberController)
+			households.DELETE("/:id/members/:user_id", c.RemoveHouseholdMemberController)
+			households.PUT("/:id/accounts/:account_id", c.AddHouseholdAccountController)
+			households.DELETE("/:id/accounts/:account_id", c.RemoveHouseholdAccountController)
+		}
+		investments := v1.Group("/investments", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			investments.GET("/performance", c.GetPortfolioPerformanceController)
+			investments.GET("/prices/:symbol", c.GetPricesController)
+			investments.POST("/prices", c.UploadPricesController)
+			investments.POST("/prices/refresh", c.RefreshPricesController)
+		}
+		tags := v1.Group("/tags", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			tags.GET("", c.GetTagsController)
+			tags.POST("", c.AddTagController)
+			tags.PUT("/:id", c.UpdateTagController)
+			tags.DELETE("/:id", c.DeleteTagController)
+		}
+		search := v1.Group("/search", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			search.GET("", c.SearchController)
+		}
+		auditLog := v1.Group("/audit", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
+		{
+			auditLog.GET("", c.GetAuditLogController)
+		}
+		budget := v1.Group("/budget", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			budget.GET("/summary", c.GetBudgetSummaryController)
+			budget.GET("/categories", c.GetCategoryReportController)
 		}
-		transfers := v1.Group("/transfers", middleware.AuthMiddleware())
+		transfers := v1.Group("/transfers", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			transfers.GET("", c.GetTransfersController)
 			transfers.POST("", c.TransferFundsController)
 		}
-		reset := v1.Group("/reset", middleware.AuthMiddleware())
+		reset := v1.Group("/reset", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			reset.POST("", c.ResetController)
 		}
-		user := v1.Group("/users", middleware.AuthMiddleware())
+		user := v1.Group("/users", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware())
 		{
 			user.POST("/create", c.CreateUserController)
+			user.GET("/settings", c.GetUserSettingsController)
+			user.PUT("/settings", c.UpdateUserSettingsController)
+			user.GET("/holidays", c.GetHolidaysController)
+			user.GET("/holidays/calendars", c.GetHolidayCalendarsController)
+			user.POST("/holidays/calendars", c.AddHolidayCalendarController)
+			user.DELETE("/holidays/calendars/:id", c.DeleteHolidayCalendarController)
 			// user.POST("/delete", c.DeleteUserController)
 		}
 	}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"guilliman/config"
+	"io"
+)
+
+// ErrNotFound is returned when reading a blob that does not exist
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps opaque files under slash separated keys
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes a blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// NewFromConfig creates the store selected by STORAGE_DRIVER
+func NewFromConfig() (BlobStore, error) {
+	switch config.GetStorageDriver() {
+	case "", "local":
+		return NewLocalStore(config.GetStoragePath())
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:  config.GetS3Endpoint(),
+			Region:    config.GetS3Region(),
+			Bucket:    config.GetS3Bucket(),
+			AccessKey: config.GetS3AccessKey(),
+			SecretKey: config.GetS3SecretKey(),
+		})
+	}
+	return nil, fmt.Errorf("unknown storage driver '%s', use local or s3", config.GetStorageDriver())
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// testBlobStore runs the behavior every store must have
+func testBlobStore(t *testing.T, store BlobStore) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	prefix := fmt.Sprintf("test/%d", time.Now().UnixNano())
+	read := func(key string) (string, error) {
+		body, err := store.Get(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		return string(data), err
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		data string
+	}{
+		{"plain key", prefix + "/receipt.pdf", "%PDF-1.7"},
+		{"nested key", prefix + "/a/b/c/photo.jpg", "\xff\xd8\xff"},
+		{"key to escape", prefix + "/ticket (1) ñ+&.png", "png"},
+		{"empty blob", prefix + "/empty.txt", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.Put(ctx, tt.key, []byte(tt.data), "application/octet-stream"); err != nil {
+				t.Fatalf("Put() = %v", err)
+			}
+			t.Cleanup(func() { store.Delete(context.Background(), tt.key) })
+
+			got, err := read(tt.key)
+			if err != nil {
+				t.Fatalf("Get() = %v", err)
+			}
+			if got != tt.data {
+				t.Errorf("Get() = %q, want %q", got, tt.data)
+			}
+
+			if err := store.Put(ctx, tt.key, []byte("replaced"), "text/plain"); err != nil {
+				t.Fatalf("Put() over a blob = %v", err)
+			}
+			if got, _ := read(tt.key); got != "replaced" {
+				t.Errorf("Get() after replacing = %q, want %q", got, "replaced")
+			}
+
+			if err := store.Delete(ctx, tt.key); err != nil {
+				t.Fatalf("Delete() = %v", err)
+			}
+			if _, err := read(tt.key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() after Delete() = %v, want ErrNotFound", err)
+			}
+		})
+	}
+
+	t.Run("missing blob", func(t *testing.T) {
+		if _, err := read(prefix + "/missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get() = %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, prefix+"/missing"); err != nil {
+			t.Errorf("Delete() = %v, want nil", err)
+		}
+	})
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+
+	for _, key := range []string{"", "/", "../outside", "a/../../outside"} {
+		if err := store.Put(context.Background(), key, []byte("x"), ""); err == nil {
+			t.Errorf("Put(%q) should be refused", key)
+		}
+	}
+}
+
+// TestS3Store runs against a MinIO or any S3 compatible service when
+// TEST_S3_ENDPOINT is set, e.g. the minio service of docker-compose.yml:
+//
+//	TEST_S3_ENDPOINT=http://localhost:9000 TEST_S3_BUCKET=guilliman-attachments \
+//	TEST_S3_ACCESS_KEY=guilliman TEST_S3_SECRET_KEY=secretpassword go test ./internal/storage
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT is not set")
+	}
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("TEST_S3_REGION"),
+		Bucket:    os.Getenv("TEST_S3_BUCKET"),
+		AccessKey: os.Getenv("TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("TEST_S3_SECRET_KEY"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, store)
+}
+
+func TestNewS3StoreConfig(t *testing.T) {
+	valid := S3Config{Endpoint: "http://localhost:9000", Bucket: "b", AccessKey: "a", SecretKey: "s"}
+
+	tests := []struct {
+		name    string
+		change  func(*S3Config)
+		wantErr bool
+	}{
+		{"valid", func(*S3Config) {}, false},
+		{"no endpoint", func(c *S3Config) { c.Endpoint = "" }, true},
+		{"endpoint without host", func(c *S3Config) { c.Endpoint = "localhost" }, true},
+		{"no bucket", func(c *S3Config) { c.Bucket = "" }, true},
+		{"no keys", func(c *S3Config) { c.AccessKey, c.SecretKey = "", "" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.change(&cfg)
+			if _, err := NewS3Store(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("NewS3Store() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
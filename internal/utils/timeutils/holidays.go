@@ -0,0 +1,299 @@
+package timeutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	AdjustNone     = "none"
+	AdjustPrevious = "previous"
+	AdjustNext     = "next"
+)
+
+type Holiday struct {
+	Date time.Time `json:"date"`
+	Name string    `json:"name"`
+}
+
+// HolidayCalendar lists the non-business days (other than weekends) of a year
+type HolidayCalendar interface {
+	Holidays(year int) []Holiday
+}
+
+// CombinedCalendar merges several calendars, e.g. a country and a user's own iCalendar files
+type CombinedCalendar []HolidayCalendar
+
+func (c CombinedCalendar) Holidays(year int) []Holiday {
+	var holidays []Holiday
+	for _, calendar := range c {
+		if calendar != nil {
+			holidays = append(holidays, calendar.Holidays(year)...)
+		}
+	}
+	sort.Slice(holidays, func(i, j int) bool { return holidays[i].Date.Before(holidays[j].Date) })
+	return holidays
+}
+
+// RuleCalendar computes holidays from fixed rules, see CountryCalendar
+type RuleCalendar struct {
+	Country string
+	rules   func(year int) []Holiday
+}
+
+func (c RuleCalendar) Holidays(year int) []Holiday {
+	return c.rules(year)
+}
+
+var countryRules = map[string]func(year int) []Holiday{
+	"SE": swedishHolidays,
+	"CO": colombianHolidays,
+	"ES": spanishHolidays,
+	"US": unitedStatesHolidays,
+}
+
+// CountryCalendar returns the built-in calendar for an ISO 3166 country code
+func CountryCalendar(country string) (RuleCalendar, error) {
+	code := strings.ToUpper(country)
+	rules, ok := countryRules[code]
+	if !ok {
+		return RuleCalendar{}, fmt.Errorf("no holiday calendar for country '%s'", country)
+	}
+	return RuleCalendar{Country: code, rules: rules}, nil
+}
+
+// SupportedCountries lists the country codes with a built-in calendar
+func SupportedCountries() []string {
+	countries := make([]string, 0, len(countryRules))
+	for code := range countryRules {
+		countries = append(countries, code)
+	}
+	sort.Strings(countries)
+	return countries
+}
+
+func ValidateAdjustment(policy string) error {
+	switch policy {
+	case AdjustNone, AdjustPrevious, AdjustNext:
+		return nil
+	}
+	return fmt.Errorf("unknown adjustment '%s', use none, previous or next", policy)
+}
+
+func IsHoliday(calendar HolidayCalendar, day time.Time) bool {
+	if calendar == nil {
+		return false
+	}
+	y, m, d := day.Date()
+	for _, holiday := range calendar.Holidays(y) {
+		hy, hm, hd := holiday.Date.Date()
+		if hy == y && hm == m && hd == d {
+			return true
+		}
+	}
+	return false
+}
+
+func IsBusinessDay(calendar HolidayCalendar, day time.Time) bool {
+	return !IsWeekend(day) && !IsHoliday(calendar, day)
+}
+
+// maxBusinessDaySearch bounds the days AdjustToBusinessDay walks, so that a
+// calendar marking every day as a holiday can't stall it
+const maxBusinessDaySearch = 31
+
+// AdjustToBusinessDay moves a date falling on a weekend or holiday to the
+// previous or next business day according to policy. It is used for pay
+// period boundaries and for due dates, see BusinessDayRule. The date is
+// kept when no business day is found within maxBusinessDaySearch days.
+func AdjustToBusinessDay(day time.Time, calendar HolidayCalendar, policy string) time.Time {
+	step := 0
+	switch policy {
+	case AdjustPrevious:
+		step = -1
+	case AdjustNext:
+		step = 1
+	default:
+		return day
+	}
+
+	holidays := map[int]map[[2]int]bool{}
+	isHoliday := func(d time.Time) bool {
+		if calendar == nil {
+			return false
+		}
+		y, m, dd := d.Date()
+		if _, ok := holidays[y]; !ok {
+			holidays[y] = map[[2]int]bool{}
+			for _, holiday := range calendar.Holidays(y) {
+				if hy, hm, hd := holiday.Date.Date(); hy == y {
+					holidays[y][[2]int{int(hm), hd}] = true
+				}
+			}
+		}
+		return holidays[y][[2]int{int(m), dd}]
+	}
+
+	for i, candidate := 0, day; i <= maxBusinessDaySearch; i, candidate = i+1, candidate.AddDate(0, 0, step) {
+		if !IsWeekend(candidate) && !isHoliday(candidate) {
+			return candidate
+		}
+	}
+	return day
+}
+
+// BusinessDayRule moves scheduled dates off weekends and holidays. The zero
+// value keeps every date.
+type BusinessDayRule struct {
+	Calendar   HolidayCalendar
+	Adjustment string // "none", "previous" or "next" business day
+}
+
+func (r BusinessDayRule) Adjust(day time.Time) time.Time {
+	return AdjustToBusinessDay(day, r.Calendar, r.Adjustment)
+}
+
+// easterSunday uses the anonymous Gregorian algorithm
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), day)
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// nthWeekday returns the n-th weekday of a month, n = -1 is the last one
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	if n < 0 {
+		last := date(year, month+1, 0)
+		return last.AddDate(0, 0, -((int(last.Weekday()) - int(weekday) + 7) % 7))
+	}
+	first := date(year, month, 1)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+// nextMonday moves a date to the following Monday unless it already is one
+func nextMonday(day time.Time) time.Time {
+	return day.AddDate(0, 0, (8-int(day.Weekday()))%7)
+}
+
+// observed moves a fixed holiday on Saturday to Friday and on Sunday to Monday
+func observed(day time.Time) time.Time {
+	switch day.Weekday() {
+	case time.Saturday:
+		return day.AddDate(0, 0, -1)
+	case time.Sunday:
+		return day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+// swedishHolidays includes the de facto bank holidays (Midsummer, Christmas
+// and New Year's Eve) since salaries are not paid on them either
+func swedishHolidays(year int) []Holiday {
+	easter := easterSunday(year)
+	midsummerEve := nthWeekday(year, time.June, time.Friday, 1)
+	for midsummerEve.Day() < 19 {
+		midsummerEve = midsummerEve.AddDate(0, 0, 7)
+	}
+	allSaints := date(year, time.October, 31)
+	for allSaints.Weekday() != time.Saturday {
+		allSaints = allSaints.AddDate(0, 0, 1)
+	}
+
+	return []Holiday{
+		{date(year, time.January, 1), "Nyårsdagen"},
+		{date(year, time.January, 6), "Trettondedag jul"},
+		{easter.AddDate(0, 0, -2), "Långfredagen"},
+		{easter, "Påskdagen"},
+		{easter.AddDate(0, 0, 1), "Annandag påsk"},
+		{date(year, time.May, 1), "Första maj"},
+		{easter.AddDate(0, 0, 39), "Kristi himmelsfärdsdag"},
+		{date(year, time.June, 6), "Sveriges nationaldag"},
+		{midsummerEve, "Midsommarafton"},
+		{midsummerEve.AddDate(0, 0, 1), "Midsommardagen"},
+		{allSaints, "Alla helgons dag"},
+		{date(year, time.December, 24), "Julafton"},
+		{date(year, time.December, 25), "Juldagen"},
+		{date(year, time.December, 26), "Annandag jul"},
+		{date(year, time.December, 31), "Nyårsafton"},
+	}
+}
+
+// colombianHolidays follows Ley 51 de 1983, which moves most holidays to the next Monday
+func colombianHolidays(year int) []Holiday {
+	easter := easterSunday(year)
+
+	return []Holiday{
+		{date(year, time.January, 1), "Año Nuevo"},
+		{nextMonday(date(year, time.January, 6)), "Día de los Reyes Magos"},
+		{nextMonday(date(year, time.March, 19)), "Día de San José"},
+		{easter.AddDate(0, 0, -3), "Jueves Santo"},
+		{easter.AddDate(0, 0, -2), "Viernes Santo"},
+		{date(year, time.May, 1), "Día del Trabajo"},
+		{easter.AddDate(0, 0, 43), "Ascensión del Señor"},
+		{easter.AddDate(0, 0, 64), "Corpus Christi"},
+		{easter.AddDate(0, 0, 71), "Sagrado Corazón"},
+		{nextMonday(date(year, time.June, 29)), "San Pedro y San Pablo"},
+		{date(year, time.July, 20), "Día de la Independencia"},
+		{date(year, time.August, 7), "Batalla de Boyacá"},
+		{nextMonday(date(year, time.August, 15)), "Asunción de la Virgen"},
+		{nextMonday(date(year, time.October, 12)), "Día de la Raza"},
+		{nextMonday(date(year, time.November, 1)), "Todos los Santos"},
+		{nextMonday(date(year, time.November, 11)), "Independencia de Cartagena"},
+		{date(year, time.December, 8), "Inmaculada Concepción"},
+		{date(year, time.December, 25), "Navidad"},
+	}
+}
+
+// spanishHolidays only includes the national holidays, not regional ones
+func spanishHolidays(year int) []Holiday {
+	easter := easterSunday(year)
+
+	return []Holiday{
+		{date(year, time.January, 1), "Año Nuevo"},
+		{date(year, time.January, 6), "Epifanía del Señor"},
+		{easter.AddDate(0, 0, -2), "Viernes Santo"},
+		{date(year, time.May, 1), "Fiesta del Trabajo"},
+		{date(year, time.August, 15), "Asunción de la Virgen"},
+		{date(year, time.October, 12), "Fiesta Nacional de España"},
+		{date(year, time.November, 1), "Todos los Santos"},
+		{date(year, time.December, 6), "Día de la Constitución"},
+		{date(year, time.December, 8), "Inmaculada Concepción"},
+		{date(year, time.December, 25), "Navidad"},
+	}
+}
+
+// unitedStatesHolidays lists the federal holidays on their observed dates
+func unitedStatesHolidays(year int) []Holiday {
+	return []Holiday{
+		{observed(date(year, time.January, 1)), "New Year's Day"},
+		{nthWeekday(year, time.January, time.Monday, 3), "Martin Luther King Jr. Day"},
+		{nthWeekday(year, time.February, time.Monday, 3), "Washington's Birthday"},
+		{nthWeekday(year, time.May, time.Monday, -1), "Memorial Day"},
+		{observed(date(year, time.June, 19)), "Juneteenth"},
+		{observed(date(year, time.July, 4)), "Independence Day"},
+		{nthWeekday(year, time.September, time.Monday, 1), "Labor Day"},
+		{nthWeekday(year, time.October, time.Monday, 2), "Columbus Day"},
+		{observed(date(year, time.November, 11)), "Veterans Day"},
+		{nthWeekday(year, time.November, time.Thursday, 4), "Thanksgiving Day"},
+		{observed(date(year, time.December, 25)), "Christmas Day"},
+	}
+}
@@ -0,0 +1,128 @@
+package timeutils
+
+import (
+	"testing"
+	"time"
+)
+
+// everyDay is a calendar without a single business day
+type everyDay struct{}
+
+func (everyDay) Holidays(year int) []Holiday {
+	var holidays []Holiday
+	for d := day(year, time.January, 1); d.Year() == year; d = d.AddDate(0, 0, 1) {
+		holidays = append(holidays, Holiday{Date: d, Name: "Holiday"})
+	}
+	return holidays
+}
+
+func TestAdjustToBusinessDay(t *testing.T) {
+	sweden, err := CountryCalendar("SE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		day      time.Time
+		calendar HolidayCalendar
+		policy   string
+		want     time.Time
+	}{
+		{"business day", day(2025, time.March, 5), sweden, AdjustNext, day(2025, time.March, 5)},
+		{"saturday to friday", day(2025, time.March, 1), nil, AdjustPrevious, day(2025, time.February, 28)},
+		{"saturday to monday", day(2025, time.March, 1), nil, AdjustNext, day(2025, time.March, 3)},
+		{"sunday to monday", day(2025, time.March, 2), nil, AdjustNext, day(2025, time.March, 3)},
+		{"weekend kept", day(2025, time.March, 1), nil, AdjustNone, day(2025, time.March, 1)},
+		{"no policy", day(2025, time.March, 1), nil, "", day(2025, time.March, 1)},
+		{"holiday without calendar", day(2025, time.December, 25), nil, AdjustNext, day(2025, time.December, 25)},
+		{"christmas forward past the weekend", day(2025, time.December, 25), sweden, AdjustNext, day(2025, time.December, 29)},
+		{"christmas back past the eve", day(2025, time.December, 26), sweden, AdjustPrevious, day(2025, time.December, 23)},
+		{"new year across the year", day(2025, time.December, 31), sweden, AdjustNext, day(2026, time.January, 2)},
+		{"good friday back", day(2025, time.April, 18), sweden, AdjustPrevious, day(2025, time.April, 17)},
+		{"easter monday forward", day(2025, time.April, 21), sweden, AdjustNext, day(2025, time.April, 22)},
+		{"no business day found", day(2025, time.December, 20), everyDay{}, AdjustNext, day(2025, time.December, 20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AdjustToBusinessDay(tt.day, tt.calendar, tt.policy); !got.Equal(tt.want) {
+				t.Errorf("AdjustToBusinessDay(%s) = %s, want %s",
+					tt.day.Format("2006-01-02"), got.Format("2006-01-02"), tt.want.Format("2006-01-02"))
+			}
+		})
+	}
+}
+
+func TestEasterSunday(t *testing.T) {
+	tests := []struct {
+		year int
+		want time.Time
+	}{
+		{2019, day(2019, time.April, 21)},
+		{2024, day(2024, time.March, 31)},
+		{2025, day(2025, time.April, 20)},
+		{2026, day(2026, time.April, 5)},
+		{2038, day(2038, time.April, 25)},
+	}
+
+	for _, tt := range tests {
+		if got := easterSunday(tt.year); !got.Equal(tt.want) {
+			t.Errorf("easterSunday(%d) = %s, want %s", tt.year, got.Format("2006-01-02"), tt.want.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestCountryHolidays(t *testing.T) {
+	tests := []struct {
+		country string
+		day     time.Time
+		want    bool
+	}{
+		// Sweden: midsummer eve is the Friday between June 19 and 25, all
+		// saints the Saturday between October 31 and November 6
+		{"SE", day(2025, time.June, 20), true},
+		{"SE", day(2026, time.June, 19), true},
+		{"SE", day(2025, time.June, 27), false},
+		{"SE", day(2025, time.November, 1), true},
+		{"SE", day(2025, time.May, 29), true}, // Ascension
+		{"SE", day(2025, time.December, 24), true},
+		// Colombia: most holidays move to the following Monday
+		{"CO", day(2025, time.January, 6), true},
+		{"CO", day(2026, time.January, 6), false},
+		{"CO", day(2026, time.January, 12), true},
+		{"CO", day(2025, time.April, 17), true}, // Holy Thursday
+		{"CO", day(2025, time.June, 2), true},   // Ascension, moved to Monday
+		{"CO", day(2025, time.November, 17), true},
+		// Spain: national holidays only
+		{"ES", day(2025, time.April, 18), true},
+		{"ES", day(2025, time.April, 21), false},
+		{"ES", day(2025, time.December, 6), true},
+		// United States: observed dates
+		{"US", day(2025, time.May, 26), true}, // Memorial Day
+		{"US", day(2025, time.November, 27), true},
+		{"US", day(2026, time.July, 3), true}, // July 4 is a Saturday
+		{"US", day(2026, time.July, 4), false},
+		{"US", day(2022, time.June, 20), true}, // Juneteenth on a Sunday
+		{"US", day(2025, time.January, 20), true},
+	}
+
+	for _, tt := range tests {
+		calendar, err := CountryCalendar(tt.country)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := IsHoliday(calendar, tt.day); got != tt.want {
+			t.Errorf("IsHoliday(%s, %s) = %v, want %v", tt.country, tt.day.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
+
+func TestCountryCalendar(t *testing.T) {
+	if _, err := CountryCalendar("se"); err != nil {
+		t.Errorf("lower case codes should be accepted: %v", err)
+	}
+	if _, err := CountryCalendar("XX"); err == nil {
+		t.Error("unknown countries should be refused")
+	}
+}
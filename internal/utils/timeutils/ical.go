@@ -0,0 +1,185 @@
+package timeutils
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type icalEvent struct {
+	Summary string
+	Start   time.Time
+	End     time.Time // exclusive, same as DTEND for all-day events
+	Yearly  bool
+	Until   time.Time
+	Count   int
+}
+
+// ICalCalendar is a holiday calendar read from an iCalendar (.ics) file.
+// Every VEVENT is a day off; multi-day events cover every day until DTEND
+// and FREQ=YEARLY recurrences (with COUNT or UNTIL) are expanded.
+type ICalCalendar struct {
+	Name   string
+	events []icalEvent
+}
+
+// ParseICal reads the VEVENTs of an iCalendar document
+func ParseICal(name string, content string) (ICalCalendar, error) {
+	calendar := ICalCalendar{Name: name}
+
+	var event *icalEvent
+	for _, line := range unfoldICalLines(content) {
+		key, value := splitICalLine(line)
+
+		switch {
+		case key == "BEGIN" && value == "VEVENT":
+			event = &icalEvent{}
+		case key == "END" && value == "VEVENT":
+			if event == nil || event.Start.IsZero() {
+				return ICalCalendar{}, fmt.Errorf("event without DTSTART")
+			}
+			if event.End.IsZero() || !event.End.After(event.Start) {
+				event.End = event.Start.AddDate(0, 0, 1)
+			}
+			calendar.events = append(calendar.events, *event)
+			event = nil
+		case event == nil:
+			continue
+		case key == "SUMMARY":
+			event.Summary = unescapeICalText(value)
+		case key == "DTSTART":
+			start, err := parseICalDate(value)
+			if err != nil {
+				return ICalCalendar{}, err
+			}
+			event.Start = start
+		case key == "DTEND":
+			end, err := parseICalDate(value)
+			if err != nil {
+				return ICalCalendar{}, err
+			}
+			event.End = end
+		case key == "RRULE":
+			if err := parseICalRule(event, value); err != nil {
+				return ICalCalendar{}, err
+			}
+		}
+	}
+
+	if len(calendar.events) == 0 {
+		return ICalCalendar{}, fmt.Errorf("calendar has no events")
+	}
+
+	return calendar, nil
+}
+
+func (c ICalCalendar) EventCount() int {
+	return len(c.events)
+}
+
+func (c ICalCalendar) Holidays(year int) []Holiday {
+	var holidays []Holiday
+
+	for _, event := range c.events {
+		occurrences := []int{0}
+		if event.Yearly {
+			offset := year - event.Start.Year()
+			if offset < 0 || (event.Count > 0 && offset >= event.Count) {
+				continue
+			}
+			occurrences = []int{offset - 1, offset}
+		}
+
+		for _, offset := range occurrences {
+			if offset < 0 {
+				continue
+			}
+			start := event.Start.AddDate(offset, 0, 0)
+			end := event.End.AddDate(offset, 0, 0)
+			if !event.Until.IsZero() && start.After(event.Until) {
+				continue
+			}
+			for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+				if day.Year() == year {
+					holidays = append(holidays, Holiday{Date: day, Name: event.Summary})
+				}
+			}
+		}
+	}
+
+	return holidays
+}
+
+// unfoldICalLines joins continuation lines (starting with a space or tab)
+func unfoldICalLines(content string) []string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitICalLine splits "DTSTART;VALUE=DATE:20250101" into its name and
+// value, parameters are ignored
+func splitICalLine(line string) (string, string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", ""
+	}
+
+	name, _, _ := strings.Cut(line[:colon], ";")
+	return strings.ToUpper(name), line[colon+1:]
+}
+
+// parseICalDate keeps only the calendar date, holidays are whole days
+func parseICalDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid iCalendar date '%s'", value)
+	}
+
+	day, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid iCalendar date '%s': %v", value, err)
+	}
+
+	return day, nil
+}
+
+func parseICalRule(event *icalEvent, value string) error {
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			if strings.ToUpper(val) != "YEARLY" {
+				return fmt.Errorf("unsupported recurrence '%s', only FREQ=YEARLY is supported", val)
+			}
+			event.Yearly = true
+		case "COUNT":
+			count, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("invalid recurrence count '%s'", val)
+			}
+			event.Count = count
+		case "UNTIL":
+			until, err := parseICalDate(val)
+			if err != nil {
+				return err
+			}
+			event.Until = until
+		}
+	}
+	return nil
+}
+
+func unescapeICalText(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
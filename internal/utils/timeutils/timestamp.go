@@ -0,0 +1,97 @@
+package timeutils
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Timestamp is a point in time exchanged as RFC 3339 in JSON. Unix seconds,
+// as a number or a numeric string, are still accepted from older clients.
+type Timestamp struct {
+	time.Time
+}
+
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Time: t}
+}
+
+// ParseTimestamp reads an RFC 3339 date or Unix seconds
+func ParseTimestamp(value string) (Timestamp, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return Timestamp{Time: time.Unix(seconds, 0)}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid date '%s', use RFC 3339 or Unix seconds", value)
+	}
+
+	return Timestamp{Time: t}, nil
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Format(time.RFC3339))
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*t = Timestamp{}
+		return nil
+	}
+
+	var value string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+	} else {
+		value = string(data)
+	}
+
+	if value == "" || value == "0" {
+		*t = Timestamp{}
+		return nil
+	}
+
+	parsed, err := ParseTimestamp(value)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Scan implements sql.Scanner for TIMESTAMPTZ columns
+func (t *Timestamp) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*t = Timestamp{}
+	case time.Time:
+		*t = Timestamp{Time: value}
+	case int64:
+		*t = Timestamp{Time: time.Unix(value, 0)}
+	default:
+		return fmt.Errorf("cannot scan %T into Timestamp", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer, a zero Timestamp is stored as NULL
+func (t Timestamp) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.Time, nil
+}
+
+// In returns the same instant in another location
+func (t Timestamp) In(loc *time.Location) Timestamp {
+	return Timestamp{Time: t.Time.In(loc)}
+}
@@ -1,50 +1,184 @@
 package timeutils
 
 import (
-	"strconv"
+	"fmt"
 	"time"
 )
 
-func CalculatePeriodBoundaries(date time.Time, offset ...int) (int64, int64) {
-	mOffset := 1
-	if len(offset) > 0 {
-		mOffset = offset[0]
+const (
+	PayCycleMonthly         = "monthly"
+	PayCycleLastBusinessDay = "last_business_day"
+	PayCycleBiweekly        = "biweekly"
+	PayCycleWeekly          = "weekly"
+)
+
+const DefaultPayDay = 25
+
+// PayCycle describes when a user gets paid. Only the fields relevant to the
+// cycle type are used:
+//   - monthly: Day of the month (clamped to the month length)
+//   - last_business_day: nothing else, holidays of Calendar are skipped
+//   - biweekly: AnchorDate, any known payday
+//   - weekly: Weekday
+//
+// Paydays falling on a weekend or a holiday of Calendar are moved according
+// to Adjustment ("none", "previous" or "next" business day).
+type PayCycle struct {
+	Type       string
+	Day        int
+	AnchorDate time.Time
+	Weekday    time.Weekday
+	Calendar   HolidayCalendar
+	Adjustment string
+}
+
+func DefaultPayCycle() PayCycle {
+	return PayCycle{Type: PayCycleMonthly, Day: DefaultPayDay}
+}
+
+// MonthlyPayCycle returns a monthly cycle starting on the given day
+func MonthlyPayCycle(day int) PayCycle {
+	return PayCycle{Type: PayCycleMonthly, Day: day}
+}
+
+func (p PayCycle) Validate() error {
+	switch p.Type {
+	case PayCycleMonthly:
+		if p.Day < 1 || p.Day > 31 {
+			return fmt.Errorf("pay day must be between 1 and 31")
+		}
+	case PayCycleLastBusinessDay:
+	case PayCycleBiweekly:
+		if p.AnchorDate.IsZero() {
+			return fmt.Errorf("biweekly pay cycle requires an anchor date")
+		}
+	case PayCycleWeekly:
+		if p.Weekday < time.Sunday || p.Weekday > time.Saturday {
+			return fmt.Errorf("pay weekday must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	default:
+		return fmt.Errorf("unknown pay cycle '%s'", p.Type)
 	}
-	year, month, day := date.Date()
-	location := date.Location()
+	if p.Adjustment != "" {
+		return ValidateAdjustment(p.Adjustment)
+	}
+	return nil
+}
+
+// PeriodRange returns the boundaries of a pay period. index 0 is the period
+// containing ref, -1 the one before it, 1 the one after it and so on.
+// The end is the last instant before the next period starts.
+func PeriodRange(cycle PayCycle, ref time.Time, index int) (startDate time.Time, endDate time.Time) {
+	loc := ref.Location()
+	n := cycle.periodNumber(ref) + index
 
-	start := time.Date(year, month, day+mOffset, 0, 0, 0, 0, location).Unix()
-	end := time.Date(year, month+1, day+mOffset+1, 23, 59, 59, 0, location).Unix()
+	startDate = cycle.payday(n, loc)
+	endDate = cycle.payday(n+1, loc).Add(-time.Nanosecond)
 
-	return start, end
+	return startDate, endDate
 }
 
-func GetSalaryMonthRange(days ...string) (startDate time.Time, endDate time.Time) {
-	var startDay, endDay int
+// EndOnDay returns the last instant of the given day of the month after the
+// one start falls in, clamped to the month length. It ends one-off periods
+// given by their first and last day of the month the way the legacy
+// start_day/end_day parameters did, so end_day=31 with start_day=1 still
+// ends the following month.
+func EndOnDay(start time.Time, day int) time.Time {
+	year, month, _ := start.Date()
+	month++
+	if month > time.December {
+		year, month = year+1, time.January
+	}
+	return time.Date(year, month, clampDay(year, month, day)+1, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+}
+
+// periodNumber finds the number of the period containing ref, starting from
+// an estimate and walking until payday(n) <= ref < payday(n+1).
+func (p PayCycle) periodNumber(ref time.Time) int {
+	loc := ref.Location()
+	var n int
+
+	switch p.Type {
+	case PayCycleBiweekly:
+		n = floorDiv(daysBetween(p.AnchorDate, ref), 14)
+	case PayCycleWeekly:
+		n = floorDiv(daysBetween(weekEpoch, ref), 7)
+	default:
+		n = ref.Year()*12 + int(ref.Month()) - 1
+	}
+
+	for p.payday(n, loc).After(ref) {
+		n--
+	}
+	for !p.payday(n+1, loc).After(ref) {
+		n++
+	}
+
+	return n
+}
 
-	if days[0] != "" {
-		startDay, _ = strconv.Atoi(days[0])
-	} else {
-		startDay = 25
+// payday returns the start of the n-th period of the cycle, adjusted to a business day
+func (p PayCycle) payday(n int, loc *time.Location) time.Time {
+	if p.Type == PayCycleLastBusinessDay {
+		year, month := n/12, time.Month(n%12+1)
+		return lastBusinessDay(year, month, p.Calendar, loc)
 	}
+	return AdjustToBusinessDay(p.nominalPayday(n, loc), p.Calendar, p.Adjustment)
+}
 
-	if days[1] != "" {
-		endDay, _ = strconv.Atoi(days[1])
-	} else {
-		endDay = 24
+// nominalPayday returns the n-th payday before any business day adjustment
+func (p PayCycle) nominalPayday(n int, loc *time.Location) time.Time {
+	switch p.Type {
+	case PayCycleBiweekly:
+		y, m, d := p.AnchorDate.Date()
+		return time.Date(y, m, d+14*n, 0, 0, 0, 0, loc)
+	case PayCycleWeekly:
+		y, m, d := weekEpoch.Date()
+		offset := (int(p.Weekday) - int(weekEpoch.Weekday()) + 7) % 7
+		return time.Date(y, m, d+7*n+offset, 0, 0, 0, 0, loc)
+	default:
+		year, month := n/12, time.Month(n%12+1)
+		return time.Date(year, month, clampDay(year, month, p.Day), 0, 0, 0, 0, loc)
 	}
+}
+
+// weekEpoch is an arbitrary Monday used to number weeks
+var weekEpoch = time.Date(1970, time.January, 5, 0, 0, 0, 0, time.UTC)
+
+func lastBusinessDay(year int, month time.Month, calendar HolidayCalendar, loc *time.Location) time.Time {
+	day := time.Date(year, month, daysIn(year, month), 0, 0, 0, 0, loc)
+	return AdjustToBusinessDay(day, calendar, AdjustPrevious)
+}
 
-	now := time.Now()
+func IsWeekend(day time.Time) bool {
+	return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+}
 
-	if now.Day() >= startDay {
-		// Current period: salaryDay of this month to endMonthDay of the next month
-		startDate = time.Date(now.Year(), now.Month(), startDay, 0, 0, 0, 0, now.Location())
-		endDate = time.Date(now.Year(), now.Month()+1, endDay, 23, 59, 59, 999999999, now.Location())
-	} else {
-		// Previous period: salaryDay of the previous month to endMonthDay of this month
-		startDate = time.Date(now.Year(), now.Month()-1, startDay, 0, 0, 0, 0, now.Location())
-		endDate = time.Date(now.Year(), now.Month(), endDay, 23, 59, 59, 999999999, now.Location())
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// clampDay limits a day of the month to the length of the month
+func clampDay(year int, month time.Month, day int) int {
+	if last := daysIn(year, month); day > last {
+		return last
 	}
+	return day
+}
 
-	return startDate, endDate
+// daysBetween counts calendar days from a to b, ignoring the time of day
+func daysBetween(a, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	da := time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC)
+	db := time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC)
+	return int(db.Sub(da).Hours() / 24)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
 }
@@ -0,0 +1,212 @@
+package timeutils
+
+import (
+	"testing"
+	"time"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestPeriodRange(t *testing.T) {
+	sweden, err := CountryCalendar("SE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		cycle     PayCycle
+		ref       time.Time
+		index     int
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "monthly",
+			cycle:     MonthlyPayCycle(25),
+			ref:       day(2025, time.March, 10),
+			wantStart: day(2025, time.February, 25),
+			wantEnd:   day(2025, time.March, 25),
+		},
+		{
+			name:      "monthly on the payday",
+			cycle:     MonthlyPayCycle(25),
+			ref:       day(2025, time.March, 25),
+			wantStart: day(2025, time.March, 25),
+			wantEnd:   day(2025, time.April, 25),
+		},
+		{
+			name:      "monthly just before the payday",
+			cycle:     MonthlyPayCycle(25),
+			ref:       day(2025, time.March, 25).Add(-time.Nanosecond),
+			wantStart: day(2025, time.February, 25),
+			wantEnd:   day(2025, time.March, 25),
+		},
+		{
+			name:      "monthly previous period across the year",
+			cycle:     MonthlyPayCycle(25),
+			ref:       day(2025, time.January, 10),
+			index:     -1,
+			wantStart: day(2024, time.November, 25),
+			wantEnd:   day(2024, time.December, 25),
+		},
+		{
+			name:      "monthly next period",
+			cycle:     MonthlyPayCycle(25),
+			ref:       day(2025, time.December, 26),
+			index:     1,
+			wantStart: day(2026, time.January, 25),
+			wantEnd:   day(2026, time.February, 25),
+		},
+		{
+			name:      "monthly day clamped to short months",
+			cycle:     MonthlyPayCycle(31),
+			ref:       day(2025, time.February, 15),
+			wantStart: day(2025, time.January, 31),
+			wantEnd:   day(2025, time.February, 28),
+		},
+		{
+			name:      "monthly day clamped in leap years",
+			cycle:     MonthlyPayCycle(30),
+			ref:       day(2024, time.March, 1),
+			wantStart: day(2024, time.February, 29),
+			wantEnd:   day(2024, time.March, 30),
+		},
+		{
+			name:      "monthly moved to the previous business day",
+			cycle:     PayCycle{Type: PayCycleMonthly, Day: 25, Calendar: sweden, Adjustment: AdjustPrevious},
+			ref:       day(2025, time.May, 24),
+			wantStart: day(2025, time.May, 23),
+			wantEnd:   day(2025, time.June, 25),
+		},
+		{
+			name:      "monthly moved to the next business day",
+			cycle:     PayCycle{Type: PayCycleMonthly, Day: 25, Calendar: sweden, Adjustment: AdjustNext},
+			ref:       day(2025, time.May, 25),
+			wantStart: day(2025, time.April, 25),
+			wantEnd:   day(2025, time.May, 26),
+		},
+		{
+			name:      "last business day",
+			cycle:     PayCycle{Type: PayCycleLastBusinessDay},
+			ref:       day(2025, time.June, 10),
+			wantStart: day(2025, time.May, 30),
+			wantEnd:   day(2025, time.June, 30),
+		},
+		{
+			name:      "last business day before holidays",
+			cycle:     PayCycle{Type: PayCycleLastBusinessDay, Calendar: sweden},
+			ref:       day(2026, time.January, 15),
+			wantStart: day(2025, time.December, 30),
+			wantEnd:   day(2026, time.January, 30),
+		},
+		{
+			name:      "biweekly",
+			cycle:     PayCycle{Type: PayCycleBiweekly, AnchorDate: day(2025, time.January, 3)},
+			ref:       day(2025, time.January, 20),
+			wantStart: day(2025, time.January, 17),
+			wantEnd:   day(2025, time.January, 31),
+		},
+		{
+			name:      "biweekly before the anchor",
+			cycle:     PayCycle{Type: PayCycleBiweekly, AnchorDate: day(2025, time.January, 3)},
+			ref:       day(2024, time.December, 25),
+			wantStart: day(2024, time.December, 20),
+			wantEnd:   day(2025, time.January, 3),
+		},
+		{
+			name:      "weekly",
+			cycle:     PayCycle{Type: PayCycleWeekly, Weekday: time.Friday},
+			ref:       day(2025, time.March, 12),
+			wantStart: day(2025, time.March, 7),
+			wantEnd:   day(2025, time.March, 14),
+		},
+		{
+			name:      "weekly on the payday",
+			cycle:     PayCycle{Type: PayCycleWeekly, Weekday: time.Sunday},
+			ref:       day(2025, time.March, 16),
+			wantStart: day(2025, time.March, 16),
+			wantEnd:   day(2025, time.March, 23),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := PeriodRange(tt.cycle, tt.ref, tt.index)
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("start = %v, want %v", start, tt.wantStart)
+			}
+			// The end is the last instant before the next period
+			if wantEnd := tt.wantEnd.Add(-time.Nanosecond); !end.Equal(wantEnd) {
+				t.Errorf("end = %v, want %v", end, wantEnd)
+			}
+		})
+	}
+}
+
+func TestPeriodRangeKeepsTheLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/Bogota")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+
+	// 2025-03-25 02:00 UTC is still the 24th in Bogotá
+	ref := time.Date(2025, time.March, 25, 2, 0, 0, 0, time.UTC).In(loc)
+	start, _ := PeriodRange(MonthlyPayCycle(25), ref, 0)
+	if want := time.Date(2025, time.February, 25, 0, 0, 0, 0, loc); !start.Equal(want) {
+		t.Errorf("start = %v, want %v", start, want)
+	}
+}
+
+func TestPayCycleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cycle   PayCycle
+		wantErr bool
+	}{
+		{"default", DefaultPayCycle(), false},
+		{"monthly day 0", MonthlyPayCycle(0), true},
+		{"monthly day 32", MonthlyPayCycle(32), true},
+		{"last business day", PayCycle{Type: PayCycleLastBusinessDay}, false},
+		{"biweekly without anchor", PayCycle{Type: PayCycleBiweekly}, true},
+		{"weekly", PayCycle{Type: PayCycleWeekly, Weekday: time.Saturday}, false},
+		{"weekly out of range", PayCycle{Type: PayCycleWeekly, Weekday: 7}, true},
+		{"unknown type", PayCycle{Type: "yearly"}, true},
+		{"unknown adjustment", PayCycle{Type: PayCycleMonthly, Day: 1, Adjustment: "closest"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cycle.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEndOnDay(t *testing.T) {
+	tests := []struct {
+		name  string
+		start time.Time
+		day   int
+		want  time.Time
+	}{
+		{"the following month", day(2025, time.March, 25), 24, day(2025, time.April, 25)},
+		{"end day after the start day", day(2025, time.March, 1), 31, day(2025, time.May, 1)},
+		{"the start day itself", day(2025, time.March, 10), 10, day(2025, time.April, 11)},
+		{"clamped to a short month", day(2025, time.January, 31), 31, day(2025, time.March, 1)},
+		{"clamped in leap years", day(2024, time.January, 30), 30, day(2024, time.March, 1)},
+		{"across the year", day(2025, time.December, 25), 24, day(2026, time.January, 25)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The end is the last instant of the day
+			if got, want := EndOnDay(tt.start, tt.day), tt.want.Add(-time.Nanosecond); !got.Equal(want) {
+				t.Errorf("EndOnDay(%s, %d) = %v, want %v", tt.start.Format("2006-01-02"), tt.day, got, want)
+			}
+		})
+	}
+}